@@ -2,10 +2,20 @@ package main
 
 import (
 	"GoExamComments/internal/config"
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/logger"
+	"GoExamComments/internal/ratelimit"
 	"GoExamComments/internal/server"
 	"GoExamComments/internal/stopsignal"
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/cache"
+	"GoExamComments/internal/storage/memory"
 	"GoExamComments/internal/storage/mongodb"
+	"GoExamComments/internal/storage/postgres"
+	"GoExamComments/internal/storage/sqlite"
+	"context"
+	"log"
 	"log/slog"
 )
 
@@ -16,14 +26,89 @@ func main() {
 	cfg := config.MustLoad()
 	slog.Debug("config file and logger initialized")
 
-	// Инициализируем базу данных.
-	st := mongodb.New(cfg)
+	// Инициализируем базу данных выбранного в конфиге типа.
+	var st storage.DB
+	switch cfg.Storage {
+	case "", "mongodb":
+		st = mongodb.New(cfg)
+	case "postgres":
+		st = postgres.New(cfg)
+	case "sqlite":
+		st = sqlite.New(cfg)
+	case "memory":
+		st = memory.New()
+	default:
+		log.Fatalf("failed to init storage: unknown storage: %s", cfg.Storage)
+	}
 	slog.Debug("storage initialized")
 	defer st.Close()
+	backend := st
+
+	// Включаем кэш комментариев популярных постов, если он задан в конфиге.
+	if cfg.Cache.Size > 0 {
+		st = cache.New(cfg, st)
+		slog.Debug("storage cache initialized")
+	}
+
+	// Инициализируем брокер событий о новых комментариях. События поступают
+	// из записи комментариев этим экземпляром или из потока изменений БД.
+	broker := events.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	switch cfg.Events.Source {
+	case "", "local":
+		st = events.Wrap(st, broker)
+	case "mongodb":
+		src, ok := backend.(events.Source)
+		if !ok {
+			log.Fatalf("failed to init events: storage %s has no change stream", cfg.Storage)
+		}
+		go broker.Run(ctx, src)
+	default:
+		log.Fatalf("failed to init events: unknown source: %s", cfg.Events.Source)
+	}
+	slog.Debug("events broker initialized")
+
+	// Инициализируем хранилище корзин для ограничения частоты запросов.
+	// Общее хранилище в MongoDB нужно, если запущено несколько экземпляров
+	// сервиса.
+	var limits ratelimit.Store
+	switch cfg.LimitStore {
+	case "", "memory":
+		limits = ratelimit.NewMemory()
+	case "mongodb":
+		l, ok := backend.(ratelimit.Store)
+		if !ok {
+			log.Fatalf("failed to init rate limit: storage %s cannot store limits", cfg.Storage)
+		}
+		limits = l
+	default:
+		log.Fatalf("failed to init rate limit: unknown store: %s", cfg.LimitStore)
+	}
+	slog.Debug("rate limit store initialized")
+
+	// Инициализируем хранилище ключей идемпотентности добавления
+	// комментариев.
+	var keys idempotency.Store
+	switch cfg.KeyStore {
+	case "", "memory":
+		keys = idempotency.NewMemory()
+	case "mongodb":
+		k, ok := backend.(idempotency.Store)
+		if !ok {
+			log.Fatalf("failed to init idempotency: storage %s cannot store keys", cfg.Storage)
+		}
+		keys = k
+	default:
+		log.Fatalf("failed to init idempotency: unknown store: %s", cfg.KeyStore)
+	}
+	slog.Debug("idempotency key store initialized")
 
 	// Инициализируем сервер, объявляем обработчики API и запускаем сервер.
 	srv := server.New(cfg)
-	srv.API(cfg, st)
+	srv.Idempotency(cfg, keys)
+	srv.RateLimit(cfg, limits)
+	srv.API(cfg, st, broker)
 	srv.Middleware()
 	srv.Start()
 	slog.Info("Server started")
@@ -9,25 +9,115 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Структура конфига
+// Структура конфига. Storage - тип хранилища: "mongodb" (по умолчанию),
+// "postgres", "sqlite" или "memory". StoragePath - адрес подключения
+// к выбранному хранилищу, для SQLite - путь к файлу БД.
 type Config struct {
+	Storage       string   `yaml:"storage"`
 	StoragePath   string   `yaml:"storage_path"`
 	StorageUser   string   `yaml:"storage_user"`
 	StoragePasswd string   `yaml:"storage_passwd"`
 	ContentLength int      `yaml:"content_length"`
 	CensorList    []string `yaml:"censor_list"`
+	OrphanPolicy  string   `yaml:"orphan_policy"`
+	Moderation    `yaml:"moderation"`
+	Cache         `yaml:"cache"`
+	Events        `yaml:"events"`
+	Auth          `yaml:"auth"`
+	Idempotency   `yaml:"idempotency"`
 	HTTPServer    `yaml:"http_server"`
 }
+
+// Auth - настройки проверки JWT токенов. Secret - общий ключ для HS256,
+// PublicKey - путь к PEM файлу с открытым ключом RSA для RS256, JWKS -
+// путь к файлу с набором открытых ключей RSA в формате JWKS. Issuer
+// и Audience, если заданы, сверяются с утверждениями iss и aud токена.
+// Если ни один ключ не задан, то токены не проверяются.
+type Auth struct {
+	Secret    string `yaml:"secret"`
+	PublicKey string `yaml:"public_key"`
+	JWKS      string `yaml:"jwks"`
+	Issuer    string `yaml:"issuer"`
+	Audience  string `yaml:"audience"`
+}
+
+// Idempotency - настройки ключей идемпотентности запросов на добавление
+// комментария. KeyTTL - время хранения результата запроса с ключом, 0 -
+// ключи не учитываются. KeyStore - хранилище результатов: "memory" (по
+// умолчанию) или "mongodb", общее для всех экземпляров сервиса.
+type Idempotency struct {
+	KeyTTL   time.Duration `yaml:"key_ttl"`
+	KeyStore string        `yaml:"key_store"`
+}
+
+// Events - настройки рассылки новых комментариев. Source - источник
+// событий: "local" (по умолчанию) - комментарии, записанные этим
+// экземпляром сервиса, "mongodb" - поток изменений MongoDB, общий для всех
+// экземпляров.
+type Events struct {
+	Source string `yaml:"source"`
+}
+
+// Cache - настройки кэша комментариев популярных постов. Size - число
+// постов в кэше, 0 - кэш отключен. TTL - время жизни записи поста.
+type Cache struct {
+	Size int           `yaml:"size"`
+	TTL  time.Duration `yaml:"ttl"`
+}
+
+// Moderation - настройки проверок комментариев перед записью в БД.
+// Действие задается строкой "reject", "mask" или "flag". Статус по
+// умолчанию - "approved" или "pending". ReportThreshold - число жалоб
+// разных читателей, после которого комментарий скрывается до проверки
+// модератором, 0 - комментарии по жалобам не скрываются.
+type Moderation struct {
+	DefaultStatus   string `yaml:"default_status"`
+	CensorAction    string `yaml:"censor_action"`
+	MaxLinks        int    `yaml:"max_links"`
+	LinksAction     string `yaml:"links_action"`
+	Rules           []Rule `yaml:"rules"`
+	ReportThreshold int    `yaml:"report_threshold"`
+}
+
+// Rule - правило модерации на основе регулярного выражения.
+type Rule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"`
+}
+
+// HTTPServer - настройки HTTP сервера. ReadLimit и WriteLimit - ограничения
+// частоты запросов одного клиента на чтение и на изменение данных.
+// LimitStore - хранилище состояния ограничений: "memory" (по умолчанию)
+// или "mongodb", общее для всех экземпляров сервиса.
 type HTTPServer struct {
 	Address      string        `yaml:"address"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	ReadLimit    RateLimit     `yaml:"read_limit"`
+	WriteLimit   RateLimit     `yaml:"write_limit"`
+	LimitStore   string        `yaml:"limit_store"`
+	// TrustedProxies - IP адреса и подсети CIDR прокси, которым можно
+	// верить в заголовке X-Real-IP.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// RateLimit - ограничение частоты запросов клиента по алгоритму корзины
+// токенов. Rate - число запросов в секунду, Burst - размер корзины, по
+// умолчанию - число запросов за секунду. Rate 0 - без ограничения.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
 // MustLoad - инициализирует данные из конфиг файла. Путь к файлу берет из
-// переменной окружения COMMENTS_CONFIG_PATH, пароль для доступа к БД - из переменной
-// окружения MONGO_DB_PASSWD. Если не удается, то завершает приложение с ошибкой.
+// переменной окружения COMMENTS_CONFIG_PATH, пароль для доступа к БД -
+// из переменной окружения MONGO_DB_PASSWD или POSTGRES_PASSWD в зависимости
+// от типа хранилища. Для SQLite и хранилища в памяти пароль не нужен.
+// Ключ для проверки токенов HS256 берется из переменной окружения
+// COMMENTS_JWT_SECRET, если она задана. Если не удается, то завершает
+// приложение с ошибкой.
 func MustLoad() *Config {
 	configPath := os.Getenv("COMMENTS_CONFIG_PATH")
 	if configPath == "" {
@@ -49,9 +139,21 @@ func MustLoad() *Config {
 		log.Fatalf("cannot decode config file: %s, %s", configPath, err)
 	}
 
-	cfg.StoragePasswd = os.Getenv("MONGO_DB_PASSWD")
+	if secret := os.Getenv("COMMENTS_JWT_SECRET"); secret != "" {
+		cfg.Secret = secret
+	}
+
+	if cfg.Storage == "sqlite" || cfg.Storage == "memory" {
+		return &cfg
+	}
+
+	passwdEnv := "MONGO_DB_PASSWD"
+	if cfg.Storage == "postgres" {
+		passwdEnv = "POSTGRES_PASSWD"
+	}
+	cfg.StoragePasswd = os.Getenv(passwdEnv)
 	if cfg.StoragePasswd == "" {
-		log.Printf("MONGO_DB_PASSWD is not set\n")
+		log.Printf("%s is not set\n", passwdEnv)
 	}
 
 	return &cfg
@@ -0,0 +1,127 @@
+// Пакет events содержит брокер событий об изменении комментариев. Брокер
+// рассылает события подписчикам внутри процесса, например открытым потокам
+// Server-Sent Events и WebSocket соединениям.
+package events
+
+import (
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/storage"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// bufSize - размер буфера канала подписчика. Подписчик, который
+// не успевает читать события, отключается.
+const bufSize = 64
+
+// retryDelay - пауза перед повторным подключением к внешнему источнику
+// событий после ошибки.
+const retryDelay = time.Second * 5
+
+// Source - внешний источник событий об изменении комментариев, например
+// поток изменений MongoDB. Watch передает в publish каждое событие
+// и блокируется до отмены контекста или ошибки.
+type Source interface {
+	Watch(ctx context.Context, publish func(storage.Event)) error
+}
+
+// Broker - брокер событий об изменении комментариев. Подписка оформляется
+// на комментарии одного поста.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan storage.Event]struct{}
+}
+
+// New - конструктор брокера.
+func New() *Broker {
+	return &Broker{subs: make(map[string]map[chan storage.Event]struct{})}
+}
+
+// Subscribe подписывается на события по комментариям к посту. Возвращает
+// канал событий и функцию отмены подписки. Канал закрывается при отмене
+// подписки или если подписчик не успевает читать события.
+func (b *Broker) Subscribe(post string) (<-chan storage.Event, func()) {
+	ch := make(chan storage.Event, bufSize)
+
+	b.mu.Lock()
+	if b.subs[post] == nil {
+		b.subs[post] = make(map[chan storage.Event]struct{})
+	}
+	b.subs[post][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.remove(post, ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Publish рассылает событие подписчикам поста комментария. Публикация
+// не блокируется: подписчик с заполненным буфером отключается, клиент
+// может переподключиться и получить пропущенные комментарии из хранилища.
+func (b *Broker) Publish(ev storage.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	post := ev.Comment.PostID
+	for ch := range b.subs[post] {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn("slow subscriber disconnected", slog.String("post_id", post))
+			b.remove(post, ch)
+		}
+	}
+}
+
+// Close отключает всех подписчиков. Вызывается при остановке сервера,
+// чтобы открытые потоки событий завершились.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for post, subs := range b.subs {
+		for ch := range subs {
+			b.remove(post, ch)
+		}
+	}
+}
+
+// remove удаляет подписчика и закрывает его канал, если он еще
+// не удален. Вызывается под блокировкой.
+func (b *Broker) remove(post string, ch chan storage.Event) {
+	subs := b.subs[post]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(b.subs, post)
+	}
+}
+
+// Run публикует события из внешнего источника до отмены контекста.
+// После ошибки источника подключается к нему повторно.
+func (b *Broker) Run(ctx context.Context, src Source) {
+	for {
+		err := src.Watch(ctx, b.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("event source stopped, reconnecting", logger.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Пакет events содержит брокер событий об изменении комментариев. Брокер
+// рассылает события подписчикам внутри процесса, например открытым потокам
+// Server-Sent Events и WebSocket соединениям.
+
+package events
+
+import (
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/memory"
+	"GoExamComments/internal/storage/mongodb"
+	"context"
+	"testing"
+	"time"
+)
+
+// Поток изменений MongoDB - внешний источник событий.
+var _ Source = (*mongodb.Storage)(nil)
+
+// receive читает событие из канала подписчика. Если события нет,
+// то возвращает false.
+func receive(ch <-chan storage.Event) (storage.Event, bool) {
+	select {
+	case ev, ok := <-ch:
+		return ev, ok
+	case <-time.After(100 * time.Millisecond):
+		return storage.Event{}, false
+	}
+}
+
+// event возвращает событие о новом комментарии.
+func event(id, post string) storage.Event {
+	return storage.Event{Type: storage.EventNew, Comment: storage.Comment{ID: id, PostID: post}}
+}
+
+func TestBroker(t *testing.T) {
+	b := New()
+	post, other := storage.NewID(), storage.NewID()
+
+	sub, cancel := b.Subscribe(post)
+	b.Publish(event("1", other))
+	b.Publish(event("2", post))
+
+	ev, ok := receive(sub)
+	if !ok || ev.Comment.ID != "2" {
+		t.Errorf("Broker.Publish() event = %+v, want comment 2", ev)
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-sub; ok {
+		t.Error("Broker.Subscribe() channel is open after cancel")
+	}
+	if len(b.subs) != 0 {
+		t.Errorf("Broker.subs len = %d, want 0", len(b.subs))
+	}
+}
+
+func TestBroker_Slow(t *testing.T) {
+	b := New()
+	post := storage.NewID()
+
+	slow, _ := b.Subscribe(post)
+	for i := 0; i <= bufSize; i++ {
+		b.Publish(event("", post))
+	}
+
+	// Подписчик получает события из буфера, затем канал закрывается.
+	for i := 0; i < bufSize; i++ {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Error("Broker.Publish() slow subscriber is not disconnected")
+	}
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := New()
+	sub, cancel := b.Subscribe(storage.NewID())
+	defer cancel()
+
+	b.Close()
+	if _, ok := <-sub; ok {
+		t.Error("Broker.Close() channel is open")
+	}
+}
+
+// source - источник событий, который публикует переданные события
+// и ждет отмены контекста.
+type source []storage.Event
+
+func (s source) Watch(ctx context.Context, publish func(storage.Event)) error {
+	for _, ev := range s {
+		publish(ev)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBroker_Run(t *testing.T) {
+	b := New()
+	post := storage.NewID()
+	sub, cancel := b.Subscribe(post)
+	defer cancel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx, source{event("1", post)})
+		close(done)
+	}()
+
+	ev, ok := receive(sub)
+	if !ok || ev.Comment.ID != "1" {
+		t.Errorf("Broker.Run() event = %+v, want comment 1", ev)
+	}
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Broker.Run() does not stop after cancel")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	b := New()
+	st := Wrap(memory.New(), b)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	sub, cancel := b.Subscribe(post)
+	defer cancel()
+
+	// next проверяет тип следующего события и возвращает его комментарий.
+	next := func(want string) storage.Comment {
+		t.Helper()
+		ev, ok := receive(sub)
+		if !ok || ev.Type != want {
+			t.Fatalf("event = %+v, want %s event", ev, want)
+		}
+		return ev.Comment
+	}
+
+	id, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "text"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	com := next(storage.EventNew)
+	if com.ID != id || com.PubTime.IsZero() || com.Status != storage.StatusApproved {
+		t.Errorf("Storage.AddComment() event = %+v, want stored comment %s", com, id)
+	}
+
+	// Комментарии на проверке модератором не публикуются.
+	pending, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "text", Status: storage.StatusPending})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ev, ok := receive(sub); ok {
+		t.Fatalf("Storage.AddComment() event = %+v, want no event", ev)
+	}
+	st.SetStatus(ctx, pending, storage.StatusApproved)
+	next(storage.EventNew)
+	// Повторное одобрение не публикует комментарий еще раз.
+	st.SetStatus(ctx, pending, storage.StatusApproved)
+	if ev, ok := receive(sub); ok {
+		t.Fatalf("Storage.SetStatus() event = %+v, want no event", ev)
+	}
+
+	st.UpdateComment(ctx, storage.Comment{ID: id, Content: "edited"})
+	if com := next(storage.EventEdited); com.Content != "edited" {
+		t.Errorf("Storage.UpdateComment() event content = %q, want %q", com.Content, "edited")
+	}
+	st.UpdateComment(ctx, storage.Comment{ID: id, Content: "flagged", Status: storage.StatusPending})
+	if com := next(storage.EventDeleted); com.Content != "" {
+		t.Errorf("Storage.UpdateComment() event content = %q, want empty", com.Content)
+	}
+
+	// Комментарий, скрытый по жалобам, публикуется как удаленный.
+	st.Report(ctx, pending, "alice", storage.ReasonSpam, 2)
+	if ev, ok := receive(sub); ok {
+		t.Fatalf("Storage.Report() event = %+v, want no event", ev)
+	}
+	st.Report(ctx, pending, "bob", storage.ReasonSpam, 2)
+	if com := next(storage.EventDeleted); com.Content != "" {
+		t.Errorf("Storage.Report() event content = %q, want empty", com.Content)
+	}
+
+	st.SetStatus(ctx, pending, storage.StatusApproved)
+	next(storage.EventNew)
+	st.DeleteComment(ctx, pending)
+	next(storage.EventDeleted)
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/storage"
+	"context"
+	"log/slog"
+)
+
+// Storage - обертка над storage.DB, которая публикует в брокер события
+// об изменении видимых читателям комментариев после записи изменений
+// в хранилище. Используется, если события не поступают из внешнего
+// источника.
+type Storage struct {
+	storage.DB
+	broker *Broker
+}
+
+// Wrap - конструктор обертки над хранилищем.
+func Wrap(db storage.DB, b *Broker) *Storage {
+	return &Storage{DB: db, broker: b}
+}
+
+// Unwrap возвращает хранилище, над которым сделана обертка.
+func (s *Storage) Unwrap() storage.DB {
+	return s.DB
+}
+
+// visible сообщает, виден ли комментарий читателям.
+func visible(com storage.Comment) bool {
+	return com.Status != storage.StatusPending && com.Status != storage.StatusRejected
+}
+
+// hidden возвращает событие об удалении комментария, который скрыт
+// от читателей или удален. Текст и автор комментария в событие
+// не попадают.
+func hidden(com storage.Comment) storage.Event {
+	return storage.Event{Type: storage.EventDeleted, Comment: storage.Strip(com)}
+}
+
+// AddComment записывает комментарий в хранилище и публикует событие о новом
+// комментарии. Комментарий перечитывается из хранилища, чтобы событие
+// содержало дату публикации и статус. Комментарии, которые ожидают
+// проверки модератором, не публикуются.
+func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string, error) {
+	id, err := s.DB.AddComment(ctx, com)
+	if err != nil {
+		return id, err
+	}
+
+	comms, err := s.DB.Subtree(ctx, id, 0)
+	if err != nil {
+		slog.Debug("new comment is not published", slog.String("id", id), logger.Err(err))
+		return id, nil
+	}
+	s.broker.Publish(storage.Event{Type: storage.EventNew, Comment: comms[0]})
+
+	return id, nil
+}
+
+// SetStatus изменяет статус комментария. Одобренный комментарий публикуется
+// как новый, если до этого он не был одобрен, отклоненный - как удаленный
+// без текста.
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	// Ошибку чтения вернет SetStatus, а без прежнего статуса комментарий
+	// считается неодобренным.
+	prev, _ := s.DB.Comment(ctx, id)
+
+	com, err := s.DB.SetStatus(ctx, id, status)
+	if err != nil {
+		return com, err
+	}
+
+	switch com.Status {
+	case storage.StatusApproved:
+		if prev.Status != storage.StatusApproved {
+			s.broker.Publish(storage.Event{Type: storage.EventNew, Comment: com})
+		}
+	case storage.StatusRejected:
+		s.broker.Publish(hidden(com))
+	}
+	return com, nil
+}
+
+// UpdateComment изменяет комментарий и публикует событие об изменении,
+// если комментарий виден читателям. Комментарий, отправленный после
+// изменения на проверку модератору, публикуется как удаленный без текста.
+func (s *Storage) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	upd, err := s.DB.UpdateComment(ctx, com)
+	if err != nil {
+		return upd, err
+	}
+
+	if visible(upd) {
+		s.broker.Publish(storage.Event{Type: storage.EventEdited, Comment: upd})
+	} else {
+		s.broker.Publish(hidden(upd))
+	}
+	return upd, nil
+}
+
+// Report записывает жалобу на комментарий. Комментарий, скрытый после
+// жалобы до проверки модератором, публикуется как удаленный без текста.
+// Скрытые комментарии жалобы не принимают, поэтому скрыть комментарий
+// могла только эта жалоба.
+func (s *Storage) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	com, err := s.DB.Report(ctx, id, user, reason, threshold)
+	if err != nil {
+		return com, err
+	}
+
+	if !visible(com) {
+		s.broker.Publish(hidden(com))
+	}
+	return com, nil
+}
+
+// DeleteComment удаляет комментарий и публикует событие об удалении.
+func (s *Storage) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	com, err := s.DB.DeleteComment(ctx, id)
+	if err != nil {
+		return com, err
+	}
+
+	s.broker.Publish(hidden(com))
+	return com, nil
+}
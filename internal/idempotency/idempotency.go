@@ -0,0 +1,154 @@
+// Пакет для повторного выполнения запросов с ключом идемпотентности.
+package idempotency
+
+import (
+	"GoExamComments/internal/config"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// MaxKey - максимальная длина ключа идемпотентности.
+const MaxKey = 255
+
+// Pending - время хранения ключа запроса, который еще выполняется. Если
+// экземпляр сервиса остановится, не записав результат, то ключ
+// освободится через это время, а не через время хранения результата.
+const Pending = time.Minute
+
+// Record - результат запроса с ключом идемпотентности. Hash - отпечаток
+// тела запроса, Status - код ответа, 0 - запрос еще выполняется, ID - ID
+// созданного объекта.
+type Record struct {
+	Hash   string `bson:"hash"`
+	Status int    `bson:"status"`
+	ID     string `bson:"id"`
+}
+
+// Store - хранилище результатов запросов с ключами идемпотентности.
+type Store interface {
+	// ReserveKey записывает ключ запроса, который начал выполняться, на
+	// время ttl, если ключа еще нет. Если ключ уже есть, то возвращает его
+	// запись и false.
+	ReserveKey(ctx context.Context, key string, rec Record, ttl time.Duration) (Record, bool, error)
+	// SaveKey записывает результат выполненного запроса на время ttl.
+	SaveKey(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// ReleaseKey удаляет ключ запроса, который не удалось выполнить, чтобы
+	// клиент мог его повторить.
+	ReleaseKey(ctx context.Context, key string) error
+}
+
+// Keys - ключи идемпотентности с результатами запросов, которые хранятся
+// заданное в конфиге время.
+type Keys struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New - конструктор ключей идемпотентности. Если время хранения не задано
+// в конфиге, то возвращает nil: ключи не учитываются.
+func New(cfg *config.Config, store Store) *Keys {
+	if cfg.KeyTTL <= 0 {
+		return nil
+	}
+	return &Keys{store: store, ttl: cfg.KeyTTL}
+}
+
+// Reserve записывает ключ запроса с отпечатком тела hash на время Pending,
+// если ключа еще нет или его время хранения истекло. Если ключ уже есть,
+// то возвращает его запись и false.
+func (k *Keys) Reserve(ctx context.Context, key, hash string) (Record, bool, error) {
+	return k.store.ReserveKey(ctx, key, Record{Hash: hash}, min(Pending, k.ttl))
+}
+
+// Save записывает результат выполненного запроса с ключом. Результат
+// записывается и после отмены ctx, например когда клиент разорвал
+// соединение: иначе ключ остался бы занятым выполняющимся запросом.
+func (k *Keys) Save(ctx context.Context, key, hash string, status int, id string) error {
+	ctx = context.WithoutCancel(ctx)
+	return k.store.SaveKey(ctx, key, Record{Hash: hash, Status: status, ID: id}, k.ttl)
+}
+
+// Release удаляет ключ запроса, который не удалось выполнить. Ключ
+// удаляется и после отмены ctx.
+func (k *Keys) Release(ctx context.Context, key string) error {
+	ctx = context.WithoutCancel(ctx)
+	return k.store.ReleaseKey(ctx, key)
+}
+
+// Hash возвращает отпечаток полей тела запроса.
+func Hash(fields ...string) string {
+	h := sha256.New()
+	for _, f := range fields {
+		// Длина перед каждым полем, чтобы ("ab", "c") и ("a", "bc")
+		// давали разные отпечатки.
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(f))))
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entry - запись ключа в памяти.
+type entry struct {
+	rec    Record
+	expire time.Time
+}
+
+// Memory - хранилище ключей идемпотентности в памяти одного экземпляра
+// сервиса.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	swept   time.Time
+	// now - источник текущего времени, подменяется в тестах.
+	now func() time.Time
+}
+
+// NewMemory - конструктор хранилища ключей идемпотентности в памяти.
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]entry), now: time.Now}
+}
+
+// ReserveKey записывает ключ запроса, если ключа еще нет. Ключи с истекшим
+// временем хранения периодически удаляются из памяти.
+func (m *Memory) ReserveKey(ctx context.Context, key string, rec Record, ttl time.Duration) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.swept) >= ttl {
+		for k, e := range m.entries {
+			if !now.Before(e.expire) {
+				delete(m.entries, k)
+			}
+		}
+		m.swept = now
+	}
+
+	if e, ok := m.entries[key]; ok && now.Before(e.expire) {
+		return e.rec, false, nil
+	}
+	m.entries[key] = entry{rec: rec, expire: now.Add(ttl)}
+	return rec, true, nil
+}
+
+// SaveKey записывает результат выполненного запроса.
+func (m *Memory) SaveKey(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = entry{rec: rec, expire: m.now().Add(ttl)}
+	return nil
+}
+
+// ReleaseKey удаляет ключ запроса.
+func (m *Memory) ReleaseKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
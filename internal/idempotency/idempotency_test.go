@@ -0,0 +1,93 @@
+package idempotency
+
+import (
+	"GoExamComments/internal/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeys(t *testing.T) {
+	if New(&config.Config{}, NewMemory()) != nil {
+		t.Fatal("New() without TTL != nil")
+	}
+
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	keys := New(&config.Config{Idempotency: config.Idempotency{KeyTTL: time.Hour}}, m)
+	ctx := context.Background()
+
+	if _, fresh, _ := keys.Reserve(ctx, "k1", "h1"); !fresh {
+		t.Fatal("Keys.Reserve() new key fresh = false, want true")
+	}
+	// Пока запрос выполняется, у записи нет кода ответа.
+	rec, fresh, _ := keys.Reserve(ctx, "k1", "h2")
+	if fresh || rec != (Record{Hash: "h1"}) {
+		t.Fatalf("Keys.Reserve() in progress = %+v, %v, want h1 without status", rec, fresh)
+	}
+
+	keys.Save(ctx, "k1", "h1", 201, "id1")
+	rec, fresh, _ = keys.Reserve(ctx, "k1", "h1")
+	if fresh || rec != (Record{Hash: "h1", Status: 201, ID: "id1"}) {
+		t.Fatalf("Keys.Reserve() after save = %+v, %v, want saved result", rec, fresh)
+	}
+
+	// Освобожденный ключ можно использовать снова.
+	keys.Reserve(ctx, "k2", "h1")
+	keys.Release(ctx, "k2")
+	if _, fresh, _ := keys.Reserve(ctx, "k2", "h2"); !fresh {
+		t.Fatal("Keys.Reserve() released key fresh = false, want true")
+	}
+
+	// Ключ запроса, результат которого не записан, освобождается через
+	// время Pending.
+	keys.Reserve(ctx, "k3", "h1")
+	now = now.Add(Pending)
+	if _, fresh, _ := keys.Reserve(ctx, "k3", "h2"); !fresh {
+		t.Fatal("Keys.Reserve() pending key after Pending fresh = false, want true")
+	}
+
+	// После окончания времени хранения ключ записывается заново.
+	now = now.Add(2 * time.Hour)
+	if _, fresh, _ := keys.Reserve(ctx, "k1", "h3"); !fresh {
+		t.Fatal("Keys.Reserve() expired key fresh = false, want true")
+	}
+	if len(m.entries) != 1 {
+		t.Errorf("Memory.entries len = %d, want 1 after sweep", len(m.entries))
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("ab", "c") == Hash("a", "bc") {
+		t.Error("Hash() of different fields are equal")
+	}
+	if Hash("a", "b") != Hash("a", "b") {
+		t.Error("Hash() of equal fields are different")
+	}
+}
+
+// ctxStore - хранилище ключей, которое возвращает ошибку отмененного
+// контекста.
+type ctxStore struct{ Memory }
+
+func (s *ctxStore) SaveKey(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	return ctx.Err()
+}
+
+func (s *ctxStore) ReleaseKey(ctx context.Context, key string) error {
+	return ctx.Err()
+}
+
+func TestKeys_Canceled(t *testing.T) {
+	keys := New(&config.Config{Idempotency: config.Idempotency{KeyTTL: time.Hour}}, &ctxStore{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := keys.Save(ctx, "k1", "h1", 201, "id1"); err != nil {
+		t.Errorf("Keys.Save() with canceled context error = %v, want nil", err)
+	}
+	if err := keys.Release(ctx, "k1"); err != nil {
+		t.Errorf("Keys.Release() with canceled context error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/logger"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimsKey - ключ утверждений токена пользователя внутри контекста.
+const ClaimsKey ctxKey = 1
+
+// Claims - утверждения JWT токена пользователя. ID пользователя передается
+// в утверждении sub, имя для подписи комментариев - в утверждении name,
+// роль пользователя - в утверждении role.
+type Claims struct {
+	Name string `json:"name,omitempty"`
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier - проверка подписи и утверждений JWT токенов.
+type Verifier struct {
+	secret []byte
+	key    *rsa.PublicKey
+	// keys - открытые ключи из JWKS по их ID.
+	keys   map[string]*rsa.PublicKey
+	parser *jwt.Parser
+}
+
+// jwk - открытый ключ RSA в формате JWK.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// NewVerifier - обертка для конструктора проверки токенов newVerifier.
+// Если ключи не заданы в конфиге, то возвращает nil: токены не проверяются.
+// Если ключи не удается загрузить, то завершает приложение с ошибкой.
+func NewVerifier(cfg *config.Config) *Verifier {
+	v, err := newVerifier(cfg.Auth)
+	if err != nil {
+		log.Fatalf("failed to init auth: %s", err.Error())
+	}
+	if v == nil {
+		slog.Warn("auth is disabled: no keys in config")
+	}
+	return v
+}
+
+// newVerifier - конструктор проверки токенов. Принимаются только алгоритмы,
+// для которых в конфиге есть ключи: HS256 для общего ключа, RS256 для
+// открытых ключей RSA.
+func newVerifier(cfg config.Auth) (*Verifier, error) {
+	const operation = "middleware.newVerifier"
+
+	v := &Verifier{}
+	var methods []string
+
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.PublicKey != "" {
+		b, err := os.ReadFile(cfg.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		v.key, err = jwt.ParseRSAPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+	if cfg.JWKS != "" {
+		var err error
+		v.keys, err = readJWKS(cfg.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+	if v.key != nil || len(v.keys) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// readJWKS читает открытые ключи RSA для подписи из файла в формате JWKS.
+// Ключи других типов и ключи для шифрования пропускаются.
+func readJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(b, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %q: incorrect exponent", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA signing keys in JWKS")
+	}
+	return keys, nil
+}
+
+// keyfunc возвращает ключ для проверки подписи токена. Для RS256 ключ
+// выбирается из JWKS по заголовку kid, без kid используется ключ из PEM
+// файла или единственный ключ из JWKS.
+func (v *Verifier) keyfunc(token *jwt.Token) (any, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		return v.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && v.key != nil {
+		return v.key, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// Verify проверяет подпись, срок действия и утверждения токена
+// и возвращает его утверждения. Токен без ID пользователя не принимается.
+func (v *Verifier) Verify(s string) (*Claims, error) {
+	var claims Claims
+	_, err := v.parser.ParseWithClaims(s, &claims, v.keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &claims, nil
+}
+
+// Auth проверяет JWT токен из заголовка Authorization и записывает его
+// утверждения в контекст. Запросы без токена выполняются, права на них
+// проверяет Require. Запрос с некорректным токеном отклоняется всегда.
+// Если v равен nil, то токены не проверяются.
+func Auth(v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if v == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := slog.Default().With(
+				slog.String("op", "middleware.Auth"),
+				slog.String("request_id", GetReqID(r.Context())),
+			)
+
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				log.Warn("incorrect authorization header")
+				unauthorized(w, "incorrect authorization header")
+				return
+			}
+			claims, err := v.Verify(strings.TrimSpace(token))
+			if err != nil {
+				log.Warn("incorrect token", logger.Err(err))
+				unauthorized(w, "incorrect token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetClaims возвращает утверждения токена пользователя из контекста.
+// Для запросов без токена возвращает nil.
+func GetClaims(ctx context.Context) *Claims {
+	if ctx == nil {
+		return nil
+	}
+	claims, _ := ctx.Value(ClaimsKey).(*Claims)
+	return claims
+}
+
+// GetUserID возвращает ID пользователя из токена в контексте. Для запросов
+// без токена возвращает пустую строку.
+func GetUserID(ctx context.Context) string {
+	if claims := GetClaims(ctx); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// unauthorized записывает ответ 401 с заголовком WWW-Authenticate.
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="comments"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
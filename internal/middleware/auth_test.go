@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/logger"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sign возвращает токен с переданными утверждениями, подписанный ключом.
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s
+}
+
+// claims возвращает утверждения токена пользователя со сроком действия
+// в один час.
+func claims(sub string) Claims {
+	return Claims{
+		Name: "Alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   sub,
+			Issuer:    "auth",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+// writeKeys записывает открытый ключ в PEM файл и в файл JWKS с ID "k1"
+// и возвращает пути к файлам.
+func writeKeys(t *testing.T, key *rsa.PublicKey) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pemPath := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	set := map[string][]jwk{"keys": {{
+		Kty: "RSA",
+		Kid: "k1",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	jwksPath := filepath.Join(dir, "jwks.json")
+	err = os.WriteFile(jwksPath, b, 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return pemPath, jwksPath
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pemPath, jwksPath := writeKeys(t, &rsaKey.PublicKey)
+
+	expired := claims("alice")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExp := claims("alice")
+	noExp.ExpiresAt = nil
+	wrongIss := claims("alice")
+	wrongIss.Issuer = "other"
+
+	tests := []struct {
+		name    string
+		cfg     config.Auth
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "HS256_OK",
+			cfg:   config.Auth{Secret: "secret", Issuer: "auth"},
+			token: sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("alice")),
+		},
+		{
+			name:    "HS256_Wrong_Secret",
+			cfg:     config.Auth{Secret: "secret"},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("other"), "", claims("alice")),
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			cfg:     config.Auth{Secret: "secret"},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", expired),
+			wantErr: true,
+		},
+		{
+			name:    "No_Expiration",
+			cfg:     config.Auth{Secret: "secret"},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", noExp),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong_Issuer",
+			cfg:     config.Auth{Secret: "secret", Issuer: "auth"},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", wrongIss),
+			wantErr: true,
+		},
+		{
+			name:    "No_Subject",
+			cfg:     config.Auth{Secret: "secret"},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("")),
+			wantErr: true,
+		},
+		{
+			name:    "None_Algorithm",
+			cfg:     config.Auth{Secret: "secret"},
+			token:   sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims("alice")),
+			wantErr: true,
+		},
+		{
+			name:  "RS256_PEM_OK",
+			cfg:   config.Auth{PublicKey: pemPath},
+			token: sign(t, jwt.SigningMethodRS256, rsaKey, "", claims("alice")),
+		},
+		{
+			name:    "RS256_Wrong_Key",
+			cfg:     config.Auth{PublicKey: pemPath},
+			token:   sign(t, jwt.SigningMethodRS256, other, "", claims("alice")),
+			wantErr: true,
+		},
+		{
+			name:  "RS256_JWKS_OK",
+			cfg:   config.Auth{JWKS: jwksPath},
+			token: sign(t, jwt.SigningMethodRS256, rsaKey, "k1", claims("alice")),
+		},
+		{
+			name:    "RS256_JWKS_Unknown_Kid",
+			cfg:     config.Auth{JWKS: jwksPath},
+			token:   sign(t, jwt.SigningMethodRS256, rsaKey, "k2", claims("alice")),
+			wantErr: true,
+		},
+		{
+			// Открытый ключ RSA не должен использоваться как общий ключ HS256.
+			name:    "RS256_Key_As_HS256",
+			cfg:     config.Auth{PublicKey: pemPath},
+			token:   sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("alice")),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newVerifier(tt.cfg)
+			if err != nil {
+				t.Fatalf("newVerifier() error = %v", err)
+			}
+			got, err := v.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Subject != "alice" || got.Name != "Alice") {
+				t.Errorf("Verifier.Verify() = %+v, want alice", got)
+			}
+		})
+	}
+}
+
+func Test_newVerifier(t *testing.T) {
+	v, err := newVerifier(config.Auth{})
+	if err != nil || v != nil {
+		t.Errorf("newVerifier() = %v, %v, want nil without keys", v, err)
+	}
+
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	err = os.WriteFile(bad, []byte(`{"keys": [{"kty": "EC"}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, cfg := range []config.Auth{
+		{PublicKey: filepath.Join(dir, "missing.pem")},
+		{PublicKey: bad},
+		{JWKS: bad},
+	} {
+		if _, err := newVerifier(cfg); err == nil {
+			t.Errorf("newVerifier(%+v) error = nil, want error", cfg)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	logger.Discard()
+
+	v, err := newVerifier(config.Auth{Secret: "secret"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	token := sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("alice"))
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		code   int
+		user   string
+	}{
+		{name: "Read_Anonymous", method: http.MethodGet, code: http.StatusOK},
+		{name: "Read_Token", method: http.MethodGet, header: "Bearer " + token, code: http.StatusOK, user: "alice"},
+		{name: "Write_Anonymous", method: http.MethodPost, code: http.StatusOK},
+		{name: "Write_Token", method: http.MethodPost, header: "Bearer " + token, code: http.StatusOK, user: "alice"},
+		{name: "Read_Bad_Token", method: http.MethodGet, header: "Bearer abc", code: http.StatusUnauthorized},
+		{name: "Basic_Auth", method: http.MethodDelete, header: "Basic YWxpY2U6cGFzcw==", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user string
+			h := Auth(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = GetUserID(r.Context())
+			}))
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Auth() code = %d, want %d", rr.Code, tt.code)
+			}
+			if user != tt.user {
+				t.Errorf("Auth() user = %q, want %q", user, tt.user)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Auth() has no WWW-Authenticate header")
+			}
+		})
+	}
+
+	// Без ключей токены не проверяются.
+	rr := httptest.NewRecorder()
+	Auth(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Auth(nil) code = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
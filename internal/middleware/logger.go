@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"bufio"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 )
 
@@ -24,6 +26,22 @@ func (l *loggingResponseWriter) WriteHeader(code int) {
 	l.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap возвращает исходный http.ResponseWriter, чтобы обработчики могли
+// использовать его методы через http.ResponseController.
+func (l *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+// Hijack передает управление соединением обработчику, например при переходе
+// на протокол WebSocket. Код ответа в этом случае - 101.
+func (l *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(l.ResponseWriter).Hijack()
+	if err == nil {
+		l.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
 // Logger записывает логи запроса и ответа в логгер slog.
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
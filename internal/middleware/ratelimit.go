@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/ratelimit"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ClientKey - ключ корзины клиента внутри контекста.
+const ClientKey ctxKey = 2
+
+// Proxies - адреса доверенных прокси. Заголовок X-Real-IP принимается
+// только в запросах, которые пришли с этих адресов.
+type Proxies []netip.Prefix
+
+// NewProxies - конструктор списка доверенных прокси из конфига. Прокси
+// задается IP адресом или подсетью CIDR. Если адрес некорректный, то
+// завершает приложение с ошибкой.
+func NewProxies(cfg *config.Config) Proxies {
+	p, err := parseProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("failed to init trusted proxies: %s", err.Error())
+	}
+	return p
+}
+
+// parseProxies разбирает адреса и подсети прокси.
+func parseProxies(list []string) (Proxies, error) {
+	const operation = "middleware.parseProxies"
+
+	p := make(Proxies, 0, len(list))
+	for _, s := range list {
+		if strings.Contains(s, "/") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", operation, err)
+			}
+			p = append(p, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		p = append(p, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return p, nil
+}
+
+// trusted сообщает, что адрес относится к доверенному прокси.
+func (p Proxies) trusted(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit ограничивает частоту запросов клиента. Клиент определяется
+// по ID пользователя из токена, без токена - по адресу из заголовка
+// X-Real-IP, если запрос пришел от доверенного прокси из proxies, иначе -
+// по адресу соединения. Ключ корзины клиента записывается в контекст.
+// Запросы GET, HEAD и OPTIONS расходуют корзину чтения, остальные -
+// корзину изменения данных. Отклоненный запрос получает ответ 429
+// с заголовком Retry-After. При ошибке хранилища корзин запрос
+// выполняется. Если l равен nil, то частота запросов не ограничивается,
+// но ключ клиента все равно записывается в контекст.
+func RateLimit(l *ratelimit.Limiter, proxies Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientKey(r, proxies)
+			r = r.WithContext(context.WithValue(r.Context(), ClientKey, client))
+			if l == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wait, err := l.Allow(r.Context(), client, !safe(r.Method))
+			if err != nil {
+				slog.Default().Error("cannot check rate limit",
+					slog.String("op", "middleware.RateLimit"),
+					slog.String("request_id", GetReqID(r.Context())),
+					logger.Err(err),
+				)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait > 0 {
+				slog.Default().Warn("rate limit exceeded",
+					slog.String("op", "middleware.RateLimit"),
+					slog.String("request_id", GetReqID(r.Context())),
+					slog.String("client", client),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+				)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey возвращает ключ корзины клиента: ID пользователя из токена
+// или IP адрес. Адрес из заголовка X-Real-IP используется, только если
+// запрос пришел от доверенного прокси.
+func clientKey(r *http.Request, proxies Proxies) string {
+	if user := GetUserID(r.Context()); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && proxies.trusted(host) {
+		return "ip:" + ip
+	}
+	return "ip:" + host
+}
+
+// GetClient возвращает ключ корзины клиента из контекста. Если запрос
+// не прошел через RateLimit, то возвращает пустую строку.
+func GetClient(ctx context.Context) string {
+	if client, ok := ctx.Value(ClientKey).(string); ok {
+		return client
+	}
+	return ""
+}
+
+// safe сообщает, что метод запроса не изменяет данные.
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
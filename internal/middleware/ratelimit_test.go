@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/ratelimit"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{HTTPServer: config.HTTPServer{
+		ReadLimit:  config.RateLimit{Rate: 0.01, Burst: 2},
+		WriteLimit: config.RateLimit{Rate: 0.01, Burst: 1},
+	}}
+	proxies, err := parseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	h := RateLimit(ratelimit.New(cfg, ratelimit.NewMemory()), proxies)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	serve := func(method, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if ip != "" {
+			req.Header.Set("X-Real-IP", ip)
+		}
+		if user != "" {
+			claims := &Claims{}
+			claims.Subject = user
+			req = req.WithContext(context.WithValue(req.Context(), ClaimsKey, claims))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		ip     string
+		user   string
+		code   int
+	}{
+		{name: "Write_OK", method: http.MethodPost, ip: "1.1.1.1", code: http.StatusOK},
+		{name: "Write_Limited", method: http.MethodPost, ip: "1.1.1.1", code: http.StatusTooManyRequests},
+		{name: "Read_Separate_Bucket", method: http.MethodGet, ip: "1.1.1.1", code: http.StatusOK},
+		{name: "Read_Burst", method: http.MethodGet, ip: "1.1.1.1", code: http.StatusOK},
+		{name: "Read_Limited", method: http.MethodGet, ip: "1.1.1.1", code: http.StatusTooManyRequests},
+		{name: "Other_IP", method: http.MethodPost, ip: "2.2.2.2", code: http.StatusOK},
+		{name: "Remote_Addr", method: http.MethodPost, code: http.StatusOK},
+		{name: "Remote_Addr_Limited", method: http.MethodPost, code: http.StatusTooManyRequests},
+		{name: "User_OK", method: http.MethodPost, ip: "1.1.1.1", user: "alice", code: http.StatusOK},
+		{name: "User_Other_IP_Limited", method: http.MethodPost, ip: "3.3.3.3", user: "alice", code: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, tt.ip, tt.user)
+			if rr.Code != tt.code {
+				t.Fatalf("RateLimit() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "100" {
+				t.Errorf("RateLimit() Retry-After = %q, want %q", rr.Header().Get("Retry-After"), "100")
+			}
+		})
+	}
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	var got string
+	h := RateLimit(nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetClient(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "1.1.1.1:1234"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || got != "ip:1.1.1.1" {
+		t.Errorf("RateLimit() code = %d, client = %q, want %d and %q", rr.Code, got, http.StatusOK, "ip:1.1.1.1")
+	}
+}
+
+func Test_clientKey(t *testing.T) {
+	proxies, err := parseProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		ip     string
+		user   string
+		want   string
+	}{
+		{name: "Trusted_Proxy", remote: "10.0.0.1:1234", ip: "1.1.1.1", want: "ip:1.1.1.1"},
+		{name: "Trusted_Subnet", remote: "192.168.5.5:1234", ip: "1.1.1.1", want: "ip:1.1.1.1"},
+		{name: "Trusted_IPv6", remote: "[::1]:1234", ip: "1.1.1.1", want: "ip:1.1.1.1"},
+		{name: "Untrusted_Header_Ignored", remote: "10.0.0.2:1234", ip: "1.1.1.1", want: "ip:10.0.0.2"},
+		{name: "Trusted_No_Header", remote: "10.0.0.1:1234", want: "ip:10.0.0.1"},
+		{name: "User", remote: "10.0.0.2:1234", ip: "1.1.1.1", user: "alice", want: "user:alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.ip != "" {
+				req.Header.Set("X-Real-IP", tt.ip)
+			}
+			if tt.user != "" {
+				claims := &Claims{}
+				claims.Subject = tt.user
+				req = req.WithContext(context.WithValue(req.Context(), ClaimsKey, claims))
+			}
+			if got := clientKey(req, proxies); got != tt.want {
+				t.Errorf("clientKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []string
+		want    int
+		wantErr bool
+	}{
+		{name: "Empty", list: nil, want: 0},
+		{name: "Addresses_And_Subnets", list: []string{"10.0.0.1", "172.16.0.0/12", "fd00::/8"}, want: 3},
+		{name: "Incorrect_Address", list: []string{"proxy.local"}, wantErr: true},
+		{name: "Incorrect_Subnet", list: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProxies(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("parseProxies() len = %d, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
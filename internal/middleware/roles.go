@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Role - роль пользователя. Роли упорядочены: каждая следующая роль имеет
+// все права предыдущих.
+type Role int
+
+// Роли пользователей.
+const (
+	// RoleReader - читатель: читает комментарии, ставит реакции и пишет
+	// жалобы. Роль запросов без токена.
+	RoleReader Role = iota + 1
+	// RoleAuthor - автор: пишет комментарии и изменяет свои. Роль
+	// пользователя с токеном без утверждения role.
+	RoleAuthor
+	// RoleModerator - модератор: проверяет, изменяет и удаляет комментарии.
+	RoleModerator
+	// RoleAdmin - администратор: управляет сервисом.
+	RoleAdmin
+)
+
+// roles - роли по их названиям в утверждении role токена.
+var roles = map[string]Role{
+	"reader":    RoleReader,
+	"author":    RoleAuthor,
+	"moderator": RoleModerator,
+	"admin":     RoleAdmin,
+}
+
+// String возвращает название роли.
+func (r Role) String() string {
+	for name, role := range roles {
+		if role == r {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// ParseRole возвращает роль по ее названию.
+func ParseRole(s string) (Role, bool) {
+	r, ok := roles[s]
+	return r, ok
+}
+
+// GetRole возвращает роль пользователя из токена в контексте. Запросы
+// без токена имеют роль читателя, токен без утверждения role - роль
+// автора. Неизвестная роль в токене понижается до читателя.
+func GetRole(ctx context.Context) Role {
+	claims := GetClaims(ctx)
+	if claims == nil {
+		return RoleReader
+	}
+	if claims.Role == "" {
+		return RoleAuthor
+	}
+	if r, ok := ParseRole(claims.Role); ok {
+		return r
+	}
+	return RoleReader
+}
+
+// denial - тело ответа на запрос без нужной роли.
+type denial struct {
+	Error     string `json:"error"`
+	Role      string `json:"role"`
+	Required  string `json:"required"`
+	RequestID string `json:"requestId"`
+}
+
+// Require пропускает только запросы пользователей с ролью не ниже
+// переданной. Запросы без токена отклоняются с ответом 401, запросы
+// с недостаточной ролью - с ответом 403 и описанием ошибки в JSON.
+// Каждый отказ записывается в лог вместе с ID запроса.
+func Require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			have := GetRole(ctx)
+			if have >= role {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqID := GetReqID(ctx)
+			slog.Default().Warn("access denied",
+				slog.String("op", "middleware.Require"),
+				slog.String("request_id", reqID),
+				slog.String("user", GetUserID(ctx)),
+				slog.String("role", have.String()),
+				slog.String("required", role.String()),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+			)
+
+			if GetClaims(ctx) == nil {
+				unauthorized(w, "authentication required")
+				return
+			}
+			b, _ := json.Marshal(denial{
+				Error:     "forbidden",
+				Role:      have.String(),
+				Required:  role.String(),
+				RequestID: reqID,
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(append(b, '\n'))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"GoExamComments/internal/logger"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   Role
+	}{
+		{name: "Anonymous", want: RoleReader},
+		{name: "No_Role_Claim", claims: &Claims{}, want: RoleAuthor},
+		{name: "Reader", claims: &Claims{Role: "reader"}, want: RoleReader},
+		{name: "Moderator", claims: &Claims{Role: "moderator"}, want: RoleModerator},
+		{name: "Admin", claims: &Claims{Role: "admin"}, want: RoleAdmin},
+		{name: "Unknown_Role", claims: &Claims{Role: "root"}, want: RoleReader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.claims != nil {
+				ctx = context.WithValue(ctx, ClaimsKey, tt.claims)
+			}
+			if got := GetRole(ctx); got != tt.want {
+				t.Errorf("GetRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		role   Role
+		code   int
+	}{
+		{name: "Reader_Anonymous", role: RoleReader, code: http.StatusOK},
+		{name: "Author_Anonymous", role: RoleAuthor, code: http.StatusUnauthorized},
+		{name: "Author_OK", claims: &Claims{}, role: RoleAuthor, code: http.StatusOK},
+		{name: "Moderator_By_Author", claims: &Claims{}, role: RoleModerator, code: http.StatusForbidden},
+		{name: "Moderator_By_Admin", claims: &Claims{Role: "admin"}, role: RoleModerator, code: http.StatusOK},
+		{name: "Admin_By_Moderator", claims: &Claims{Role: "moderator"}, role: RoleAdmin, code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Require(tt.role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			ctx := context.WithValue(req.Context(), RequestIDKey, "req-1")
+			if tt.claims != nil {
+				ctx = context.WithValue(ctx, ClaimsKey, tt.claims)
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req.WithContext(ctx))
+
+			if rr.Code != tt.code {
+				t.Fatalf("Require() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusForbidden {
+				return
+			}
+			var got denial
+			err := json.NewDecoder(rr.Body).Decode(&got)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			want := denial{
+				Error:     "forbidden",
+				Role:      GetRole(ctx).String(),
+				Required:  tt.role.String(),
+				RequestID: "req-1",
+			}
+			if got != want {
+				t.Errorf("Require() body = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
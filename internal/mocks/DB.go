@@ -42,6 +42,34 @@ func (_m *DB) AddComment(ctx context.Context, com storage.Comment) (string, erro
 	return r0, r1
 }
 
+// AuthorComments provides a mock function with given fields: ctx, author, limit, cursor
+func (_m *DB) AuthorComments(ctx context.Context, author string, limit int, cursor string) (storage.Page, error) {
+	ret := _m.Called(ctx, author, limit, cursor)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AuthorComments")
+	}
+
+	var r0 storage.Page
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, string) (storage.Page, error)); ok {
+		return rf(ctx, author, limit, cursor)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, string) storage.Page); ok {
+		r0 = rf(ctx, author, limit, cursor)
+	} else {
+		r0 = ret.Get(0).(storage.Page)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, string) error); ok {
+		r1 = rf(ctx, author, limit, cursor)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *DB) Close() error {
 	ret := _m.Called()
@@ -60,6 +88,34 @@ func (_m *DB) Close() error {
 	return r0
 }
 
+// Comment provides a mock function with given fields: ctx, id
+func (_m *DB) Comment(ctx context.Context, id string) (storage.Comment, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Comment")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (storage.Comment, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.Comment); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Comments provides a mock function with given fields: ctx, post
 func (_m *DB) Comments(ctx context.Context, post string) ([]storage.Comment, error) {
 	ret := _m.Called(ctx, post)
@@ -90,6 +146,352 @@ func (_m *DB) Comments(ctx context.Context, post string) ([]storage.Comment, err
 	return r0, r1
 }
 
+// Counts provides a mock function with given fields: ctx, posts
+func (_m *DB) Counts(ctx context.Context, posts []string) (map[string]int, error) {
+	ret := _m.Called(ctx, posts)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Counts")
+	}
+
+	var r0 map[string]int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) (map[string]int, error)); ok {
+		return rf(ctx, posts)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) map[string]int); ok {
+		r0 = rf(ctx, posts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, posts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteComment provides a mock function with given fields: ctx, id
+func (_m *DB) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteComment")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (storage.Comment, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) storage.Comment); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// History provides a mock function with given fields: ctx, id
+func (_m *DB) History(ctx context.Context, id string) ([]storage.Revision, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for History")
+	}
+
+	var r0 []storage.Revision
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]storage.Revision, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []storage.Revision); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Revision)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Pending provides a mock function with given fields: ctx, limit
+func (_m *DB) Pending(ctx context.Context, limit int) ([]storage.Comment, error) {
+	ret := _m.Called(ctx, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Pending")
+	}
+
+	var r0 []storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]storage.Comment, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []storage.Comment); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Comment)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// React provides a mock function with given fields: ctx, id, user, reaction
+func (_m *DB) React(ctx context.Context, id string, user string, reaction string) (storage.Comment, error) {
+	ret := _m.Called(ctx, id, user, reaction)
+
+	if len(ret) == 0 {
+		panic("no return value specified for React")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) (storage.Comment, error)); ok {
+		return rf(ctx, id, user, reaction)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) storage.Comment); ok {
+		r0 = rf(ctx, id, user, reaction)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, id, user, reaction)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Report provides a mock function with given fields: ctx, id, user, reason, threshold
+func (_m *DB) Report(ctx context.Context, id string, user string, reason string, threshold int) (storage.Comment, error) {
+	ret := _m.Called(ctx, id, user, reason, threshold)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Report")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int) (storage.Comment, error)); ok {
+		return rf(ctx, id, user, reason, threshold)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int) storage.Comment); ok {
+		r0 = rf(ctx, id, user, reason, threshold)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, int) error); ok {
+		r1 = rf(ctx, id, user, reason, threshold)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reported provides a mock function with given fields: ctx, limit
+func (_m *DB) Reported(ctx context.Context, limit int) ([]storage.Reported, error) {
+	ret := _m.Called(ctx, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Reported")
+	}
+
+	var r0 []storage.Reported
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]storage.Reported, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []storage.Reported); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Reported)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetStatus provides a mock function with given fields: ctx, id, status
+func (_m *DB) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	ret := _m.Called(ctx, id, status)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetStatus")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (storage.Comment, error)); ok {
+		return rf(ctx, id, status)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) storage.Comment); ok {
+		r0 = rf(ctx, id, status)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, id, status)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Subtree provides a mock function with given fields: ctx, id, depth
+func (_m *DB) Subtree(ctx context.Context, id string, depth int) ([]storage.Comment, error) {
+	ret := _m.Called(ctx, id, depth)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Subtree")
+	}
+
+	var r0 []storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) ([]storage.Comment, error)); ok {
+		return rf(ctx, id, depth)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) []storage.Comment); ok {
+		r0 = rf(ctx, id, depth)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]storage.Comment)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, id, depth)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Threads provides a mock function with given fields: ctx, post, limit, cursor, oldest
+func (_m *DB) Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (storage.Page, error) {
+	ret := _m.Called(ctx, post, limit, cursor, oldest)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Threads")
+	}
+
+	var r0 storage.Page
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, string, bool) (storage.Page, error)); ok {
+		return rf(ctx, post, limit, cursor, oldest)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, string, bool) storage.Page); ok {
+		r0 = rf(ctx, post, limit, cursor, oldest)
+	} else {
+		r0 = ret.Get(0).(storage.Page)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, string, bool) error); ok {
+		r1 = rf(ctx, post, limit, cursor, oldest)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Unreact provides a mock function with given fields: ctx, id, user
+func (_m *DB) Unreact(ctx context.Context, id string, user string) (storage.Comment, error) {
+	ret := _m.Called(ctx, id, user)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Unreact")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (storage.Comment, error)); ok {
+		return rf(ctx, id, user)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) storage.Comment); ok {
+		r0 = rf(ctx, id, user)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, id, user)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateComment provides a mock function with given fields: ctx, com
+func (_m *DB) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	ret := _m.Called(ctx, com)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateComment")
+	}
+
+	var r0 storage.Comment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Comment) (storage.Comment, error)); ok {
+		return rf(ctx, com)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Comment) storage.Comment); ok {
+		r0 = rf(ctx, com)
+	} else {
+		r0 = ret.Get(0).(storage.Comment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, storage.Comment) error); ok {
+		r1 = rf(ctx, com)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewDB creates a new instance of DB. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewDB(t interface {
@@ -0,0 +1,165 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// leet - замены символов, которыми часто подменяют буквы в словах.
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// reLinks - регулярное выражение для поиска ссылок в тексте.
+var reLinks = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s]+`)
+
+// WordList - проверка текста по списку запрещенных слов.
+type WordList struct {
+	words  map[string]struct{}
+	action Action
+}
+
+// NewWordList - конструктор проверки по списку запрещенных слов.
+func NewWordList(words []string, action Action) *WordList {
+	wl := &WordList{
+		words:  make(map[string]struct{}, len(words)),
+		action: action,
+	}
+	for _, w := range words {
+		n := normalize(w)
+		if n == "" {
+			continue
+		}
+		wl.words[n] = struct{}{}
+	}
+	return wl
+}
+
+// Check ищет в тексте запрещенные слова. Перед сравнением каждое слово
+// приводится к нормальной форме, поэтому "Сл0во" и "слово" совпадут.
+func (wl *WordList) Check(content string) (string, []Hit) {
+	var hits []Hit
+	runes := []rune(content)
+
+	for _, span := range words(runes) {
+		word := normalize(string(runes[span[0]:span[1]]))
+		if _, ok := wl.words[word]; !ok {
+			continue
+		}
+		hits = append(hits, Hit{Check: "censor_list", Rule: word, Action: wl.action})
+		if wl.action == Mask {
+			for i := span[0]; i < span[1]; i++ {
+				runes[i] = '*'
+			}
+		}
+	}
+
+	return string(runes), hits
+}
+
+// Regex - проверка текста по регулярному выражению.
+type Regex struct {
+	name   string
+	re     *regexp.Regexp
+	action Action
+}
+
+// NewRegex - конструктор проверки по регулярному выражению.
+func NewRegex(name string, re *regexp.Regexp, action Action) *Regex {
+	return &Regex{name: name, re: re, action: action}
+}
+
+// Check ищет в тексте совпадения с регулярным выражением.
+func (r *Regex) Check(content string) (string, []Hit) {
+	if !r.re.MatchString(content) {
+		return content, nil
+	}
+	hits := []Hit{{Check: "regex", Rule: r.name, Action: r.action}}
+	if r.action == Mask {
+		content = r.re.ReplaceAllStringFunc(content, stars)
+	}
+	return content, hits
+}
+
+// Links - проверка количества ссылок в тексте.
+type Links struct {
+	max    int
+	action Action
+}
+
+// NewLinks - конструктор проверки количества ссылок.
+func NewLinks(max int, action Action) *Links {
+	return &Links{max: max, action: action}
+}
+
+// Check считает ссылки в тексте и срабатывает, если их больше
+// допустимого количества. При маскировке скрываются все ссылки.
+func (l *Links) Check(content string) (string, []Hit) {
+	links := reLinks.FindAllStringIndex(content, -1)
+	if len(links) <= l.max {
+		return content, nil
+	}
+	hits := []Hit{{Check: "max_links", Rule: "max_links", Action: l.action}}
+	if l.action == Mask {
+		content = reLinks.ReplaceAllStringFunc(content, stars)
+	}
+	return content, hits
+}
+
+// normalize приводит слово к нормальной форме: раскладывает символы
+// Unicode и убирает диакритические знаки, переводит в нижний регистр
+// и заменяет leetspeak-символы на буквы.
+func normalize(word string) string {
+	var b strings.Builder
+
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// words возвращает границы слов в тексте в виде индексов рун. Словом
+// считается последовательность букв, цифр и leetspeak-символов.
+func words(runes []rune) [][2]int {
+	var spans [][2]int
+	start := -1
+
+	for i, r := range runes {
+		_, isLeet := leet[r]
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || isLeet {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(runes)})
+	}
+	return spans
+}
+
+// stars заменяет каждый символ строки на звездочку.
+func stars(s string) string {
+	return strings.Repeat("*", len([]rune(s)))
+}
@@ -0,0 +1,195 @@
+// Пакет moderation содержит цепочку проверок содержимого комментариев,
+// которая выполняется перед записью комментария в БД.
+package moderation
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/storage"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Action - действие, которое выполняется при срабатывании правила.
+type Action int
+
+// Возможные действия правил модерации. Порядок констант соответствует
+// строгости действия.
+const (
+	Allow Action = iota
+	Flag
+	Mask
+	Reject
+)
+
+// String возвращает название действия.
+func (a Action) String() string {
+	switch a {
+	case Flag:
+		return "flag"
+	case Mask:
+		return "mask"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// ParseAction возвращает действие по его названию. Для пустой строки
+// возвращает переданное действие по умолчанию.
+func ParseAction(s string, def Action) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return def, nil
+	case "allow":
+		return Allow, nil
+	case "flag":
+		return Flag, nil
+	case "mask":
+		return Mask, nil
+	case "reject":
+		return Reject, nil
+	default:
+		return Allow, fmt.Errorf("unknown moderation action: %q", s)
+	}
+}
+
+// Hit - сработавшее правило модерации.
+type Hit struct {
+	Check  string
+	Rule   string
+	Action Action
+}
+
+// Check - проверка содержимого комментария.
+type Check interface {
+	// Check проверяет текст комментария и возвращает его, возможно,
+	// с замаскированными фрагментами, а также список сработавших правил.
+	Check(content string) (string, []Hit)
+}
+
+// Verdict - итог прохождения комментария через цепочку проверок. Status
+// содержит статус модерации, с которым комментарий записывается в БД.
+type Verdict struct {
+	Content string
+	Status  string
+	Hits    []Hit
+}
+
+// Rejected сообщает, что комментарий должен быть отклонен.
+func (v Verdict) Rejected() bool {
+	return v.has(Reject)
+}
+
+// Flagged сообщает, что комментарий отмечен для проверки модератором.
+func (v Verdict) Flagged() bool {
+	return v.has(Flag)
+}
+
+// has проверяет, есть ли среди сработавших правил правило с переданным
+// действием.
+func (v Verdict) has(a Action) bool {
+	for _, hit := range v.Hits {
+		if hit.Action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline - цепочка проверок комментария.
+type Pipeline struct {
+	status string
+	checks []Check
+}
+
+// New - обертка для конструктора цепочки проверок new. Собирает проверки
+// из настроек конфига. Если настройки некорректны, то завершает приложение
+// с ошибкой.
+func New(cfg *config.Config) *Pipeline {
+	checks, err := fromConfig(cfg)
+	if err != nil {
+		log.Fatalf("failed to init moderation: %s", err.Error())
+	}
+
+	p := new(checks...)
+	switch cfg.DefaultStatus {
+	case "":
+	case storage.StatusApproved, storage.StatusPending:
+		p.status = cfg.DefaultStatus
+	default:
+		log.Fatalf("failed to init moderation: incorrect default status: %s", cfg.DefaultStatus)
+	}
+	return p
+}
+
+// new - конструктор цепочки проверок. Статус по умолчанию - одобрен.
+func new(checks ...Check) *Pipeline {
+	return &Pipeline{status: storage.StatusApproved, checks: checks}
+}
+
+// fromConfig создает проверки по настройкам конфига.
+func fromConfig(cfg *config.Config) ([]Check, error) {
+	const operation = "moderation.fromConfig"
+
+	var checks []Check
+
+	if len(cfg.CensorList) > 0 {
+		act, err := ParseAction(cfg.CensorAction, Reject)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		checks = append(checks, NewWordList(cfg.CensorList, act))
+	}
+
+	for _, rule := range cfg.Rules {
+		act, err := ParseAction(rule.Action, Flag)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", operation, rule.Name, err)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", operation, rule.Name, err)
+		}
+		checks = append(checks, NewRegex(rule.Name, re, act))
+	}
+
+	if cfg.MaxLinks > 0 {
+		act, err := ParseAction(cfg.LinksAction, Reject)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		checks = append(checks, NewLinks(cfg.MaxLinks, act))
+	}
+
+	return checks, nil
+}
+
+// Moderate пропускает текст комментария через все проверки по очереди.
+// Каждая следующая проверка получает текст после маскировки предыдущими.
+// При срабатывании правила с действием Reject остальные проверки
+// не выполняются. Помеченный комментарий отправляется в очередь
+// модерации, остальные получают статус по умолчанию.
+func (p *Pipeline) Moderate(content string) Verdict {
+	v := Verdict{Content: content, Status: storage.StatusApproved}
+	if p == nil {
+		return v
+	}
+	v.Status = p.status
+
+	for _, check := range p.checks {
+		var hits []Hit
+		v.Content, hits = check.Check(v.Content)
+		v.Hits = append(v.Hits, hits...)
+		if v.Rejected() {
+			break
+		}
+	}
+
+	if v.Flagged() {
+		v.Status = storage.StatusPending
+	}
+	return v
+}
@@ -0,0 +1,211 @@
+package moderation
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/storage"
+	"regexp"
+	"testing"
+)
+
+func TestPipeline_Moderate(t *testing.T) {
+	phone := regexp.MustCompile(`\+?\d[\d\- ]{9,}\d`)
+
+	tests := []struct {
+		name     string
+		checks   []Check
+		content  string
+		want     string
+		rejected bool
+		flagged  bool
+		hits     int
+	}{
+		{
+			name:    "Clean_OK",
+			checks:  []Check{NewWordList([]string{"дурак"}, Reject)},
+			content: "Хорошая статья",
+			want:    "Хорошая статья",
+		},
+		{
+			name:     "Word_Reject",
+			checks:   []Check{NewWordList([]string{"дурак"}, Reject)},
+			content:  "Автор ДУРАК",
+			want:     "Автор ДУРАК",
+			rejected: true,
+			hits:     1,
+		},
+		{
+			name:    "Word_Leet_Mask",
+			checks:  []Check{NewWordList([]string{"idiot"}, Mask)},
+			content: "You are an 1d10t, really",
+			want:    "You are an *****, really",
+			hits:    1,
+		},
+		{
+			name:    "Word_Diacritics_Mask",
+			checks:  []Check{NewWordList([]string{"naive"}, Mask)},
+			content: "So naïve!",
+			want:    "So *****!",
+			hits:    1,
+		},
+		{
+			name:    "Word_Substring_OK",
+			checks:  []Check{NewWordList([]string{"ass"}, Reject)},
+			content: "Classic assessment",
+			want:    "Classic assessment",
+		},
+		{
+			name:    "Regex_Flag",
+			checks:  []Check{NewRegex("phone", phone, Flag)},
+			content: "Call me +7 999 123-45-67",
+			want:    "Call me +7 999 123-45-67",
+			flagged: true,
+			hits:    1,
+		},
+		{
+			name:    "Regex_Mask",
+			checks:  []Check{NewRegex("phone", phone, Mask)},
+			content: "Call 89991234567",
+			want:    "Call ***********",
+			hits:    1,
+		},
+		{
+			name:     "Links_Reject",
+			checks:   []Check{NewLinks(1, Reject)},
+			content:  "See http://a.com and www.b.com",
+			want:     "See http://a.com and www.b.com",
+			rejected: true,
+			hits:     1,
+		},
+		{
+			name:    "Links_OK",
+			checks:  []Check{NewLinks(1, Reject)},
+			content: "See https://a.com",
+			want:    "See https://a.com",
+		},
+		{
+			name: "Chain_Stops_On_Reject",
+			checks: []Check{
+				NewWordList([]string{"spam"}, Reject),
+				NewRegex("phone", phone, Flag),
+			},
+			content:  "spam 89991234567",
+			want:     "spam 89991234567",
+			rejected: true,
+			hits:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := new(tt.checks...).Moderate(tt.content)
+			if got.Content != tt.want {
+				t.Errorf("Pipeline.Moderate() content = %q, want %q", got.Content, tt.want)
+			}
+			if got.Rejected() != tt.rejected {
+				t.Errorf("Pipeline.Moderate() rejected = %v, want %v", got.Rejected(), tt.rejected)
+			}
+			if got.Flagged() != tt.flagged {
+				t.Errorf("Pipeline.Moderate() flagged = %v, want %v", got.Flagged(), tt.flagged)
+			}
+			if len(got.Hits) != tt.hits {
+				t.Errorf("Pipeline.Moderate() hits = %d, want %d", len(got.Hits), tt.hits)
+			}
+		})
+	}
+}
+
+func TestPipeline_Status(t *testing.T) {
+	phone := regexp.MustCompile(`\d{10}`)
+
+	tests := []struct {
+		name    string
+		status  string
+		content string
+		want    string
+	}{
+		{
+			name:    "Default_Approved",
+			status:  storage.StatusApproved,
+			content: "Hello",
+			want:    storage.StatusApproved,
+		},
+		{
+			name:    "Default_Pending",
+			status:  storage.StatusPending,
+			content: "Hello",
+			want:    storage.StatusPending,
+		},
+		{
+			name:    "Flagged_Pending",
+			status:  storage.StatusApproved,
+			content: "Call 9991234567",
+			want:    storage.StatusPending,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := new(NewRegex("phone", phone, Flag))
+			p.status = tt.status
+			got := p.Moderate(tt.content)
+			if got.Status != tt.want {
+				t.Errorf("Pipeline.Moderate() status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "Config_OK",
+			cfg: config.Config{
+				CensorList: []string{"word"},
+				Moderation: config.Moderation{
+					MaxLinks: 2,
+					Rules:    []config.Rule{{Name: "digits", Pattern: `\d+`, Action: "mask"}},
+				},
+			},
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name:    "Empty_Config",
+			cfg:     config.Config{},
+			want:    0,
+			wantErr: false,
+		},
+		{
+			name: "Incorrect_Action",
+			cfg: config.Config{
+				CensorList: []string{"word"},
+				Moderation: config.Moderation{CensorAction: "ban"},
+			},
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name: "Incorrect_Pattern",
+			cfg: config.Config{
+				Moderation: config.Moderation{Rules: []config.Rule{{Name: "bad", Pattern: `(`}}},
+			},
+			want:    0,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fromConfig(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fromConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.want {
+				t.Errorf("fromConfig() len = %d, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
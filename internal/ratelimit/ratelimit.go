@@ -0,0 +1,118 @@
+// Пакет для ограничения частоты запросов клиентов.
+package ratelimit
+
+import (
+	"GoExamComments/internal/config"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweep - интервал удаления из памяти корзин, которые успели наполниться.
+const sweep = time.Minute
+
+// Store - хранилище корзин токенов клиентов.
+type Store interface {
+	// Take забирает токен из корзины key, которая наполняется со скоростью
+	// rate токенов в секунду и вмещает не более burst токенов. Если токен
+	// получен, то возвращает 0, иначе - время до появления токена.
+	Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+}
+
+// Limiter - ограничение частоты запросов на чтение и на изменение данных
+// с отдельными корзинами для каждого клиента.
+type Limiter struct {
+	store Store
+	read  config.RateLimit
+	write config.RateLimit
+}
+
+// New - конструктор ограничения частоты запросов. Если ни одно
+// ограничение не задано в конфиге, то возвращает nil.
+func New(cfg *config.Config, store Store) *Limiter {
+	if cfg.ReadLimit.Rate <= 0 && cfg.WriteLimit.Rate <= 0 {
+		return nil
+	}
+	return &Limiter{store: store, read: cfg.ReadLimit, write: cfg.WriteLimit}
+}
+
+// Allow забирает токен из корзины клиента для запроса на чтение или на
+// изменение данных. Если токен получен или ограничение для этого типа
+// запросов не задано, то возвращает 0, иначе - время до появления токена.
+func (l *Limiter) Allow(ctx context.Context, client string, write bool) (time.Duration, error) {
+	limit, prefix := l.read, "read:"
+	if write {
+		limit, prefix = l.write, "write:"
+	}
+	if limit.Rate <= 0 {
+		return 0, nil
+	}
+	return l.store.Take(ctx, prefix+client, limit.Rate, burst(limit))
+}
+
+// burst возвращает размер корзины. Если он не задан, то корзина вмещает
+// запросы за одну секунду.
+func burst(limit config.RateLimit) int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return max(1, int(math.Ceil(limit.Rate)))
+}
+
+// bucket - корзина токенов клиента.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full - время, к которому корзина наполнится полностью.
+	full time.Time
+}
+
+// Memory - хранилище корзин токенов в памяти одного экземпляра сервиса.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	// now - источник текущего времени, подменяется в тестах.
+	now func() time.Time
+}
+
+// NewMemory - конструктор хранилища корзин токенов в памяти.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take забирает токен из корзины key. Корзины, которые успели наполниться,
+// периодически удаляются из памяти: новая корзина тоже полная.
+func (m *Memory) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.swept) >= sweep {
+		for k, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	elapsed := max(0, now.Sub(b.last).Seconds())
+	b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		wait = time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return wait, nil
+}
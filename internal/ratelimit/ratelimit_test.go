@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"GoExamComments/internal/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory_Take(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Полная корзина пропускает burst запросов подряд.
+	for i := 0; i < 3; i++ {
+		if wait, _ := m.Take(ctx, "a", 2, 3); wait != 0 {
+			t.Fatalf("Memory.Take() request %d wait = %v, want 0", i, wait)
+		}
+	}
+	if wait, _ := m.Take(ctx, "a", 2, 3); wait != 500*time.Millisecond {
+		t.Fatalf("Memory.Take() on empty bucket wait = %v, want 500ms", wait)
+	}
+	// У другого клиента своя корзина.
+	if wait, _ := m.Take(ctx, "b", 2, 3); wait != 0 {
+		t.Fatalf("Memory.Take() other key wait = %v, want 0", wait)
+	}
+
+	// Через полсекунды появляется один токен.
+	now = now.Add(500 * time.Millisecond)
+	if wait, _ := m.Take(ctx, "a", 2, 3); wait != 0 {
+		t.Fatalf("Memory.Take() after refill wait = %v, want 0", wait)
+	}
+	if wait, _ := m.Take(ctx, "a", 2, 3); wait == 0 {
+		t.Fatal("Memory.Take() after refill second wait = 0, want > 0")
+	}
+
+	// Наполнившиеся корзины удаляются из памяти.
+	now = now.Add(2 * sweep)
+	m.Take(ctx, "c", 2, 3)
+	if len(m.buckets) != 1 {
+		t.Errorf("Memory.buckets len = %d, want 1 after sweep", len(m.buckets))
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	cfg := &config.Config{}
+	if New(cfg, NewMemory()) != nil {
+		t.Fatal("New() without limits != nil")
+	}
+
+	cfg.WriteLimit = config.RateLimit{Rate: 1}
+	l := New(cfg, NewMemory())
+	ctx := context.Background()
+
+	// Чтение не ограничено, запись - один запрос в секунду.
+	for i := 0; i < 5; i++ {
+		if wait, _ := l.Allow(ctx, "ip:1", false); wait != 0 {
+			t.Fatalf("Limiter.Allow() read %d wait = %v, want 0", i, wait)
+		}
+	}
+	if wait, _ := l.Allow(ctx, "ip:1", true); wait != 0 {
+		t.Fatalf("Limiter.Allow() write wait = %v, want 0", wait)
+	}
+	if wait, _ := l.Allow(ctx, "ip:1", true); wait == 0 {
+		t.Fatal("Limiter.Allow() second write wait = 0, want > 0")
+	}
+	if wait, _ := l.Allow(ctx, "ip:2", true); wait != 0 {
+		t.Fatalf("Limiter.Allow() write by other client wait = %v, want 0", wait)
+	}
+}
@@ -1,21 +1,44 @@
 package server
 
 import (
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/logger"
 	"GoExamComments/internal/middleware"
+	"GoExamComments/internal/moderation"
 	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/cache"
 	"GoExamComments/internal/tree"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Ограничения на количество комментариев в выдаче.
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// heartbeat - интервал отправки комментария-пинга в поток событий, чтобы
+// прокси не закрывали неактивное соединение.
+const heartbeat = 15 * time.Second
+
 // AddComment записывает переданный в запросе комментарий в БД. В заголовках
 // должен быть "Content-Type" со значением "application/json" в начале. Размер
 // тела запроса ограничен 1 Мбайтом. Размер комментария не более 1000 символов.
-func AddComment(ln int, st storage.DB) http.HandlerFunc {
+// Перед записью комментарий проходит цепочку проверок модерации. Записывает
+// в ResponseWriter сохраненный комментарий и заголовок "Location" с его
+// адресом. Запрос с заголовком "Idempotency-Key" выполняется один раз,
+// повторы с тем же ключом получают тот же ответ, если keys не nil.
+func AddComment(ln int, mod *moderation.Pipeline, st storage.DB, keys *idempotency.Keys) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const operation = "server.AddComment"
 
@@ -26,57 +49,148 @@ func AddComment(ln int, st storage.DB) http.HandlerFunc {
 
 		log.Info("request to add comment")
 
-		ct := r.Header.Get("Content-Type")
-		media := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
-		if media != "application/json" {
-			log.Error("content-Type header is not application/json")
-			http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
-			return
-		}
-
-		r.Body = http.MaxBytesReader(w, r.Body, 1048576)
-
-		var comm storage.Comment
-		err := json.NewDecoder(r.Body).Decode(&comm)
-		if err != nil {
-			log.Error("cannot decode request", logger.Err(err))
-			http.Error(w, "cannot decode request", http.StatusBadRequest)
+		comm, ok := readComment(w, r, log, ln, mod, false)
+		if !ok {
 			return
 		}
-		log.Debug("request body decoded")
 
-		if comm.Content == "" {
-			log.Error("comment has empty content field")
-			http.Error(w, "empty comment", http.StatusBadRequest)
-			return
-		}
-		if len([]rune(comm.Content)) > ln {
-			log.Error("comment content field has more than 1000 characters")
-			http.Error(w, "the length of the comment must not exceed 1000 characters", http.StatusBadRequest)
+		ctx := r.Context()
+		key, hash, ok := reserveKey(w, r, log, keys, st, comm)
+		if !ok {
 			return
 		}
 
-		ctx := r.Context()
 		id, err := st.AddComment(ctx, comm)
 		if err != nil {
 			log.Error("cannot add comment to DB", logger.Err(err))
-			if errors.Is(err, storage.ErrIncorrectParentID) || errors.Is(err, storage.ErrIncorrectPostID) {
-				http.Error(w, "incorrect data", http.StatusBadRequest)
-				return
+			if key != "" {
+				err := keys.Release(ctx, key)
+				if err != nil {
+					log.Error("cannot release idempotency key", logger.Err(err))
+				}
+			}
+			e := addError(err)
+			http.Error(w, e.msg, e.code)
+			return
+		}
+		log.Debug("comment added to DB successfully", slog.String("id", id), slog.String("status", comm.Status))
+
+		if key != "" {
+			err = keys.Save(ctx, key, hash, http.StatusCreated, id)
+			if err != nil {
+				log.Error("cannot save idempotency key", logger.Err(err))
 			}
-			http.Error(w, "cannot add the comment", http.StatusInternalServerError)
+		}
+
+		if !writeCreated(w, r, log, st, id, comm) {
 			return
 		}
-		log.Debug("comment added to DB successfully", slog.String("id", id))
 
-		w.WriteHeader(http.StatusCreated)
 		log.Info("request served successfuly")
 	}
 }
 
+// reserveKey записывает ключ идемпотентности из заголовка "Idempotency-Key"
+// запроса на добавление комментария. Ключ действует в пределах
+// пользователя из токена, а без токена - в пределах адреса клиента. Если
+// запрос без ключа, адрес клиента неизвестен или keys равен nil, то
+// возвращает пустой ключ. Если ключ уже использован, то записывает
+// в ResponseWriter ответ первого запроса или ответ 409, если тело запроса
+// отличается или первый запрос еще выполняется, и возвращает false.
+func reserveKey(w http.ResponseWriter, r *http.Request, log *slog.Logger, keys *idempotency.Keys, st storage.DB, comm storage.Comment) (string, string, bool) {
+	header := r.Header.Get("Idempotency-Key")
+	if keys == nil || header == "" {
+		return "", "", true
+	}
+	if len(header) > idempotency.MaxKey {
+		log.Error("incorrect idempotency key")
+		http.Error(w, "incorrect idempotency key", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	// Анонимные клиенты не должны получать ответы друг друга.
+	scope := "user:" + middleware.GetUserID(r.Context())
+	if middleware.GetUserID(r.Context()) == "" {
+		scope = middleware.GetClient(r.Context())
+	}
+	if scope == "" {
+		log.Warn("idempotency key ignored for unknown client")
+		return "", "", true
+	}
+
+	key := scope + ":" + header
+	hash := idempotency.Hash(comm.ParentID, comm.PostID, comm.AuthorID, comm.AuthorName, comm.Content)
+	rec, fresh, err := keys.Reserve(r.Context(), key, hash)
+	if err != nil {
+		log.Error("cannot reserve idempotency key", logger.Err(err))
+		http.Error(w, "cannot add the comment", http.StatusInternalServerError)
+		return "", "", false
+	}
+	if fresh {
+		return key, hash, true
+	}
+
+	switch {
+	case rec.Hash != hash:
+		log.Warn("idempotency key reused with different body")
+		http.Error(w, "idempotency key reused with different body", http.StatusConflict)
+	case rec.Status == 0:
+		log.Warn("request with idempotency key is in progress")
+		http.Error(w, "request with idempotency key is in progress", http.StatusConflict)
+	default:
+		log.Info("idempotent request replayed", slog.String("id", rec.ID))
+		w.Header().Set("Idempotent-Replayed", "true")
+		if rec.Status != http.StatusCreated {
+			w.WriteHeader(rec.Status)
+			break
+		}
+		writeCreated(w, r, log, st, rec.ID, comm)
+	}
+	return "", "", false
+}
+
+// writeCreated записывает в ResponseWriter ответ 201 с добавленным
+// комментарием из БД и заголовком "Location" с адресом комментария. Если
+// комментарий не удается прочитать из БД, то записывает переданный
+// комментарий с его ID.
+func writeCreated(w http.ResponseWriter, r *http.Request, log *slog.Logger, st storage.DB, id string, comm storage.Comment) bool {
+	created, err := st.Comment(r.Context(), id)
+	if err != nil {
+		log.Error("cannot receive added comment", logger.Err(err))
+		comm.ID = id
+		created = comm
+	}
+
+	w.Header().Set("Location", "/comment/"+id)
+	return writeJSON(w, log, http.StatusCreated, created)
+}
+
+// page - ответ со страницей дерева комментариев.
+type page struct {
+	Comments   []*tree.Node `json:"comments"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// authorPage - ответ со страницей комментариев автора.
+type authorPage struct {
+	Comments   []storage.Comment `json:"comments"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
 // Comments записывает в ResponseWriter полное дерево комментариев по
-// принятому ID поста.
-func Comments(st storage.DB) http.HandlerFunc {
+// принятому ID поста. Если в запросе передан параметр limit или cursor,
+// то записывает страницу из не более чем limit корневых комментариев
+// со всеми ответами на них и курсор следующей страницы. Параметр sort
+// задает порядок комментариев в дереве, страницы идут от новых корневых
+// комментариев к старым или при sort=old от старых к новым, другие
+// порядки со страницами не работают. Параметр maxDepth ограничивает
+// глубину дерева, скрытые ответы заменяются заглушкой. Ответы
+// на отсутствующие комментарии обрабатываются способом policy
+// и записываются в лог. Если хранилище обернуто в кэш, то построенный
+// ответ кэшируется по посту.
+func Comments(policy tree.Policy, st storage.DB) http.HandlerFunc {
+	c, cached := findCache(st)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		const operation = "server.Comments"
 
@@ -97,8 +211,94 @@ func Comments(st storage.DB) http.HandlerFunc {
 			return
 		}
 
-		ctx := r.Context()
-		comms, err := st.Comments(ctx, id)
+		query := r.URL.Query()
+		paged := query.Has("limit") || query.Has("cursor")
+
+		order, err := tree.ParseOrder(query.Get("sort"))
+		if err != nil {
+			log.Error("incorrect sort order", logger.Err(err))
+			http.Error(w, "incorrect sort order", http.StatusBadRequest)
+			return
+		}
+
+		if paged && order != tree.Newest && order != tree.Oldest {
+			log.Error("sort order is not supported with paging", slog.String("sort", query.Get("sort")))
+			http.Error(w, "sort order is not supported with paging", http.StatusBadRequest)
+			return
+		}
+
+		var maxDepth int
+		if s := query.Get("maxDepth"); s != "" {
+			maxDepth, err = strconv.Atoi(s)
+			if err != nil || maxDepth < 1 {
+				log.Error("incorrect max depth", slog.String("maxDepth", s))
+				http.Error(w, "incorrect max depth", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var limit int
+		if paged {
+			limit, err = parseLimit(r)
+			if err != nil {
+				log.Error("incorrect limit", logger.Err(err))
+				http.Error(w, "incorrect limit", http.StatusBadRequest)
+				return
+			}
+		}
+		cursor := query.Get("cursor")
+
+		// build получает комментарии из хранилища и строит ответ.
+		build := func(ctx context.Context) (any, error) {
+			var comms []storage.Comment
+			var next string
+			var err error
+			if paged {
+				var pg storage.Page
+				pg, err = st.Threads(ctx, id, limit, cursor, order == tree.Oldest)
+				comms, next = pg.Comments, pg.NextCursor
+			} else {
+				comms, err = st.Comments(ctx, id)
+			}
+			if err != nil {
+				return nil, err
+			}
+			log.Debug("comments received successfully")
+
+			// Страница после последней может оказаться пустой.
+			root := tree.Root{Comments: []*tree.Node{}}
+			if len(comms) > 0 {
+				root, err = tree.Build(comms, policy)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, orphan := range root.Orphans {
+				log.Warn("orphaned comments found",
+					slog.String("post_id", id),
+					slog.String("parent_id", orphan.ParentID),
+					slog.Any("ids", orphan.IDs),
+					slog.Int("count", orphan.Count),
+				)
+			}
+			tree.Sort(root.Comments, order)
+			tree.Truncate(root.Comments, maxDepth)
+
+			if paged {
+				return page{Comments: root.Comments, NextCursor: next}, nil
+			}
+			return root.Comments, nil
+		}
+
+		// Построенный ответ кэшируется по посту вместе с параметрами
+		// запроса, от которых он зависит.
+		var resp any
+		if cached {
+			key := fmt.Sprintf("comments/%d/%d/%t/%d/%s", order, maxDepth, paged, limit, cursor)
+			resp, err = c.Response(r.Context(), id, key, build)
+		} else {
+			resp, err = build(r.Context())
+		}
 		if err != nil {
 			log.Error("cannot receive comments", logger.Err(err))
 			if errors.Is(err, storage.ErrNoComments) {
@@ -109,27 +309,1041 @@ func Comments(st storage.DB) http.HandlerFunc {
 				http.Error(w, "incorrect post id", http.StatusBadRequest)
 				return
 			}
+			if errors.Is(err, storage.ErrIncorrectCursor) {
+				http.Error(w, "incorrect cursor", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot receive comments", http.StatusInternalServerError)
+			return
+		}
+
+		if !writeJSON(w, log, http.StatusOK, resp) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// AuthorComments записывает в ResponseWriter страницу комментариев автора
+// с ID из пути запроса ко всем постам, начиная с самых новых. Размер
+// страницы задается параметром limit, следующая страница запрашивается
+// с параметром cursor из предыдущего ответа.
+func AuthorComments(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.AuthorComments"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive author comments")
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		limit, err := parseLimit(r)
+		if err != nil {
+			log.Error("incorrect limit", logger.Err(err))
+			http.Error(w, "incorrect limit", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		pg, err := st.AuthorComments(ctx, r.PathValue("authorId"), limit, r.URL.Query().Get("cursor"))
+		if err != nil {
+			log.Error("cannot receive author comments", logger.Err(err))
+			if errors.Is(err, storage.ErrIncorrectUserID) {
+				http.Error(w, "incorrect author id", http.StatusBadRequest)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCursor) {
+				http.Error(w, "incorrect cursor", http.StatusBadRequest)
+				return
+			}
 			http.Error(w, "cannot receive comments", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("comments received successfully")
+		log.Debug("author comments received successfully", slog.Int("count", len(pg.Comments)))
+
+		if !writeJSON(w, log, http.StatusOK, authorPage{Comments: pg.Comments, NextCursor: pg.NextCursor}) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// Comment записывает в ResponseWriter комментарий с ID из пути запроса.
+// Если передан параметр depth, то вместе с комментарием записываются
+// ответы на него не глубже depth уровней в порядке из параметра sort.
+func Comment(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Comment"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive comment")
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		id := r.PathValue("commentId")
+		if id == "" {
+			log.Error("empty comment id")
+			http.Error(w, "empty comment id", http.StatusBadRequest)
+			return
+		}
+
+		order, err := tree.ParseOrder(r.URL.Query().Get("sort"))
+		if err != nil {
+			log.Error("incorrect sort order", logger.Err(err))
+			http.Error(w, "incorrect sort order", http.StatusBadRequest)
+			return
+		}
+
+		var depth int
+		if s := r.URL.Query().Get("depth"); s != "" {
+			d, err := strconv.Atoi(s)
+			if err != nil || d < 0 {
+				log.Error("incorrect depth", slog.String("depth", s))
+				http.Error(w, "incorrect depth", http.StatusBadRequest)
+				return
+			}
+			depth = d
+		}
+
+		ctx := r.Context()
+		comms, err := st.Subtree(ctx, id, depth)
+		if err != nil {
+			log.Error("cannot receive comment", logger.Err(err))
+			if errors.Is(err, storage.ErrCommentNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCommentID) {
+				http.Error(w, "incorrect comment id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot receive comment", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comment received successfully", slog.Int("count", len(comms)))
+
+		node, err := tree.Subtree(comms, id)
+		if err != nil {
+			log.Error("cannot build comment tree", logger.Err(err))
+			if errors.Is(err, tree.ErrNodeNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "cannot receive comment", http.StatusInternalServerError)
+			return
+		}
+		tree.Sort(node.Childs, order)
+
+		if !writeJSON(w, log, http.StatusOK, node) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// countsRequest - тело запроса количества комментариев.
+type countsRequest struct {
+	PostIDs []string `json:"postIds"`
+}
+
+// countsResponse - ответ с количеством комментариев к каждому посту.
+type countsResponse struct {
+	Counts map[string]int `json:"counts"`
+}
+
+// Counts записывает в ResponseWriter количество комментариев к каждому
+// из постов, ID которых переданы в теле запроса. Количество постов
+// в одном запросе ограничено.
+func Counts(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Counts"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to count comments")
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		if !isJSON(r) {
+			log.Error("content-Type header is not application/json")
+			http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+		var req countsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Error("cannot decode request", logger.Err(err))
+			http.Error(w, "cannot decode request", http.StatusBadRequest)
+			return
+		}
+		if len(req.PostIDs) > maxLimit {
+			log.Error("too many post ids", slog.Int("count", len(req.PostIDs)))
+			http.Error(w, "too many post ids", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		counts, err := st.Counts(ctx, req.PostIDs)
+		if err != nil {
+			log.Error("cannot count comments", logger.Err(err))
+			if errors.Is(err, storage.ErrIncorrectPostID) {
+				http.Error(w, "incorrect post id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot count comments", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comments counted successfully", slog.Int("posts", len(counts)))
+
+		if !writeJSON(w, log, http.StatusOK, countsResponse{Counts: counts}) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// Pending записывает в ResponseWriter очередь комментариев, ожидающих
+// проверки модератором. Размер выдачи задается параметром limit.
+func Pending(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Pending"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive pending comments")
+
+		limit, err := parseLimit(r)
+		if err != nil {
+			log.Error("incorrect limit", logger.Err(err))
+			http.Error(w, "incorrect limit", http.StatusBadRequest)
+			return
+		}
 
-		root, err := tree.Build(comms)
+		ctx := r.Context()
+		comms, err := st.Pending(ctx, limit)
 		if err != nil {
-			log.Error("cannot build comments tree", logger.Err(err))
+			log.Error("cannot receive pending comments", logger.Err(err))
 			http.Error(w, "cannot receive comments", http.StatusInternalServerError)
 			return
 		}
+		log.Debug("pending comments received successfully", slog.Int("count", len(comms)))
+
+		if !writeJSON(w, log, http.StatusOK, comms) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// SetStatus устанавливает переданный статус модерации комментарию с ID
+// из пути запроса и записывает в ResponseWriter обновленный комментарий.
+func SetStatus(status string, st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.SetStatus"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to set comment status", slog.String("status", status))
+
+		id := r.PathValue("commentId")
+		if id == "" {
+			log.Error("empty comment id")
+			http.Error(w, "empty comment id", http.StatusBadRequest)
+			return
+		}
 
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "\t")
-		err = enc.Encode(root.Comments)
+		ctx := r.Context()
+		comm, err := st.SetStatus(ctx, id, status)
 		if err != nil {
-			log.Error("cannot encode comments", logger.Err(err))
-			http.Error(w, "cannot encode comments", http.StatusInternalServerError)
+			log.Error("cannot set comment status", logger.Err(err))
+			if errors.Is(err, storage.ErrCommentNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCommentID) {
+				http.Error(w, "incorrect comment id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot set comment status", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comment status set successfully", slog.String("id", id))
+
+		if !writeJSON(w, log, http.StatusOK, comm) {
 			return
 		}
 
 		log.Info("request served successfuly")
 	}
 }
+
+// UpdateComment заменяет текст комментария с ID из пути запроса. Автор
+// изменяет только свои комментарии, модератор - любые. Текст проверяется
+// так же, как в AddComment. Предыдущая версия текста сохраняется в истории
+// изменений. Записывает в ResponseWriter обновленный комментарий.
+func UpdateComment(ln int, mod *moderation.Pipeline, st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.UpdateComment"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to update comment")
+
+		id := r.PathValue("commentId")
+		if id == "" {
+			log.Error("empty comment id")
+			http.Error(w, "empty comment id", http.StatusBadRequest)
+			return
+		}
+
+		if !canEdit(w, r, log, st, id) {
+			return
+		}
+
+		comm, ok := readComment(w, r, log, ln, mod, true)
+		if !ok {
+			return
+		}
+		comm.ID = id
+
+		ctx := r.Context()
+		upd, err := st.UpdateComment(ctx, comm)
+		if err != nil {
+			log.Error("cannot update comment", logger.Err(err))
+			if errors.Is(err, storage.ErrCommentNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCommentID) {
+				http.Error(w, "incorrect comment id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot update the comment", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comment updated successfully", slog.String("id", id), slog.String("status", upd.Status))
+
+		if !writeJSON(w, log, http.StatusOK, upd) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// canEdit проверяет, что пользователь из токена может изменить комментарий
+// с переданным ID: модератор изменяет любые комментарии, остальные
+// пользователи - только комментарии, автором которых они указаны. Запрос
+// без токена не может изменить комментарий. При отказе или ошибке
+// записывает ответ в ResponseWriter и возвращает false.
+func canEdit(w http.ResponseWriter, r *http.Request, log *slog.Logger, st storage.DB, id string) bool {
+	ctx := r.Context()
+	if middleware.GetRole(ctx) >= middleware.RoleModerator {
+		return true
+	}
+
+	comm, err := st.Comment(ctx, id)
+	if err != nil {
+		log.Error("cannot get comment", logger.Err(err))
+		if errors.Is(err, storage.ErrCommentNotFound) {
+			http.Error(w, "comment not found", http.StatusNotFound)
+			return false
+		}
+		if errors.Is(err, storage.ErrIncorrectCommentID) {
+			http.Error(w, "incorrect comment id", http.StatusBadRequest)
+			return false
+		}
+		http.Error(w, "cannot update the comment", http.StatusInternalServerError)
+		return false
+	}
+
+	user := middleware.GetUserID(ctx)
+	if user == "" || comm.AuthorID != user {
+		log.Warn("access denied",
+			slog.String("user", user),
+			slog.String("author", comm.AuthorID),
+			slog.String("role", middleware.GetRole(ctx).String()),
+		)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// DeleteComment удаляет комментарий с ID из пути запроса. Комментарий
+// остается в дереве с текстом tree.DeletedContent, пока на него есть
+// неудаленные ответы.
+func DeleteComment(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.DeleteComment"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to delete comment")
+
+		id := r.PathValue("commentId")
+		if id == "" {
+			log.Error("empty comment id")
+			http.Error(w, "empty comment id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		_, err := st.DeleteComment(ctx, id)
+		if err != nil {
+			log.Error("cannot delete comment", logger.Err(err))
+			if errors.Is(err, storage.ErrCommentNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCommentID) {
+				http.Error(w, "incorrect comment id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot delete the comment", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comment deleted successfully", slog.String("id", id))
+
+		w.WriteHeader(http.StatusNoContent)
+		log.Info("request served successfuly")
+	}
+}
+
+// reaction - тело запроса на реакцию к комментарию.
+type reaction struct {
+	Reaction string `json:"reaction"`
+}
+
+// React записывает реакцию пользователя из токена на комментарий с ID
+// из пути запроса. Реакция передается в теле запроса в виде
+// {"reaction": "like"} или {"reaction": "dislike"} и заменяет предыдущую
+// реакцию пользователя. Записывает в ResponseWriter комментарий
+// с обновленным числом реакций.
+func React(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.React"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to react to comment")
+
+		if !isJSON(r) {
+			log.Error("content-Type header is not application/json")
+			http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+		var req reaction
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Error("cannot decode request", logger.Err(err))
+			http.Error(w, "cannot decode request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		user, ok := requireUser(w, r, log)
+		if !ok {
+			return
+		}
+		comm, err := st.React(ctx, r.PathValue("commentId"), user, req.Reaction)
+		if err != nil {
+			log.Error("cannot react to comment", logger.Err(err))
+			e := reactError(err)
+			http.Error(w, e.msg, e.code)
+			return
+		}
+		log.Debug("reaction added successfully", slog.String("id", comm.ID), slog.String("reaction", req.Reaction))
+
+		if !writeJSON(w, log, http.StatusOK, comm) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// Unreact удаляет реакцию пользователя из токена на комментарий с ID
+// из пути запроса. Записывает в ResponseWriter комментарий с обновленным
+// числом реакций.
+func Unreact(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Unreact"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to remove reaction")
+
+		ctx := r.Context()
+		user, ok := requireUser(w, r, log)
+		if !ok {
+			return
+		}
+		comm, err := st.Unreact(ctx, r.PathValue("commentId"), user)
+		if err != nil {
+			log.Error("cannot remove reaction", logger.Err(err))
+			e := reactError(err)
+			http.Error(w, e.msg, e.code)
+			return
+		}
+		log.Debug("reaction removed successfully", slog.String("id", comm.ID))
+
+		if !writeJSON(w, log, http.StatusOK, comm) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// report - тело запроса с жалобой на комментарий.
+type report struct {
+	Reason string `json:"reason"`
+}
+
+// Report записывает жалобу пользователя из токена на комментарий с ID
+// из пути запроса. Причина передается в теле запроса в виде
+// {"reason": "spam"}. Пользователь может пожаловаться на комментарий
+// один раз. Когда число жалоб достигает порога threshold, комментарий
+// скрывается от читателей до проверки модератором.
+func Report(threshold int, st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Report"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to report comment")
+
+		if !isJSON(r) {
+			log.Error("content-Type header is not application/json")
+			http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+		var req report
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Error("cannot decode request", logger.Err(err))
+			http.Error(w, "cannot decode request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		user, ok := requireUser(w, r, log)
+		if !ok {
+			return
+		}
+		comm, err := st.Report(ctx, r.PathValue("commentId"), user, req.Reason, threshold)
+		if err != nil {
+			log.Error("cannot report comment", logger.Err(err))
+			e := reportError(err)
+			http.Error(w, e.msg, e.code)
+			return
+		}
+		log.Debug("comment reported successfully",
+			slog.String("id", comm.ID),
+			slog.String("reason", req.Reason),
+			slog.Int("reports", comm.Reports),
+		)
+		if comm.Status == storage.StatusPending {
+			log.Warn("comment hidden until review", slog.String("id", comm.ID), slog.Int("reports", comm.Reports))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		log.Info("request served successfuly")
+	}
+}
+
+// Reported записывает в ResponseWriter комментарии с наибольшим числом
+// жалоб вместе с числом жалоб по каждой причине.
+func Reported(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Reported"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive reported comments")
+
+		limit, err := parseLimit(r)
+		if err != nil {
+			log.Error("incorrect limit", logger.Err(err))
+			http.Error(w, "incorrect limit", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		list, err := st.Reported(ctx, limit)
+		if err != nil {
+			log.Error("cannot receive reported comments", logger.Err(err))
+			http.Error(w, "cannot receive comments", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("reported comments received successfully", slog.Int("count", len(list)))
+
+		if !writeJSON(w, log, http.StatusOK, list) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// History записывает в ResponseWriter предыдущие версии текста
+// комментария с ID из пути запроса.
+func History(st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.History"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive comment history")
+
+		id := r.PathValue("commentId")
+		if id == "" {
+			log.Error("empty comment id")
+			http.Error(w, "empty comment id", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		revs, err := st.History(ctx, id)
+		if err != nil {
+			log.Error("cannot receive comment history", logger.Err(err))
+			if errors.Is(err, storage.ErrCommentNotFound) {
+				http.Error(w, "comment not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrIncorrectCommentID) {
+				http.Error(w, "incorrect comment id", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "cannot receive comment history", http.StatusInternalServerError)
+			return
+		}
+		log.Debug("comment history received successfully", slog.Int("count", len(revs)))
+
+		if !writeJSON(w, log, http.StatusOK, revs) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// Stream отправляет клиенту новые комментарии к посту в виде потока
+// Server-Sent Events. ID события - ID комментария. Если клиент передал
+// заголовок Last-Event-ID, то сначала отправляются комментарии, записанные
+// после комментария с этим ID. Поток завершается при отключении клиента,
+// остановке сервера или если клиент не успевает читать события.
+func Stream(b *events.Broker, st storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Stream"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to stream comments")
+
+		id := r.PathValue("id")
+		if !storage.ValidID(id) {
+			log.Error("incorrect post id", slog.String("post_id", id))
+			http.Error(w, "incorrect post id", http.StatusBadRequest)
+			return
+		}
+
+		// Поток открыт дольше, чем таймаут записи сервера.
+		rc := http.NewResponseController(w)
+		err := rc.SetWriteDeadline(time.Time{})
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Error("cannot reset write deadline", logger.Err(err))
+		}
+
+		// Подписываемся до чтения пропущенных комментариев, чтобы
+		// не потерять записанные в это время.
+		sub, cancel := b.Subscribe(id)
+		defer cancel()
+
+		ctx := r.Context()
+		var missed []storage.Comment
+		if last := r.Header.Get("Last-Event-ID"); storage.ValidID(last) {
+			missed, err = since(ctx, st, id, last)
+			if err != nil {
+				log.Error("cannot receive missed comments", logger.Err(err))
+				http.Error(w, "cannot receive comments", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		sent := make(map[string]struct{}, len(missed))
+		for _, com := range missed {
+			if !writeEvent(w, log, com) {
+				return
+			}
+			sent[com.ID] = struct{}{}
+		}
+		rc.Flush()
+		log.Debug("stream opened", slog.String("post_id", id), slog.Int("missed", len(missed)))
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("request served successfuly")
+				return
+			case ev, ok := <-sub:
+				if !ok {
+					log.Info("stream closed by server")
+					return
+				}
+				if ev.Type != storage.EventNew {
+					continue
+				}
+				if _, ok := sent[ev.Comment.ID]; ok {
+					continue
+				}
+				if !writeEvent(w, log, ev.Comment) {
+					return
+				}
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": ping\n\n")
+				if err != nil {
+					log.Info("client disconnected", logger.Err(err))
+					return
+				}
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// CacheStats записывает в ResponseWriter статистику обращений к кэшу
+// комментариев.
+func CacheStats(c *cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.CacheStats"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to receive cache stats")
+
+		if !writeJSON(w, log, http.StatusOK, c.Stats()) {
+			return
+		}
+
+		log.Info("request served successfuly")
+	}
+}
+
+// readComment читает комментарий из тела запроса и проверяет его текст:
+// заголовок "Content-Type", непустой текст, длину не более ln символов
+// и цепочку проверок модерации. Возвращает комментарий с текстом после
+// маскировки и статусом модерации, статус для edit - как в checkComment.
+// При ошибке записывает ответ в ResponseWriter и возвращает false.
+func readComment(w http.ResponseWriter, r *http.Request, log *slog.Logger, ln int, mod *moderation.Pipeline, edit bool) (storage.Comment, bool) {
+	var comm storage.Comment
+
+	if !isJSON(r) {
+		log.Error("content-Type header is not application/json")
+		http.Error(w, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+		return comm, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	err := json.NewDecoder(r.Body).Decode(&comm)
+	if err != nil {
+		log.Error("cannot decode request", logger.Err(err))
+		http.Error(w, "cannot decode request", http.StatusBadRequest)
+		return comm, false
+	}
+	log.Debug("request body decoded")
+
+	comm = withAuthor(r.Context(), comm)
+	comm, e := checkComment(comm, log, ln, mod, edit)
+	if e != nil {
+		http.Error(w, e.msg, e.code)
+		return comm, false
+	}
+
+	return comm, true
+}
+
+// requireUser возвращает ID пользователя из токена. Если токена нет,
+// то записывает в ResponseWriter ответ 401 и возвращает false.
+func requireUser(w http.ResponseWriter, r *http.Request, log *slog.Logger) (string, bool) {
+	user := middleware.GetUserID(r.Context())
+	if user == "" {
+		log.Error("request without user token")
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// withAuthor заполняет автора комментария из токена пользователя. Автор
+// из тела запроса не учитывается, без токена комментарий анонимный.
+func withAuthor(ctx context.Context, comm storage.Comment) storage.Comment {
+	comm.AuthorID, comm.AuthorName = "", ""
+	if claims := middleware.GetClaims(ctx); claims != nil {
+		comm.AuthorID, comm.AuthorName = claims.Subject, claims.Name
+	}
+	return comm
+}
+
+// apiError - ошибка запроса с кодом ответа и сообщением для клиента.
+type apiError struct {
+	code int
+	msg  string
+}
+
+// checkComment проверяет длину текста комментария и пропускает его через
+// цепочку проверок модерации. Возвращает комментарий с текстом после
+// маскировки и статусом модерации. Для измененного комментария (edit)
+// статус пустой, если текст не отмечен для проверки модератором: хранилище
+// сохраняет прежний статус.
+func checkComment(comm storage.Comment, log *slog.Logger, ln int, mod *moderation.Pipeline, edit bool) (storage.Comment, *apiError) {
+	if comm.Content == "" {
+		log.Error("comment has empty content field")
+		return comm, &apiError{http.StatusBadRequest, "empty comment"}
+	}
+	if len([]rune(comm.Content)) > ln {
+		log.Error("comment content field has more than 1000 characters")
+		return comm, &apiError{http.StatusBadRequest, "the length of the comment must not exceed 1000 characters"}
+	}
+	if !storage.ValidAuthor(comm.AuthorID, comm.AuthorName) {
+		log.Error("incorrect comment author", slog.String("author_id", comm.AuthorID))
+		return comm, &apiError{http.StatusBadRequest, "incorrect author"}
+	}
+
+	verdict := mod.Moderate(comm.Content)
+	for _, hit := range verdict.Hits {
+		log.Warn("moderation rule fired",
+			slog.String("check", hit.Check),
+			slog.String("rule", hit.Rule),
+			slog.String("action", hit.Action.String()),
+		)
+	}
+	if verdict.Rejected() {
+		log.Error("comment rejected by moderation")
+		return comm, &apiError{http.StatusUnprocessableEntity, "comment rejected by moderation"}
+	}
+	if verdict.Flagged() {
+		log.Warn("comment flagged for review")
+	}
+	comm.Content = verdict.Content
+	comm.Status = verdict.Status
+	// При изменении текста статус комментария сохраняется, на проверку
+	// модератором отправляется только отмеченный текст.
+	if edit && !verdict.Flagged() {
+		comm.Status = ""
+	}
+
+	return comm, nil
+}
+
+// addError возвращает код ответа и сообщение для клиента по ошибке записи
+// нового комментария в БД.
+func addError(err error) *apiError {
+	switch {
+	case errors.Is(err, storage.ErrIncorrectParentID), errors.Is(err, storage.ErrIncorrectPostID):
+		return &apiError{http.StatusBadRequest, "incorrect data"}
+	case errors.Is(err, storage.ErrIncorrectAuthor):
+		return &apiError{http.StatusBadRequest, "incorrect author"}
+	case errors.Is(err, storage.ErrParentNotFound):
+		return &apiError{http.StatusNotFound, "parent comment not found"}
+	default:
+		return &apiError{http.StatusInternalServerError, "cannot add the comment"}
+	}
+}
+
+// reactError возвращает код ответа и сообщение для клиента по ошибке
+// записи реакции на комментарий.
+func reactError(err error) *apiError {
+	switch {
+	case errors.Is(err, storage.ErrCommentNotFound):
+		return &apiError{http.StatusNotFound, "comment not found"}
+	case errors.Is(err, storage.ErrIncorrectCommentID):
+		return &apiError{http.StatusBadRequest, "incorrect comment id"}
+	case errors.Is(err, storage.ErrIncorrectUserID):
+		return &apiError{http.StatusBadRequest, "incorrect user id"}
+	case errors.Is(err, storage.ErrIncorrectReaction):
+		return &apiError{http.StatusBadRequest, "incorrect reaction"}
+	default:
+		return &apiError{http.StatusInternalServerError, "cannot save the request"}
+	}
+}
+
+// reportError возвращает код ответа и сообщение для клиента по ошибке
+// записи жалобы на комментарий.
+func reportError(err error) *apiError {
+	switch {
+	case errors.Is(err, storage.ErrAlreadyReported):
+		return &apiError{http.StatusConflict, "comment already reported"}
+	case errors.Is(err, storage.ErrIncorrectReason):
+		return &apiError{http.StatusBadRequest, "incorrect reason"}
+	default:
+		return reactError(err)
+	}
+}
+
+// isJSON проверяет, что заголовок "Content-Type" запроса начинается
+// со значения "application/json".
+func isJSON(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	media := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	return media == "application/json"
+}
+
+// since возвращает видимые читателям неудаленные комментарии к посту,
+// записанные после комментария с переданным ID, начиная с самого старого.
+// Комментарии сравниваются по дате создания, при равной дате - по ID.
+// Если комментария с переданным ID нет, то пропущенных комментариев нет.
+func since(ctx context.Context, st storage.DB, post, last string) ([]storage.Comment, error) {
+	prev, err := st.Comment(ctx, last)
+	if err != nil {
+		if errors.Is(err, storage.ErrCommentNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	comms, err := st.Comments(ctx, post)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoComments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var missed []storage.Comment
+	for _, com := range comms {
+		if com.Deleted || com.PubTime.Before(prev.PubTime) {
+			continue
+		}
+		if com.PubTime.Equal(prev.PubTime) && com.ID <= prev.ID {
+			continue
+		}
+		missed = append(missed, com)
+	}
+	sort.Slice(missed, func(i, j int) bool {
+		if !missed[i].PubTime.Equal(missed[j].PubTime) {
+			return missed[i].PubTime.Before(missed[j].PubTime)
+		}
+		return missed[i].ID < missed[j].ID
+	})
+	return missed, nil
+}
+
+// writeEvent записывает комментарий в поток Server-Sent Events. Если запись
+// не удалась, то возвращает false.
+func writeEvent(w http.ResponseWriter, log *slog.Logger, com storage.Comment) bool {
+	data, err := json.Marshal(com)
+	if err != nil {
+		log.Error("cannot encode event", logger.Err(err))
+		return false
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: comment\ndata: %s\n\n", com.ID, data)
+	if err != nil {
+		log.Info("client disconnected", logger.Err(err))
+		return false
+	}
+	return true
+}
+
+// writeJSON кодирует переданное значение в JSON и записывает его
+// в ResponseWriter с переданным кодом ответа. При ошибке кодирования
+// записывает ответ с ошибкой и возвращает false.
+func writeJSON(w http.ResponseWriter, log *slog.Logger, code int, v any) bool {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		log.Error("cannot encode response", logger.Err(err))
+		http.Error(w, "cannot encode response", http.StatusInternalServerError)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+	return true
+}
+
+// parseLimit возвращает значение параметра limit из запроса. Если параметр
+// не передан, то возвращает значение по умолчанию. Значение больше
+// максимального уменьшается до максимального.
+func parseLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 {
+		return 0, errors.New("limit must be positive")
+	}
+	return min(limit, maxLimit), nil
+}
@@ -2,11 +2,16 @@
 package server
 
 import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/logger"
+	"GoExamComments/internal/middleware"
 	"GoExamComments/internal/mocks"
+	"GoExamComments/internal/moderation"
 	"GoExamComments/internal/storage"
 	"GoExamComments/internal/tree"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,12 +19,28 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
 
 var comment = storage.Comment{ParentID: "", PostID: "news1", Content: "The content of the test comment."}
 
+// withUser возвращает запрос с токеном пользователя в контексте, как после
+// middleware.Auth.
+func withUser(r *http.Request, user string) *http.Request {
+	return withRole(r, user, "")
+}
+
+// withRole возвращает запрос с токеном пользователя с переданной ролью
+// в контексте.
+func withRole(r *http.Request, user, role string) *http.Request {
+	claims := &middleware.Claims{Name: "User", Role: role}
+	claims.Subject = user
+	ctx := context.WithValue(r.Context(), middleware.ClaimsKey, claims)
+	return r.WithContext(ctx)
+}
+
 func TestAddComment(t *testing.T) {
 	logger.Discard()
 
@@ -32,9 +53,11 @@ func TestAddComment(t *testing.T) {
 		name    string
 		header  string
 		len     int
+		censor  []string
 		comment []byte
 		respErr string
 		mockErr error
+		readErr error
 	}{
 		{
 			name:    "Comment_OK",
@@ -44,6 +67,15 @@ func TestAddComment(t *testing.T) {
 			respErr: "",
 			mockErr: nil,
 		},
+		{
+			// Если добавленный комментарий не удается прочитать, то
+			// в ответе комментарий из запроса.
+			name:    "Read_error",
+			header:  "Application/json",
+			len:     1000,
+			comment: comm,
+			readErr: errors.New("DB error"),
+		},
 		{
 			name:    "Comment_content_type",
 			header:  "Other_content_type",
@@ -68,6 +100,23 @@ func TestAddComment(t *testing.T) {
 			respErr: "cannot decode request",
 			mockErr: nil,
 		},
+		{
+			name:    "Comment_censored",
+			header:  "Application/json",
+			len:     1000,
+			censor:  []string{"t3st"},
+			comment: comm,
+			respErr: "comment rejected by moderation",
+			mockErr: nil,
+		},
+		{
+			name:    "Parent_not_found",
+			header:  "Application/json",
+			len:     1000,
+			comment: comm,
+			respErr: "parent comment not found",
+			mockErr: storage.ErrParentNotFound,
+		},
 		{
 			name:    "DB_error",
 			header:  "Application/json",
@@ -93,9 +142,20 @@ func TestAddComment(t *testing.T) {
 					Return("comment_id", tt.mockErr).
 					Once()
 			}
+			stored := comment
+			stored.ID = "comment_id"
+			stored.PubTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			if tt.respErr == "" {
+				stMock.
+					On("Comment", mock.Anything, "comment_id").
+					Return(stored, tt.readErr).
+					Once()
+			}
+
+			mod := moderation.New(&config.Config{CensorList: tt.censor})
 
 			mux := http.NewServeMux()
-			mux.HandleFunc("POST /comments/new", AddComment(tt.len, stMock))
+			mux.HandleFunc("POST /comments/new", AddComment(tt.len, mod, stMock, nil))
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
@@ -117,8 +177,134 @@ func TestAddComment(t *testing.T) {
 				t.Fatalf("AddComment() error = %s, want %s", body, tt.respErr)
 			}
 
-			if body != "" {
-				t.Errorf("AddComment() error = not empty body")
+			if loc := rr.Header().Get("Location"); loc != "/comment/comment_id" {
+				t.Errorf("AddComment() Location = %q, want %q", loc, "/comment/comment_id")
+			}
+			var got storage.Comment
+			err := json.Unmarshal([]byte(body), &got)
+			if err != nil {
+				t.Fatalf("AddComment() cannot decode body: %s", err.Error())
+			}
+			want := stored
+			if tt.readErr != nil {
+				want = comment
+				want.ID = "comment_id"
+			}
+			if !got.PubTime.Equal(want.PubTime) || got.ID != want.ID || got.Content != want.Content {
+				t.Errorf("AddComment() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddComment_Author(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name   string
+		user   string
+		author string
+	}{
+		{name: "From_Token", user: "alice", author: "User"},
+		{name: "Anonymous"},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Автор из тела запроса не учитывается.
+			stMock := mocks.NewDB(t)
+			stMock.
+				On("AddComment", mock.Anything, mock.MatchedBy(func(com storage.Comment) bool {
+					return com.AuthorID == tt.user && com.AuthorName == tt.author
+				})).
+				Return("comment_id", nil).
+				Once()
+			stMock.
+				On("Comment", mock.Anything, "comment_id").
+				Return(storage.Comment{ID: "comment_id"}, nil).
+				Once()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /comments/new", AddComment(1000, nil, stMock, nil))
+
+			body := `{"postId": "news1", "authorId": "mallory", "authorName": "Mallory", "content": "Hello"}`
+			req := httptest.NewRequest(http.MethodPost, "/comments/new", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.user != "" {
+				req = withUser(req, tt.user)
+			}
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Errorf("AddComment() code = %d, want %d", rr.Code, http.StatusCreated)
+			}
+		})
+	}
+}
+
+func TestAddComment_Idempotency(t *testing.T) {
+	logger.Discard()
+
+	stMock := mocks.NewDB(t)
+	stMock.
+		On("Comment", mock.Anything, "comment_id").
+		Return(storage.Comment{ID: "comment_id", Content: "Hello"}, nil)
+	cfg := &config.Config{Idempotency: config.Idempotency{KeyTTL: time.Hour}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /comments/new", AddComment(1000, nil, stMock, idempotency.New(cfg, idempotency.NewMemory())))
+
+	// Тест-кейсы выполняются последовательно и используют одни и те же
+	// ключи. Мок вызывается только в тест-кейсах с db.
+	tests := []struct {
+		name     string
+		key      string
+		user     string
+		content  string
+		db       bool
+		mockErr  error
+		code     int
+		replayed bool
+	}{
+		{name: "First", key: "k1", user: "alice", content: "Hello", db: true, code: http.StatusCreated},
+		{name: "Replay", key: "k1", user: "alice", content: "Hello", code: http.StatusCreated, replayed: true},
+		{name: "Different_Body", key: "k1", user: "alice", content: "Bye", code: http.StatusConflict},
+		{name: "Long_Key", key: strings.Repeat("k", idempotency.MaxKey+1), user: "alice", content: "Hello", code: http.StatusBadRequest},
+		{name: "Other_User", key: "k1", user: "bob", content: "Hello", db: true, mockErr: errors.New("DB error"), code: http.StatusInternalServerError},
+		{name: "Retry_After_Error", key: "k1", user: "bob", content: "Hello", db: true, code: http.StatusCreated},
+		{name: "Replay_After_Retry", key: "k1", user: "bob", content: "Hello", code: http.StatusCreated, replayed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db {
+				stMock.
+					On("AddComment", mock.Anything, mock.AnythingOfType("storage.Comment")).
+					Return("comment_id", tt.mockErr).
+					Once()
+			}
+
+			body := `{"postId": "news1", "content": "` + tt.content + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/comments/new", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", tt.key)
+			req = withUser(req, tt.user)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("AddComment() code = %d, want %d", rr.Code, tt.code)
+			}
+			if got := rr.Header().Get("Idempotent-Replayed") == "true"; got != tt.replayed {
+				t.Errorf("AddComment() replayed = %v, want %v", got, tt.replayed)
+			}
+			// Повтор получает тот же комментарий, что и первый запрос.
+			if tt.code == http.StatusCreated && rr.Header().Get("Location") != "/comment/comment_id" {
+				t.Errorf("AddComment() Location = %q, want %q", rr.Header().Get("Location"), "/comment/comment_id")
 			}
 		})
 	}
@@ -164,7 +350,7 @@ func TestComments(t *testing.T) {
 			}
 
 			mux := http.NewServeMux()
-			mux.HandleFunc("GET /comments/{id}", Comments(stMock))
+			mux.HandleFunc("GET /comments/{id}", Comments(tree.Drop, stMock))
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
@@ -200,3 +386,1429 @@ func TestComments(t *testing.T) {
 		})
 	}
 }
+
+func TestPending(t *testing.T) {
+	logger.Discard()
+
+	comm := []storage.Comment{{ID: "com-1", PostID: "news1", Content: "Pending", Status: storage.StatusPending}}
+
+	tests := []struct {
+		name    string
+		query   string
+		limit   int
+		code    int
+		mockErr error
+	}{
+		{
+			name:    "Pending_OK",
+			query:   "",
+			limit:   defaultLimit,
+			code:    http.StatusOK,
+			mockErr: nil,
+		},
+		{
+			name:    "Limit_Max",
+			query:   "?limit=100000",
+			limit:   maxLimit,
+			code:    http.StatusOK,
+			mockErr: nil,
+		},
+		{
+			name:    "Incorrect_Limit",
+			query:   "?limit=abc",
+			code:    http.StatusBadRequest,
+			mockErr: nil,
+		},
+		{
+			name:    "DB_error",
+			query:   "?limit=10",
+			limit:   10,
+			code:    http.StatusInternalServerError,
+			mockErr: errors.New("DB error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.limit > 0 {
+				stMock.
+					On("Pending", mock.Anything, tt.limit).
+					Return(comm, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /admin/comments/pending", Pending(stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/comments/pending"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Pending() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			resp := []storage.Comment{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Pending() error = cannot unmarshal response")
+			}
+			if len(resp) != len(comm) {
+				t.Errorf("Pending() len = %d, want %d", len(resp), len(comm))
+			}
+		})
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name    string
+		status  string
+		code    int
+		mockErr error
+	}{
+		{
+			name:    "Approve_OK",
+			status:  storage.StatusApproved,
+			code:    http.StatusOK,
+			mockErr: nil,
+		},
+		{
+			name:    "Reject_OK",
+			status:  storage.StatusRejected,
+			code:    http.StatusOK,
+			mockErr: nil,
+		},
+		{
+			name:    "Not_Found",
+			status:  storage.StatusApproved,
+			code:    http.StatusNotFound,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "Incorrect_ID",
+			status:  storage.StatusApproved,
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCommentID,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			stMock.
+				On("SetStatus", mock.Anything, "com-1", tt.status).
+				Return(storage.Comment{ID: "com-1", Status: tt.status}, tt.mockErr).
+				Once()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /admin/comments/{commentId}/status", SetStatus(tt.status, stMock))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/comments/com-1/status", nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("SetStatus() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp storage.Comment
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("SetStatus() error = cannot unmarshal response")
+			}
+			if resp.Status != tt.status {
+				t.Errorf("SetStatus() status = %s, want %s", resp.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestUpdateComment(t *testing.T) {
+	logger.Discard()
+
+	body, err := json.Marshal(storage.Comment{Content: "Fixed typo"})
+	if err != nil {
+		t.Fatalf("cannot encode comment, error = %s", err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		len     int
+		body    []byte
+		user    string
+		role    string
+		author  string
+		code    int
+		callDB  bool
+		status  string
+		mockErr error
+	}{
+		{
+			// Статус не передается, хранилище сохраняет прежний.
+			name:    "Update_OK",
+			len:     1000,
+			body:    body,
+			user:    "mod",
+			role:    "moderator",
+			code:    http.StatusOK,
+			callDB:  true,
+			mockErr: nil,
+		},
+		{
+			name:   "Flagged",
+			len:    1000,
+			body:   []byte(`{"content": "Fixed spam"}`),
+			user:   "mod",
+			role:   "moderator",
+			code:   http.StatusOK,
+			callDB: true,
+			status: storage.StatusPending,
+		},
+		{
+			name:   "Own_Comment",
+			len:    1000,
+			body:   body,
+			user:   "alice",
+			author: "alice",
+			code:   http.StatusOK,
+			callDB: true,
+		},
+		{
+			name:   "Other_Comment",
+			len:    1000,
+			body:   body,
+			user:   "bob",
+			author: "alice",
+			code:   http.StatusForbidden,
+			callDB: false,
+		},
+		{
+			name:   "Anonymous",
+			len:    1000,
+			body:   body,
+			author: "",
+			code:   http.StatusForbidden,
+			callDB: false,
+		},
+		{
+			name:    "Comment_length",
+			len:     5,
+			body:    body,
+			user:    "mod",
+			role:    "moderator",
+			code:    http.StatusBadRequest,
+			callDB:  false,
+			mockErr: nil,
+		},
+		{
+			name:    "Comment_empty",
+			len:     1000,
+			body:    []byte(`{"content": ""}`),
+			user:    "mod",
+			role:    "moderator",
+			code:    http.StatusBadRequest,
+			callDB:  false,
+			mockErr: nil,
+		},
+		{
+			name:    "Not_Found",
+			len:     1000,
+			body:    body,
+			user:    "mod",
+			role:    "moderator",
+			code:    http.StatusNotFound,
+			callDB:  true,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "DB_error",
+			len:     1000,
+			body:    body,
+			user:    "mod",
+			role:    "moderator",
+			code:    http.StatusInternalServerError,
+			callDB:  true,
+			mockErr: errors.New("DB error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.role != "moderator" {
+				stMock.
+					On("Comment", mock.Anything, "com-1").
+					Return(storage.Comment{ID: "com-1", AuthorID: tt.author}, nil).
+					Once()
+			}
+			if tt.callDB {
+				stMock.
+					On("UpdateComment", mock.Anything, mock.MatchedBy(func(c storage.Comment) bool {
+						return c.ID == "com-1" && c.Status == tt.status
+					})).
+					Return(storage.Comment{ID: "com-1", Content: "Fixed typo"}, tt.mockErr).
+					Once()
+			}
+
+			mod := moderation.New(&config.Config{Moderation: config.Moderation{
+				Rules: []config.Rule{{Name: "spam", Pattern: "spam", Action: "flag"}},
+			}})
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /comments/{commentId}", UpdateComment(tt.len, mod, stMock))
+
+			req := httptest.NewRequest(http.MethodPut, "/comments/com-1", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.user != "" {
+				req = withRole(req, tt.user, tt.role)
+			}
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("UpdateComment() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp storage.Comment
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("UpdateComment() error = cannot unmarshal response")
+			}
+			if resp.Content != "Fixed typo" {
+				t.Errorf("UpdateComment() content = %s, want %s", resp.Content, "Fixed typo")
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	logger.Discard()
+
+	revs := []storage.Revision{{Content: "First version"}, {Content: "Second version"}}
+
+	tests := []struct {
+		name    string
+		code    int
+		mockErr error
+	}{
+		{
+			name:    "History_OK",
+			code:    http.StatusOK,
+			mockErr: nil,
+		},
+		{
+			name:    "Not_Found",
+			code:    http.StatusNotFound,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "Incorrect_ID",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCommentID,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			stMock.
+				On("History", mock.Anything, "com-1").
+				Return(revs, tt.mockErr).
+				Once()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /admin/comments/{commentId}/history", History(stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/comments/com-1/history", nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("History() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			resp := []storage.Revision{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("History() error = cannot unmarshal response")
+			}
+			if len(resp) != len(revs) {
+				t.Errorf("History() len = %d, want %d", len(resp), len(revs))
+			}
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name    string
+		code    int
+		mockErr error
+	}{
+		{
+			name:    "Delete_OK",
+			code:    http.StatusNoContent,
+			mockErr: nil,
+		},
+		{
+			name:    "Not_Found",
+			code:    http.StatusNotFound,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "Incorrect_ID",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCommentID,
+		},
+		{
+			name:    "DB_error",
+			code:    http.StatusInternalServerError,
+			mockErr: errors.New("DB error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			stMock.
+				On("DeleteComment", mock.Anything, "com-1").
+				Return(storage.Comment{ID: "com-1", Deleted: true}, tt.mockErr).
+				Once()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("DELETE /comments/{commentId}", DeleteComment(stMock))
+
+			req := httptest.NewRequest(http.MethodDelete, "/comments/com-1", nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Errorf("DeleteComment() code = %d, want %d", rr.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestComments_Page(t *testing.T) {
+	logger.Discard()
+
+	pg := storage.Page{
+		Comments: []storage.Comment{
+			{ID: "com-2", PostID: "news1", Content: "Root"},
+			{ID: "com-3", ParentID: "com-2", PostID: "news1", Content: "Reply"},
+		},
+		NextCursor: "next",
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		limit   int
+		cursor  string
+		oldest  bool
+		code    int
+		roots   int
+		mockErr error
+	}{
+		{
+			name:    "Page_OK",
+			query:   "?limit=1",
+			limit:   1,
+			cursor:  "",
+			code:    http.StatusOK,
+			roots:   1,
+			mockErr: nil,
+		},
+		{
+			name:    "Cursor_Default_Limit",
+			query:   "?cursor=abc",
+			limit:   defaultLimit,
+			cursor:  "abc",
+			code:    http.StatusOK,
+			roots:   1,
+			mockErr: nil,
+		},
+		{
+			name:    "Incorrect_Cursor",
+			query:   "?cursor=abc",
+			limit:   defaultLimit,
+			cursor:  "abc",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCursor,
+		},
+		{
+			name:    "Page_Oldest",
+			query:   "?limit=1&sort=old",
+			limit:   1,
+			cursor:  "",
+			oldest:  true,
+			code:    http.StatusOK,
+			roots:   1,
+			mockErr: nil,
+		},
+		{
+			name:  "Incorrect_Limit",
+			query: "?limit=-1",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "Page_Sort_Top",
+			query: "?limit=1&sort=top",
+			code:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.limit > 0 {
+				stMock.
+					On("Threads", mock.Anything, "news1", tt.limit, tt.cursor, tt.oldest).
+					Return(pg, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /comments/{id}", Comments(tree.Drop, stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/comments/news1"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Comments() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp page
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Comments() error = cannot unmarshal response")
+			}
+			if len(resp.Comments) != tt.roots {
+				t.Errorf("Comments() roots = %d, want %d", len(resp.Comments), tt.roots)
+			}
+			if resp.NextCursor != pg.NextCursor {
+				t.Errorf("Comments() nextCursor = %s, want %s", resp.NextCursor, pg.NextCursor)
+			}
+		})
+	}
+}
+
+func TestCounts(t *testing.T) {
+	logger.Discard()
+
+	counts := map[string]int{"news1": 3, "news2": 0}
+	many := make([]string, maxLimit+1)
+
+	tests := []struct {
+		name    string
+		header  string
+		body    any
+		code    int
+		callDB  bool
+		mockErr error
+	}{
+		{
+			name:    "Counts_OK",
+			header:  "application/json",
+			body:    countsRequest{PostIDs: []string{"news1", "news2"}},
+			code:    http.StatusOK,
+			callDB:  true,
+			mockErr: nil,
+		},
+		{
+			name:   "Content_type",
+			header: "text/plain",
+			body:   countsRequest{PostIDs: []string{"news1"}},
+			code:   http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "Too_many_posts",
+			header: "application/json",
+			body:   countsRequest{PostIDs: many},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:    "Incorrect_Post_ID",
+			header:  "application/json",
+			body:    countsRequest{PostIDs: []string{"news1", "news2"}},
+			code:    http.StatusBadRequest,
+			callDB:  true,
+			mockErr: storage.ErrIncorrectPostID,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.callDB {
+				stMock.
+					On("Counts", mock.Anything, []string{"news1", "news2"}).
+					Return(counts, tt.mockErr).
+					Once()
+			}
+
+			body, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatalf("cannot encode request, error = %s", err.Error())
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /comments/counts", Counts(stMock))
+
+			req := httptest.NewRequest(http.MethodPost, "/comments/counts", bytes.NewReader(body))
+			req.Header.Set("Content-Type", tt.header)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Counts() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp countsResponse
+			err = json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Counts() error = cannot unmarshal response")
+			}
+			if resp.Counts["news1"] != 3 || len(resp.Counts) != 2 {
+				t.Errorf("Counts() = %v, want %v", resp.Counts, counts)
+			}
+		})
+	}
+}
+
+func TestComment(t *testing.T) {
+	logger.Discard()
+
+	comms := []storage.Comment{
+		{ID: "com-1", ParentID: "com-0", PostID: "news1", Content: "Comment"},
+		{ID: "com-2", ParentID: "com-1", PostID: "news1", Content: "Reply"},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		depth   int
+		code    int
+		childs  int
+		callDB  bool
+		mockErr error
+	}{
+		{
+			name:    "Comment_OK",
+			query:   "",
+			depth:   0,
+			code:    http.StatusOK,
+			childs:  0,
+			callDB:  true,
+			mockErr: nil,
+		},
+		{
+			name:    "Depth_OK",
+			query:   "?depth=2",
+			depth:   2,
+			code:    http.StatusOK,
+			childs:  1,
+			callDB:  true,
+			mockErr: nil,
+		},
+		{
+			name:   "Incorrect_Depth",
+			query:  "?depth=-1",
+			code:   http.StatusBadRequest,
+			callDB: false,
+		},
+		{
+			name:    "Not_Found",
+			query:   "",
+			depth:   0,
+			code:    http.StatusNotFound,
+			callDB:  true,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "Incorrect_ID",
+			query:   "",
+			depth:   0,
+			code:    http.StatusBadRequest,
+			callDB:  true,
+			mockErr: storage.ErrIncorrectCommentID,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.callDB {
+				ret := comms[:1]
+				if tt.depth > 0 {
+					ret = comms
+				}
+				stMock.
+					On("Subtree", mock.Anything, "com-1", tt.depth).
+					Return(ret, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /comment/{commentId}", Comment(stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/comment/com-1"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Comment() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp tree.Node
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Comment() error = cannot unmarshal response")
+			}
+			if resp.ID != "com-1" || len(resp.Childs) != tt.childs {
+				t.Errorf("Comment() = %s with %d childs, want %s with %d", resp.ID, len(resp.Childs), "com-1", tt.childs)
+			}
+		})
+	}
+}
+
+func TestComments_Sort(t *testing.T) {
+	logger.Discard()
+
+	tm := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	comms := []storage.Comment{
+		{ID: "com-2", PostID: "news1", PubTime: tm.Add(time.Minute)},
+		{ID: "com-1", PostID: "news1", PubTime: tm},
+	}
+
+	tests := []struct {
+		name  string
+		sort  string
+		code  int
+		first string
+	}{
+		{
+			name:  "Default_Newest",
+			sort:  "",
+			code:  http.StatusOK,
+			first: "com-2",
+		},
+		{
+			name:  "Oldest",
+			sort:  "old",
+			code:  http.StatusOK,
+			first: "com-1",
+		},
+		{
+			name: "Incorrect_Sort",
+			sort: "random",
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.code == http.StatusOK {
+				stMock.
+					On("Comments", mock.Anything, "news1").
+					Return(comms, nil).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /comments/{id}", Comments(tree.Drop, stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/comments/news1?sort="+tt.sort, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Comments() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			resp := []*tree.Node{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Comments() error = cannot unmarshal response")
+			}
+			if resp[0].ID != tt.first {
+				t.Errorf("Comments() first = %s, want %s", resp[0].ID, tt.first)
+			}
+		})
+	}
+}
+
+func TestComments_MaxDepth(t *testing.T) {
+	logger.Discard()
+
+	comms := []storage.Comment{
+		{ID: "com-3", ParentID: "com-2", PostID: "news1"},
+		{ID: "com-2", ParentID: "com-1", PostID: "news1"},
+		{ID: "com-1", PostID: "news1"},
+	}
+
+	tests := []struct {
+		name     string
+		maxDepth string
+		code     int
+		hidden   int
+	}{
+		{
+			name:     "MaxDepth_OK",
+			maxDepth: "1",
+			code:     http.StatusOK,
+			hidden:   2,
+		},
+		{
+			name:     "Incorrect_MaxDepth",
+			maxDepth: "0",
+			code:     http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+
+			if tt.code == http.StatusOK {
+				stMock.
+					On("Comments", mock.Anything, "news1").
+					Return(comms, nil).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /comments/{id}", Comments(tree.Drop, stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/comments/news1?maxDepth="+tt.maxDepth, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Comments() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			resp := []*tree.Node{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Comments() error = cannot unmarshal response")
+			}
+			if resp[0].More == nil || resp[0].More.Count != tt.hidden || resp[0].More.ParentID != "com-1" {
+				t.Errorf("Comments() more = %+v, want count %d", resp[0].More, tt.hidden)
+			}
+		})
+	}
+}
+
+func TestComments_Orphans(t *testing.T) {
+	logger.Discard()
+
+	comms := []storage.Comment{
+		{ID: "com-2", ParentID: "com-missing", PostID: "news1"},
+		{ID: "com-1", PostID: "news1"},
+	}
+
+	tests := []struct {
+		name   string
+		policy tree.Policy
+		roots  int
+	}{
+		{
+			name:   "Drop",
+			policy: tree.Drop,
+			roots:  1,
+		},
+		{
+			name:   "Promote",
+			policy: tree.Promote,
+			roots:  2,
+		},
+		{
+			name:   "Tombstone",
+			policy: tree.Tombstone,
+			roots:  2,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			stMock.
+				On("Comments", mock.Anything, "news1").
+				Return(comms, nil).
+				Once()
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /comments/{id}", Comments(tt.policy, stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/comments/news1", nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Comments() code = %d, want %d", rr.Code, http.StatusOK)
+			}
+
+			resp := []*tree.Node{}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Comments() error = cannot unmarshal response")
+			}
+			if len(resp) != tt.roots {
+				t.Errorf("Comments() roots = %d, want %d", len(resp), tt.roots)
+			}
+		})
+	}
+}
+
+func TestReact(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name     string
+		body     string
+		reaction string
+		code     int
+		mockErr  error
+		useMock  bool
+	}{
+		{
+			name:     "Like_OK",
+			body:     `{"reaction": "like"}`,
+			reaction: storage.ReactionLike,
+			code:     http.StatusOK,
+			useMock:  true,
+		},
+		{
+			name:     "Incorrect_Reaction",
+			body:     `{"reaction": "love"}`,
+			reaction: "love",
+			code:     http.StatusBadRequest,
+			mockErr:  storage.ErrIncorrectReaction,
+			useMock:  true,
+		},
+		{
+			name:     "Not_Found",
+			body:     `{"reaction": "dislike"}`,
+			reaction: storage.ReactionDislike,
+			code:     http.StatusNotFound,
+			mockErr:  storage.ErrCommentNotFound,
+			useMock:  true,
+		},
+		{
+			name:     "Incorrect_User",
+			body:     `{"reaction": "like"}`,
+			reaction: storage.ReactionLike,
+			code:     http.StatusBadRequest,
+			mockErr:  storage.ErrIncorrectUserID,
+			useMock:  true,
+		},
+		{
+			name: "Incorrect_Body",
+			body: `{"reaction": `,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.useMock {
+				stMock.
+					On("React", mock.Anything, "com-1", "user-1", tt.reaction).
+					Return(storage.Comment{ID: "com-1", Likes: 1}, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /comment/{commentId}/reaction", React(stMock))
+
+			req := httptest.NewRequest(http.MethodPut, "/comment/com-1/reaction", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = withUser(req, "user-1")
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("React() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp storage.Comment
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("React() error = cannot unmarshal response")
+			}
+			if resp.Likes != 1 {
+				t.Errorf("React() likes = %d, want 1", resp.Likes)
+			}
+		})
+	}
+}
+
+func TestUnreact(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name    string
+		user    string
+		code    int
+		mockErr error
+	}{
+		{
+			name: "Unreact_OK",
+			user: "user-1",
+			code: http.StatusOK,
+		},
+		{
+			name:    "Not_Found",
+			user:    "user-1",
+			code:    http.StatusNotFound,
+			mockErr: storage.ErrCommentNotFound,
+		},
+		{
+			name:    "Incorrect_ID",
+			user:    "user-1",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCommentID,
+		},
+		{
+			name: "No_Token",
+			code: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.user != "" {
+				stMock.
+					On("Unreact", mock.Anything, "com-1", tt.user).
+					Return(storage.Comment{ID: "com-1"}, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("DELETE /comment/{commentId}/reaction", Unreact(stMock))
+
+			req := httptest.NewRequest(http.MethodDelete, "/comment/com-1/reaction", nil)
+			if tt.user != "" {
+				req = withUser(req, tt.user)
+			}
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Errorf("Unreact() code = %d, want %d", rr.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	logger.Discard()
+
+	tests := []struct {
+		name    string
+		body    string
+		reason  string
+		code    int
+		mockErr error
+		useMock bool
+	}{
+		{
+			name:    "Report_OK",
+			body:    `{"reason": "spam"}`,
+			reason:  storage.ReasonSpam,
+			code:    http.StatusNoContent,
+			useMock: true,
+		},
+		{
+			name:    "Already_Reported",
+			body:    `{"reason": "abuse"}`,
+			reason:  storage.ReasonAbuse,
+			code:    http.StatusConflict,
+			mockErr: storage.ErrAlreadyReported,
+			useMock: true,
+		},
+		{
+			name:    "Incorrect_Reason",
+			body:    `{"reason": "boring"}`,
+			reason:  "boring",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectReason,
+			useMock: true,
+		},
+		{
+			name:    "Not_Found",
+			body:    `{"reason": "spam"}`,
+			reason:  storage.ReasonSpam,
+			code:    http.StatusNotFound,
+			mockErr: storage.ErrCommentNotFound,
+			useMock: true,
+		},
+		{
+			name:    "DB_error",
+			body:    `{"reason": "spam"}`,
+			reason:  storage.ReasonSpam,
+			code:    http.StatusInternalServerError,
+			mockErr: errors.New("DB error"),
+			useMock: true,
+		},
+		{
+			name: "Incorrect_Body",
+			body: `{"reason": `,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.useMock {
+				stMock.
+					On("Report", mock.Anything, "com-1", "user-1", tt.reason, 3).
+					Return(storage.Comment{ID: "com-1", Reports: 1}, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /comment/{commentId}/report", Report(3, stMock))
+
+			req := httptest.NewRequest(http.MethodPost, "/comment/com-1/report", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = withUser(req, "user-1")
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Errorf("Report() code = %d, want %d", rr.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestReported(t *testing.T) {
+	logger.Discard()
+
+	list := []storage.Reported{{
+		Comment: storage.Comment{ID: "com-1", PostID: "news1", Content: "Spam", Reports: 2},
+		Reasons: map[string]int{storage.ReasonSpam: 2},
+	}}
+
+	tests := []struct {
+		name    string
+		query   string
+		limit   int
+		code    int
+		mockErr error
+	}{
+		{
+			name:  "Reported_OK",
+			query: "",
+			limit: defaultLimit,
+			code:  http.StatusOK,
+		},
+		{
+			name:  "Incorrect_Limit",
+			query: "?limit=-1",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:    "DB_error",
+			query:   "?limit=10",
+			limit:   10,
+			code:    http.StatusInternalServerError,
+			mockErr: errors.New("DB error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.limit > 0 {
+				stMock.
+					On("Reported", mock.Anything, tt.limit).
+					Return(list, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /admin/comments/reported", Reported(stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/comments/reported"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("Reported() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp []storage.Reported
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("Reported() error = cannot unmarshal response")
+			}
+			if len(resp) != 1 || resp[0].Reasons[storage.ReasonSpam] != 2 {
+				t.Errorf("Reported() = %+v, want %+v", resp, list)
+			}
+		})
+	}
+}
+
+func TestAuthorComments(t *testing.T) {
+	logger.Discard()
+
+	pg := storage.Page{
+		Comments:   []storage.Comment{{ID: "com-1", PostID: "news1", AuthorID: "alice", AuthorName: "Alice", Content: "Hello"}},
+		NextCursor: "next",
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		limit   int
+		cursor  string
+		code    int
+		mockErr error
+	}{
+		{
+			name:  "Author_OK",
+			query: "?limit=1",
+			limit: 1,
+			code:  http.StatusOK,
+		},
+		{
+			name:   "Next_Page",
+			query:  "?cursor=next",
+			limit:  defaultLimit,
+			cursor: "next",
+			code:   http.StatusOK,
+		},
+		{
+			name:  "Incorrect_Limit",
+			query: "?limit=abc",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:    "Incorrect_Cursor",
+			query:   "?cursor=abc",
+			limit:   defaultLimit,
+			cursor:  "abc",
+			code:    http.StatusBadRequest,
+			mockErr: storage.ErrIncorrectCursor,
+		},
+		{
+			name:    "DB_error",
+			limit:   defaultLimit,
+			code:    http.StatusInternalServerError,
+			mockErr: errors.New("DB error"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.limit > 0 {
+				stMock.
+					On("AuthorComments", mock.Anything, "alice", tt.limit, tt.cursor).
+					Return(pg, tt.mockErr).
+					Once()
+			}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /users/{authorId}/comments", AuthorComments(stMock))
+
+			req := httptest.NewRequest(http.MethodGet, "/users/alice/comments"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("AuthorComments() code = %d, want %d", rr.Code, tt.code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp authorPage
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal("AuthorComments() error = cannot unmarshal response")
+			}
+			if len(resp.Comments) != 1 || resp.Comments[0].AuthorName != "Alice" || resp.NextCursor != "next" {
+				t.Errorf("AuthorComments() = %+v, want %+v", resp, pg)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	post := storage.NewID()
+	now := time.Now()
+
+	// ID одобренного позже комментария меньше ID последнего
+	// полученного клиентом комментария, но он записан позже.
+	late := storage.Comment{ID: storage.NewID(), PostID: post, PubTime: now.Add(2 * time.Second)}
+	last := storage.Comment{ID: storage.NewID(), PostID: post, PubTime: now.Add(time.Second)}
+	old := storage.Comment{ID: storage.NewID(), PostID: post, PubTime: now}
+	next := storage.Comment{ID: storage.NewID(), PostID: post, PubTime: now.Add(3 * time.Second)}
+	deleted := storage.Comment{ID: storage.NewID(), PostID: post, PubTime: now.Add(4 * time.Second), Deleted: true}
+
+	tests := []struct {
+		name    string
+		last    string
+		prevErr error
+		want    []string
+	}{
+		{
+			name: "OK",
+			last: last.ID,
+			want: []string{late.ID, next.ID},
+		},
+		{
+			name:    "Unknown_Last",
+			last:    storage.NewID(),
+			prevErr: storage.ErrCommentNotFound,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stMock := mocks.NewDB(t)
+			if tt.prevErr != nil {
+				stMock.On("Comment", mock.Anything, tt.last).Return(storage.Comment{}, tt.prevErr).Once()
+			} else {
+				stMock.On("Comment", mock.Anything, tt.last).Return(last, nil).Once()
+				stMock.
+					On("Comments", mock.Anything, post).
+					Return([]storage.Comment{deleted, next, late, last, old}, nil).
+					Once()
+			}
+
+			missed, err := since(context.Background(), stMock, post, tt.last)
+			if err != nil {
+				t.Fatalf("since() error = %v", err)
+			}
+			var got []string
+			for _, com := range missed {
+				got = append(got, com.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("since() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,14 @@ package server
 
 import (
 	"GoExamComments/internal/config"
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/middleware"
+	"GoExamComments/internal/moderation"
+	"GoExamComments/internal/ratelimit"
 	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/cache"
+	"GoExamComments/internal/tree"
 	"context"
 	"errors"
 	"log"
@@ -15,8 +21,17 @@ import (
 
 // Server - структура сервера.
 type Server struct {
-	srv *http.Server
-	mux *http.ServeMux
+	srv  *http.Server
+	mux  *http.ServeMux
+	ws   *sockets
+	auth *middleware.Verifier
+	// proxies - доверенные прокси для определения адреса клиента.
+	proxies middleware.Proxies
+	// limit - ограничение частоты запросов клиентов, nil - без ограничения.
+	limit *ratelimit.Limiter
+	// keys - ключи идемпотентности добавления комментариев, nil - ключи
+	// не учитываются.
+	keys *idempotency.Keys
 }
 
 // New - конструктор сервера.
@@ -30,7 +45,10 @@ func New(cfg *config.Config) *Server {
 			WriteTimeout: cfg.WriteTimeout,
 			IdleTimeout:  cfg.IdleTimeout,
 		},
-		mux: m,
+		mux:     m,
+		ws:      newSockets(),
+		auth:    middleware.NewVerifier(cfg),
+		proxies: middleware.NewProxies(cfg),
 	}
 	return server
 }
@@ -47,16 +65,127 @@ func (s *Server) Start() {
 	}()
 }
 
-// Middleware инициализирует все обработчики middleware.
+// Idempotency включает ключи идемпотентности для добавления комментариев
+// с результатами запросов в переданном хранилище. Вызывается до API.
+func (s *Server) Idempotency(cfg *config.Config, store idempotency.Store) {
+	s.keys = idempotency.New(cfg, store)
+}
+
+// RateLimit включает ограничение частоты запросов клиентов из конфига
+// с состоянием в переданном хранилище. Вызывается до API и Middleware.
+func (s *Server) RateLimit(cfg *config.Config, store ratelimit.Store) {
+	s.limit = ratelimit.New(cfg, store)
+}
+
+// Middleware инициализирует все обработчики middleware. Токен
+// пользователя проверяется после присвоения ID запросу, чтобы отказы
+// попадали в лог вместе с ним. Частота запросов ограничивается после
+// проверки токена, чтобы считать запросы пользователя с разных адресов
+// вместе.
 func (s *Server) Middleware() {
-	wrappedMux := middleware.RequestID(middleware.Logger(s.mux))
+	limited := middleware.RateLimit(s.limit, s.proxies)(s.mux)
+	wrappedMux := middleware.RequestID(middleware.Logger(middleware.Auth(s.auth)(limited)))
 	s.srv.Handler = wrappedMux
 }
 
+// access - минимальные роли пользователя для маршрутов API. Маршрут
+// без записи в таблице не регистрируется. Если токены не проверяются, то
+// маршруты для ролей выше автора тоже не регистрируются.
+var access = map[string]middleware.Role{
+	"POST /comments/new":                       middleware.RoleAuthor,
+	"GET /comments/{id}":                       middleware.RoleReader,
+	"GET /comments/{id}/stream":                middleware.RoleReader,
+	"GET /comments/ws":                         middleware.RoleReader,
+	"POST /comments/counts":                    middleware.RoleReader,
+	"GET /comment/{commentId}":                 middleware.RoleReader,
+	"GET /users/{authorId}/comments":           middleware.RoleReader,
+	"PUT /comments/{commentId}":                middleware.RoleAuthor,
+	"DELETE /comments/{commentId}":             middleware.RoleModerator,
+	"PUT /comment/{commentId}/reaction":        middleware.RoleReader,
+	"DELETE /comment/{commentId}/reaction":     middleware.RoleReader,
+	"POST /comment/{commentId}/report":         middleware.RoleReader,
+	"GET /admin/comments/pending":              middleware.RoleModerator,
+	"GET /admin/comments/reported":             middleware.RoleModerator,
+	"POST /admin/comments/{commentId}/approve": middleware.RoleModerator,
+	"POST /admin/comments/{commentId}/reject":  middleware.RoleModerator,
+	"GET /admin/comments/{commentId}/history":  middleware.RoleModerator,
+	"GET /admin/cache/stats":                   middleware.RoleAdmin,
+}
+
 // API инициализирует все обработчики API.
-func (s *Server) API(cfg *config.Config, st storage.DB) {
-	s.mux.HandleFunc("POST /comments/new", AddComment(cfg.ContentLength, st))
-	s.mux.HandleFunc("GET /comments/{id}", Comments(st))
+func (s *Server) API(cfg *config.Config, st storage.DB, b *events.Broker) {
+	mod := moderation.New(cfg)
+	policy, err := tree.ParsePolicy(cfg.OrphanPolicy)
+	if err != nil {
+		log.Fatalf("failed to init API: %s", err.Error())
+	}
+
+	s.handle("POST /comments/new", AddComment(cfg.ContentLength, mod, st, s.keys))
+	s.handle("GET /comments/{id}", Comments(policy, st))
+	s.handle("GET /comments/{id}/stream", Stream(b, st))
+	s.handle("GET /comments/ws", Socket(cfg.ContentLength, mod, st, b, s.ws, s.limit, s.auth != nil))
+	s.handle("POST /comments/counts", Counts(st))
+	s.handle("GET /comment/{commentId}", Comment(st))
+	s.handle("GET /users/{authorId}/comments", AuthorComments(st))
+	s.handle("PUT /comments/{commentId}", UpdateComment(cfg.ContentLength, mod, st))
+	s.handle("DELETE /comments/{commentId}", DeleteComment(st))
+	s.handle("PUT /comment/{commentId}/reaction", React(st))
+	s.handle("DELETE /comment/{commentId}/reaction", Unreact(st))
+	s.handle("POST /comment/{commentId}/report", Report(cfg.ReportThreshold, st))
+
+	// Обработчики для модераторов.
+	s.handle("GET /admin/comments/pending", Pending(st))
+	s.handle("GET /admin/comments/reported", Reported(st))
+	s.handle("POST /admin/comments/{commentId}/approve", SetStatus(storage.StatusApproved, st))
+	s.handle("POST /admin/comments/{commentId}/reject", SetStatus(storage.StatusRejected, st))
+	s.handle("GET /admin/comments/{commentId}/history", History(st))
+	// При остановке сервера закрываем открытые потоки событий, иначе
+	// graceful shutdown будет ждать их завершения. WebSocket соединения
+	// не отслеживаются сервером и закрываются отдельно.
+	s.srv.RegisterOnShutdown(b.Close)
+	s.srv.RegisterOnShutdown(s.ws.Close)
+
+	if c, ok := findCache(st); ok {
+		s.handle("GET /admin/cache/stats", CacheStats(c))
+	}
+}
+
+// handle регистрирует обработчик маршрута с проверкой роли пользователя
+// из таблицы access. Если токены не проверяются, то роль пользователя
+// неизвестна: маршруты для читателей и авторов регистрируются без проверки,
+// маршруты для модераторов и администраторов не регистрируются.
+func (s *Server) handle(pattern string, h http.HandlerFunc) {
+	role, ok := access[pattern]
+	if !ok {
+		log.Fatalf("failed to init API: no access policy for %q", pattern)
+	}
+	if s.auth == nil {
+		if role > middleware.RoleAuthor {
+			slog.Warn("route is disabled without authentication",
+				slog.String("route", pattern),
+				slog.String("required", role.String()),
+			)
+			return
+		}
+		s.mux.Handle(pattern, h)
+		return
+	}
+	s.mux.Handle(pattern, middleware.Require(role)(h))
+}
+
+// findCache ищет кэш в цепочке оберток над хранилищем.
+func findCache(st storage.DB) (*cache.Cache, bool) {
+	for st != nil {
+		if c, ok := st.(*cache.Cache); ok {
+			return c, true
+		}
+		w, ok := st.(interface{ Unwrap() storage.DB })
+		if !ok {
+			break
+		}
+		st = w.Unwrap()
+	}
+	return nil, false
 }
 
 // Shutdown останавливает сервер используя graceful shutdown.
@@ -0,0 +1,768 @@
+// Пакет для работы с сервером и обработчиками API.
+
+package server
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/idempotency"
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/middleware"
+	"GoExamComments/internal/ratelimit"
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/cache"
+	"GoExamComments/internal/storage/memory"
+	"GoExamComments/internal/tree"
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// node - комментарий в дереве из ответа API.
+type node struct {
+	ID         string `json:"id"`
+	AuthorID   string `json:"authorId"`
+	AuthorName string `json:"authorName"`
+	Content    string `json:"content"`
+	Deleted    bool   `json:"deleted"`
+	Likes      int    `json:"likes"`
+	Childs     []node `json:"childs"`
+}
+
+// secret - общий ключ для подписи токенов в тестах.
+const secret = "secret"
+
+// token возвращает токен пользователя без роли, подписанный ключом secret.
+func token(t *testing.T, user, name string) string {
+	t.Helper()
+	return tokenAs(t, user, name, "")
+}
+
+// tokenAs возвращает токен пользователя с переданной ролью, подписанный
+// ключом secret.
+func tokenAs(t *testing.T, user, name, role string) string {
+	t.Helper()
+
+	claims := middleware.Claims{Name: name, Role: role}
+	claims.Subject = user
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return s
+}
+
+// do выполняет запрос к тестовому серверу и возвращает ответ. Тело ответа
+// декодируется в v, если v не nil.
+func do(t *testing.T, ts *httptest.Server, method, path, body string, v any) *http.Response {
+	t.Helper()
+	return doAs(t, ts, "", method, path, body, v)
+}
+
+// doAs выполняет запрос к тестовому серверу с переданным токеном
+// пользователя. Пустой токен не передается.
+func doAs(t *testing.T, ts *httptest.Server, tok, method, path, body string, v any) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			t.Fatalf("%s %s: cannot decode response: %s", method, path, err.Error())
+		}
+	}
+	return resp
+}
+
+func TestServer_API(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, OrphanPolicy: "tombstone", Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	author := token(t, "alice", "")
+	moderator := tokenAs(t, "mod", "", "moderator")
+
+	var created storage.Comment
+	resp := doAs(t, ts, author, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "root"}`, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /comments/new status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if !storage.ValidID(created.ID) || created.PubTime.IsZero() || created.Content != "root" {
+		t.Fatalf("POST /comments/new = %+v, want stored comment", created)
+	}
+
+	// Адрес из заголовка Location ведет к добавленному комментарию.
+	var got node
+	do(t, ts, http.MethodGet, resp.Header.Get("Location"), "", &got)
+	if got.ID != created.ID {
+		t.Fatalf("GET %s = %+v, want comment %s", resp.Header.Get("Location"), got, created.ID)
+	}
+
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	if len(roots) != 1 {
+		t.Fatalf("GET /comments/{id} len = %d, want %d", len(roots), 1)
+	}
+	root := roots[0].ID
+
+	resp = doAs(t, ts, author, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "parentId": "`+root+`", "content": "reply"}`, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /comments/new status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	resp = doAs(t, ts, author, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "parentId": "`+storage.NewID()+`", "content": "reply"}`, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("POST /comments/new with unknown parent status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	if len(roots) != 1 || len(roots[0].Childs) != 1 || roots[0].Childs[0].Content != "reply" {
+		t.Fatalf("GET /comments/{id} = %+v, want root with one reply", roots)
+	}
+
+	var upd storage.Comment
+	resp = doAs(t, ts, moderator, http.MethodPut, "/comments/"+root, `{"content": "edited"}`, &upd)
+	if resp.StatusCode != http.StatusOK || upd.Content != "edited" || upd.EditedAt == nil {
+		t.Fatalf("PUT /comments/{commentId} status = %d, comment = %+v", resp.StatusCode, upd)
+	}
+
+	resp = doAs(t, ts, moderator, http.MethodDelete, "/comments/"+root, "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /comments/{commentId} status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	// Удаленный комментарий остается в дереве, пока на него есть ответы.
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	if len(roots) != 1 || !roots[0].Deleted || len(roots[0].Childs) != 1 {
+		t.Fatalf("GET /comments/{id} = %+v, want deleted root with one reply", roots)
+	}
+	// Заглушка удаленного комментария не раскрывает автора.
+	if roots[0].AuthorID != "" || roots[0].Content != tree.DeletedContent {
+		t.Errorf("GET /comments/{id} root = %+v, want tombstone without author", roots[0])
+	}
+	var tomb node
+	do(t, ts, http.MethodGet, "/comment/"+root+"?depth=1", "", &tomb)
+	if !tomb.Deleted || tomb.AuthorID != "" || tomb.Content != tree.DeletedContent || len(tomb.Childs) != 1 {
+		t.Errorf("GET /comment/{commentId} = %+v, want tombstone without author with one reply", tomb)
+	}
+
+	var revs []storage.Revision
+	doAs(t, ts, moderator, http.MethodGet, "/admin/comments/"+root+"/history", "", &revs)
+	if len(revs) != 2 || revs[0].Content != "root" || revs[1].Content != "edited" {
+		t.Fatalf("GET /admin/comments/{commentId}/history = %+v", revs)
+	}
+
+	var counts countsResponse
+	do(t, ts, http.MethodPost, "/comments/counts", `{"postIds": ["`+post+`"]}`, &counts)
+	if counts.Counts[post] != 1 {
+		t.Errorf("POST /comments/counts = %v, want %d", counts.Counts, 1)
+	}
+
+	// Удаленный комментарий без неудаленных ответов не найден.
+	doAs(t, ts, moderator, http.MethodDelete, "/comments/"+tomb.Childs[0].ID, "", nil)
+	resp = do(t, ts, http.MethodGet, "/comment/"+root+"?depth=1", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /comment/{commentId} deleted without replies status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestServer_Reactions(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	for _, content := range []string{"old", "new"} {
+		doAs(t, ts, token(t, "alice", "Alice"), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "`+content+`"}`, nil)
+	}
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	old := roots[1].ID
+
+	react := func(method, user, body string) int {
+		tok := ""
+		if user != "" {
+			tok = token(t, user, "")
+		}
+		return doAs(t, ts, tok, method, "/comment/"+old+"/reaction", body, nil).StatusCode
+	}
+
+	// Повторная реакция пользователя не учитывается дважды.
+	for _, user := range []string{"alice", "alice", "bob", "carol"} {
+		if code := react(http.MethodPut, user, `{"reaction": "like"}`); code != http.StatusOK {
+			t.Fatalf("PUT /comment/{commentId}/reaction status = %d, want %d", code, http.StatusOK)
+		}
+	}
+	if code := react(http.MethodDelete, "carol", ""); code != http.StatusOK {
+		t.Fatalf("DELETE /comment/{commentId}/reaction status = %d, want %d", code, http.StatusOK)
+	}
+	if code := react(http.MethodPut, "", `{"reaction": "like"}`); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /comment/{commentId}/reaction without token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	do(t, ts, http.MethodGet, "/comments/"+post+"?sort=top", "", &roots)
+	if len(roots) != 2 || roots[0].ID != old || roots[0].Likes != 2 || roots[1].Likes != 0 {
+		t.Fatalf("GET /comments/{id}?sort=top = %+v, want liked comment first", roots)
+	}
+}
+
+func TestServer_Pages(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	var want []string
+	for _, content := range []string{"first", "second", "third"} {
+		var com storage.Comment
+		doAs(t, ts, token(t, "alice", "Alice"), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "`+content+`"}`, &com)
+		want = append(want, com.ID)
+	}
+
+	// Страницы при sort=old идут от старых корневых комментариев к новым.
+	var pg struct {
+		Comments   []node `json:"comments"`
+		NextCursor string `json:"nextCursor"`
+	}
+	do(t, ts, http.MethodGet, "/comments/"+post+"?sort=old&limit=2", "", &pg)
+	if len(pg.Comments) != 2 || pg.Comments[0].ID != want[0] || pg.Comments[1].ID != want[1] || pg.NextCursor == "" {
+		t.Fatalf("GET /comments/{id}?sort=old&limit=2 = %+v, want two oldest comments and cursor", pg)
+	}
+	next := pg.NextCursor
+	pg.NextCursor = ""
+	do(t, ts, http.MethodGet, "/comments/"+post+"?sort=old&limit=2&cursor="+next, "", &pg)
+	if len(pg.Comments) != 1 || pg.Comments[0].ID != want[2] || pg.NextCursor != "" {
+		t.Fatalf("GET /comments/{id}?sort=old&limit=2&cursor = %+v, want newest comment without cursor", pg)
+	}
+
+	resp := do(t, ts, http.MethodGet, "/comments/"+post+"?sort=top&limit=2", "", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /comments/{id}?sort=top&limit=2 status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestServer_Authors(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	// Автор берется из токена, а не из тела запроса.
+	posts := []string{storage.NewID(), storage.NewID()}
+	for _, post := range posts {
+		doAs(t, ts, token(t, "alice", "Alice"), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "authorId": "bob", "content": "hello"}`, nil)
+	}
+	doAs(t, ts, token(t, "bob", ""), http.MethodPost, "/comments/new", `{"postId": "`+posts[0]+`", "content": "no name"}`, nil)
+	if resp := do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+posts[0]+`", "content": "anonymous"}`, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST /comments/new without token status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+posts[0], "", &roots)
+	if len(roots) != 2 || roots[0].AuthorName != "" || roots[1].AuthorName != "Alice" {
+		t.Fatalf("GET /comments/{id} = %+v, want bob and Alice comments", roots)
+	}
+
+	var pg struct {
+		Comments   []storage.Comment `json:"comments"`
+		NextCursor string            `json:"nextCursor"`
+	}
+	do(t, ts, http.MethodGet, "/users/alice/comments?limit=1", "", &pg)
+	if len(pg.Comments) != 1 || pg.Comments[0].PostID != posts[1] || pg.NextCursor == "" {
+		t.Fatalf("GET /users/{authorId}/comments = %+v, want newest comment and cursor", pg)
+	}
+	next := pg.NextCursor
+	pg.NextCursor = ""
+	do(t, ts, http.MethodGet, "/users/alice/comments?limit=1&cursor="+next, "", &pg)
+	if len(pg.Comments) != 1 || pg.Comments[0].PostID != posts[0] || pg.NextCursor != "" {
+		t.Fatalf("GET /users/{authorId}/comments = %+v, want last comment without cursor", pg)
+	}
+}
+
+func TestServer_Reports(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{
+		ContentLength: 1000,
+		Moderation:    config.Moderation{ReportThreshold: 2},
+		Auth:          config.Auth{Secret: secret},
+	}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	doAs(t, ts, token(t, "mallory", ""), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "buy now"}`, nil)
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	id := roots[0].ID
+
+	report := func(user, reason string) int {
+		return doAs(t, ts, token(t, user, ""), http.MethodPost, "/comment/"+id+"/report", `{"reason": "`+reason+`"}`, nil).StatusCode
+	}
+
+	if code := report("alice", storage.ReasonSpam); code != http.StatusNoContent {
+		t.Fatalf("POST /comment/{commentId}/report status = %d, want %d", code, http.StatusNoContent)
+	}
+	if code := report("alice", storage.ReasonAbuse); code != http.StatusConflict {
+		t.Fatalf("POST /comment/{commentId}/report again status = %d, want %d", code, http.StatusConflict)
+	}
+
+	moderator := tokenAs(t, "mod", "", "moderator")
+	var list []storage.Reported
+	doAs(t, ts, moderator, http.MethodGet, "/admin/comments/reported", "", &list)
+	if len(list) != 1 || list[0].Comment.ID != id || list[0].Reasons[storage.ReasonSpam] != 1 {
+		t.Fatalf("GET /admin/comments/reported = %+v, want one spam report", list)
+	}
+
+	// По достижении порога комментарий скрывается и попадает в очередь
+	// модерации.
+	if code := report("bob", storage.ReasonAbuse); code != http.StatusNoContent {
+		t.Fatalf("POST /comment/{commentId}/report status = %d, want %d", code, http.StatusNoContent)
+	}
+	if resp := do(t, ts, http.MethodGet, "/comments/"+post, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET /comments/{id} status = %d, want %d for hidden comment", resp.StatusCode, http.StatusNotFound)
+	}
+	var pending []storage.Comment
+	doAs(t, ts, moderator, http.MethodGet, "/admin/comments/pending", "", &pending)
+	if len(pending) != 1 || pending[0].ID != id || pending[0].Reports != 2 {
+		t.Fatalf("GET /admin/comments/pending = %+v, want reported comment", pending)
+	}
+	if code := report("carol", storage.ReasonSpam); code != http.StatusNotFound {
+		t.Fatalf("POST /comment/{commentId}/report on hidden status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestServer_Roles(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	doAs(t, ts, token(t, "alice", ""), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "hello"}`, nil)
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	id := roots[0].ID
+
+	tests := []struct {
+		name   string
+		tok    string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{
+			name:   "Read_Anonymous",
+			method: http.MethodGet,
+			path:   "/comments/" + post,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Add_Anonymous",
+			method: http.MethodPost,
+			path:   "/comments/new",
+			body:   `{"postId": "` + post + `", "content": "hi"}`,
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name:   "Add_By_Reader",
+			tok:    tokenAs(t, "bob", "", "reader"),
+			method: http.MethodPost,
+			path:   "/comments/new",
+			body:   `{"postId": "` + post + `", "content": "hi"}`,
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "Counts_Anonymous",
+			method: http.MethodPost,
+			path:   "/comments/counts",
+			body:   `{"postIds": ["` + post + `"]}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Pending_By_Author",
+			tok:    token(t, "alice", ""),
+			method: http.MethodGet,
+			path:   "/admin/comments/pending",
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "Pending_By_Moderator",
+			tok:    tokenAs(t, "mod", "", "moderator"),
+			method: http.MethodGet,
+			path:   "/admin/comments/pending",
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Update_By_Author",
+			tok:    token(t, "alice", ""),
+			method: http.MethodPut,
+			path:   "/comments/" + id,
+			body:   `{"content": "edited"}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Update_By_Other_Author",
+			tok:    token(t, "bob", ""),
+			method: http.MethodPut,
+			path:   "/comments/" + id,
+			body:   `{"content": "edited"}`,
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "Update_By_Moderator",
+			tok:    tokenAs(t, "mod", "", "moderator"),
+			method: http.MethodPut,
+			path:   "/comments/" + id,
+			body:   `{"content": "moderated"}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "Delete_By_Author",
+			tok:    token(t, "alice", ""),
+			method: http.MethodDelete,
+			path:   "/comments/" + id,
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "Delete_By_Admin",
+			tok:    tokenAs(t, "root", "", "admin"),
+			method: http.MethodDelete,
+			path:   "/comments/" + id,
+			code:   http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doAs(t, ts, tt.tok, tt.method, tt.path, tt.body, nil)
+			if resp.StatusCode != tt.code {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.code)
+			}
+		})
+	}
+
+	// Отказ описывается в JSON с ID запроса.
+	var denial struct {
+		Error     string `json:"error"`
+		Role      string `json:"role"`
+		Required  string `json:"required"`
+		RequestID string `json:"requestId"`
+	}
+	doAs(t, ts, token(t, "alice", ""), http.MethodGet, "/admin/comments/reported", "", &denial)
+	if denial.Error != "forbidden" || denial.Role != "author" || denial.Required != "moderator" || denial.RequestID == "" {
+		t.Errorf("GET /admin/comments/reported = %+v, want forbidden for author", denial)
+	}
+}
+
+func TestServer_NoAuth(t *testing.T) {
+	logger.Discard()
+
+	// Без проверки токенов маршруты модераторов и администраторов
+	// не регистрируются, а изменить комментарий нельзя: автор неизвестен.
+	cfg := &config.Config{ContentLength: 1000, Cache: config.Cache{Size: 10}}
+	srv := New(cfg)
+	b := events.New()
+	srv.API(cfg, events.Wrap(cache.New(cfg, memory.New()), b), b)
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	var created storage.Comment
+	resp := do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "hello"}`, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /comments/new status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	id := created.ID
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{name: "Read", method: http.MethodGet, path: "/comments/" + post, code: http.StatusOK},
+		{name: "Update", method: http.MethodPut, path: "/comments/" + id, body: `{"content": "edited"}`, code: http.StatusForbidden},
+		{name: "Delete", method: http.MethodDelete, path: "/comments/" + id, code: http.StatusMethodNotAllowed},
+		{name: "Pending", method: http.MethodGet, path: "/admin/comments/pending", code: http.StatusNotFound},
+		{name: "Approve", method: http.MethodPost, path: "/admin/comments/" + id + "/approve", code: http.StatusNotFound},
+		{name: "Cache_Stats", method: http.MethodGet, path: "/admin/cache/stats", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, ts, tt.method, tt.path, tt.body, nil)
+			if resp.StatusCode != tt.code {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.code)
+			}
+		})
+	}
+}
+
+func TestServer_CacheStats(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Cache: config.Cache{Size: 10}, Auth: config.Auth{Secret: secret}}
+	srv := New(cfg)
+	b := events.New()
+	srv.API(cfg, events.Wrap(cache.New(cfg, memory.New()), b), b)
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	doAs(t, ts, token(t, "alice", ""), http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "root"}`, nil)
+	for i := 0; i < 3; i++ {
+		do(t, ts, http.MethodGet, "/comments/"+post, "", nil)
+	}
+
+	var stats cache.Stats
+	resp := doAs(t, ts, tokenAs(t, "root", "", "admin"), http.MethodGet, "/admin/cache/stats", "", &stats)
+	if resp.StatusCode != http.StatusOK || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("GET /admin/cache/stats status = %d, stats = %+v, want 2 hits and 1 miss", resp.StatusCode, stats)
+	}
+
+	// Ответ с другими параметрами кэшируется отдельно.
+	for i := 0; i < 2; i++ {
+		do(t, ts, http.MethodGet, "/comments/"+post+"?sort=old", "", nil)
+	}
+	doAs(t, ts, tokenAs(t, "root", "", "admin"), http.MethodGet, "/admin/cache/stats", "", &stats)
+	if stats.Hits != 3 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Errorf("GET /admin/cache/stats = %+v, want 3 hits, 2 misses and 1 entry", stats)
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{
+		ContentLength: 1000,
+		HTTPServer:    config.HTTPServer{WriteLimit: config.RateLimit{Rate: 0.1, Burst: 2}},
+	}
+	srv := New(cfg)
+	srv.RateLimit(cfg, ratelimit.NewMemory())
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	body := `{"postId": "` + post + `", "content": "flood"}`
+	for i := 0; i < 2; i++ {
+		if resp := do(t, ts, http.MethodPost, "/comments/new", body, nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST /comments/new status = %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+	}
+	resp := do(t, ts, http.MethodPost, "/comments/new", body, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "10" {
+		t.Fatalf("POST /comments/new status = %d, Retry-After = %q, want %d and 10",
+			resp.StatusCode, resp.Header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	// Добавление через WebSocket расходует ту же корзину клиента.
+	conn := dial(t, ts)
+	send(t, conn, `{"type": "add", "id": "a1", "comment": `+body+`}`)
+	if f := readFrame(t, conn); f.Type != msgError || f.ID != "a1" || f.Code != http.StatusTooManyRequests {
+		t.Fatalf("add reply = %+v, want error %d", f, http.StatusTooManyRequests)
+	}
+
+	// Запросы на чтение не ограничены.
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	if len(roots) != 2 {
+		t.Errorf("GET /comments/{id} = %d comments, want 2", len(roots))
+	}
+}
+
+func TestServer_Idempotency(t *testing.T) {
+	logger.Discard()
+
+	// Без проверки токенов ключ идемпотентности действует в пределах
+	// адреса клиента.
+	cfg := &config.Config{
+		ContentLength: 1000,
+		HTTPServer:    config.HTTPServer{TrustedProxies: []string{"127.0.0.1", "::1"}},
+		Idempotency:   config.Idempotency{KeyTTL: time.Hour},
+	}
+	srv := New(cfg)
+	srv.Idempotency(cfg, idempotency.NewMemory())
+	srv.API(cfg, memory.New(), events.New())
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	add := func(ip string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/comments/new", strings.NewReader(`{"postId": "`+post+`", "content": "hello"}`))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("X-Real-IP", ip)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		replayed bool
+	}{
+		{name: "First", ip: "1.1.1.1", replayed: false},
+		{name: "Replay", ip: "1.1.1.1", replayed: true},
+		{name: "Other_Client", ip: "2.2.2.2", replayed: false},
+	}
+	for _, tt := range tests {
+		resp := add(tt.ip)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: POST /comments/new status = %d, want %d", tt.name, resp.StatusCode, http.StatusCreated)
+		}
+		if got := resp.Header.Get("Idempotent-Replayed") == "true"; got != tt.replayed {
+			t.Errorf("%s: POST /comments/new replayed = %v, want %v", tt.name, got, tt.replayed)
+		}
+	}
+
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post, "", &roots)
+	if len(roots) != 2 {
+		t.Errorf("GET /comments/{id} = %d comments, want 2", len(roots))
+	}
+}
+
+// readEvent читает из потока Server-Sent Events одно событие и возвращает
+// его ID. Комментарии-пинги пропускаются.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	var id string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("cannot read event: %s", err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && id != "" {
+			return id
+		}
+		if v, ok := strings.CutPrefix(line, "id: "); ok {
+			id = v
+		}
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000}
+	b := events.New()
+	srv := New(cfg)
+	srv.API(cfg, events.Wrap(memory.New(), b), b)
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	var ids []string
+	for _, content := range []string{"first", "second"} {
+		do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "`+content+`"}`, nil)
+		var roots []node
+		do(t, ts, http.MethodGet, "/comments/"+post+"?sort=new", "", &roots)
+		ids = append(ids, roots[0].ID)
+	}
+
+	// Клиент, получивший первый комментарий, получает пропущенный второй
+	// и затем новые комментарии.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/comments/"+post+"/stream", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req.Header.Set("Last-Event-ID", ids[0])
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("GET /comments/{id}/stream Content-Type = %s, want text/event-stream", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if got := readEvent(t, r); got != ids[1] {
+		t.Fatalf("GET /comments/{id}/stream missed event = %s, want %s", got, ids[1])
+	}
+
+	do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "content": "third"}`, nil)
+	do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+storage.NewID()+`", "content": "other"}`, nil)
+	do(t, ts, http.MethodPost, "/comments/new", `{"postId": "`+post+`", "parentId": "`+ids[0]+`", "content": "fourth"}`, nil)
+
+	var roots []node
+	do(t, ts, http.MethodGet, "/comments/"+post+"?sort=new", "", &roots)
+	if len(roots) != 3 || len(roots[2].Childs) != 1 {
+		t.Fatalf("GET /comments/{id} = %+v, want 3 roots and one reply", roots)
+	}
+	want := []string{roots[0].ID, roots[2].Childs[0].ID}
+	for _, id := range want {
+		if got := readEvent(t, r); got != id {
+			t.Errorf("GET /comments/{id}/stream event = %s, want %s", got, id)
+		}
+	}
+
+	resp = do(t, ts, http.MethodGet, "/comments/post/stream", "", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /comments/{id}/stream status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/middleware"
+	"GoExamComments/internal/moderation"
+	"GoExamComments/internal/ratelimit"
+	"GoExamComments/internal/storage"
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Настройки WebSocket соединений.
+const (
+	// wsQueue - размер очереди исходящих сообщений соединения. Клиент,
+	// который не успевает читать сообщения, отключается.
+	wsQueue = 64
+	// wsWriteWait - время на запись одного сообщения клиенту.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait - время ожидания ответа на пинг или сообщения от клиента.
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod - интервал отправки пингов, меньше wsPongWait.
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxMessage - максимальный размер сообщения от клиента.
+	wsMaxMessage = 1048576
+	// wsMaxPosts - максимальное количество постов, на которые может быть
+	// подписано одно соединение.
+	wsMaxPosts = 100
+)
+
+// Типы сообщений WebSocket соединения. Кроме них сервер отправляет
+// события об изменении комментариев с типами storage.Event*.
+const (
+	msgSubscribe   = "subscribe"
+	msgUnsubscribe = "unsubscribe"
+	msgAdd         = "add"
+	msgAdded       = "added"
+	msgError       = "error"
+)
+
+// upgrader переводит HTTP соединение на протокол WebSocket. Запросы
+// с чужим заголовком Origin отклоняются, мобильные клиенты его не передают.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// message - сообщение клиента. ID - произвольный идентификатор запроса
+// на добавление комментария, который возвращается в ответе.
+type message struct {
+	Type    string           `json:"type"`
+	ID      string           `json:"id,omitempty"`
+	PostIDs []string         `json:"postIds,omitempty"`
+	Comment *storage.Comment `json:"comment,omitempty"`
+}
+
+// reply - ответ сервера на сообщение клиента.
+type reply struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	CommentID string `json:"commentId,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Code      int    `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// subscription - подписка соединения на события по комментариям к посту.
+type subscription struct {
+	ch     <-chan storage.Event
+	cancel func()
+}
+
+// socket - открытое WebSocket соединение.
+type socket struct {
+	conn *websocket.Conn
+	log  *slog.Logger
+	send chan any
+	done chan struct{}
+	once sync.Once
+
+	// auth - токены проверяются, добавлять комментарии без токена или
+	// без роли автора нельзя.
+	auth bool
+	// limit - ограничение частоты добавления комментариев, nil - без
+	// ограничения. client - ключ корзины клиента.
+	limit  *ratelimit.Limiter
+	client string
+
+	mu   sync.Mutex
+	subs map[string]subscription
+}
+
+// sockets - открытые WebSocket соединения сервера. Соединения, переданные
+// обработчику через Hijack, не отслеживаются http.Server, поэтому при
+// остановке сервера они закрываются через Close.
+type sockets struct {
+	mu     sync.Mutex
+	conns  map[*socket]struct{}
+	closed bool
+}
+
+// newSockets - конструктор списка соединений.
+func newSockets() *sockets {
+	return &sockets{conns: make(map[*socket]struct{})}
+}
+
+// add добавляет соединение в список. Если сервер уже останавливается,
+// то возвращает false.
+func (ss *sockets) add(s *socket) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.closed {
+		return false
+	}
+	ss.conns[s] = struct{}{}
+	return true
+}
+
+// remove удаляет соединение из списка.
+func (ss *sockets) remove(s *socket) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.conns, s)
+}
+
+// Close закрывает все открытые соединения с кодом "going away" и запрещает
+// открывать новые. Вызывается при остановке сервера.
+func (ss *sockets) Close() {
+	ss.mu.Lock()
+	conns := ss.conns
+	ss.conns = make(map[*socket]struct{})
+	ss.closed = true
+	ss.mu.Unlock()
+
+	for s := range conns {
+		s.close(websocket.CloseGoingAway, "server shutdown")
+	}
+}
+
+// Socket открывает WebSocket соединение. Через одно соединение клиент
+// подписывается на события по комментариям к нескольким постам и добавляет
+// комментарии с теми же проверками, что и в AddComment. Клиенту отправляются
+// события о новых, измененных и удаленных комментариях. Соединение
+// закрывается, если клиент не отвечает на пинги или не успевает читать
+// сообщения. Токен пользователя проверяется при открытии соединения,
+// без токена или без роли автора соединение открывается только для чтения,
+// если auth - true. Каждое добавление комментария расходует корзину
+// изменения данных клиента в l, как запрос к API.
+func Socket(ln int, mod *moderation.Pipeline, st storage.DB, b *events.Broker, ss *sockets, l *ratelimit.Limiter, auth bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const operation = "server.Socket"
+
+		log := slog.Default().With(
+			slog.String("op", operation),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		log.Info("request to open websocket")
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade сам записывает ответ с ошибкой.
+			log.Error("cannot upgrade connection", logger.Err(err))
+			return
+		}
+
+		s := &socket{
+			conn:   conn,
+			log:    log,
+			send:   make(chan any, wsQueue),
+			done:   make(chan struct{}),
+			auth:   auth,
+			limit:  l,
+			client: middleware.GetClient(r.Context()),
+			subs:   make(map[string]subscription),
+		}
+		if !ss.add(s) {
+			log.Info("server is shutting down")
+			s.close(websocket.CloseGoingAway, "server shutdown")
+			return
+		}
+		defer ss.remove(s)
+
+		go s.write()
+		s.read(r.Context(), ln, mod, st, b)
+
+		log.Info("request served successfuly")
+	}
+}
+
+// read читает сообщения клиента до закрытия соединения.
+func (s *socket) read(ctx context.Context, ln int, mod *moderation.Pipeline, st storage.DB, b *events.Broker) {
+	defer s.close(websocket.CloseNormalClosure, "")
+
+	s.conn.SetReadLimit(wsMaxMessage)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg message
+		err := s.conn.ReadJSON(&msg)
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); ok {
+				s.log.Debug("websocket closed by client", logger.Err(err))
+			} else {
+				s.log.Info("cannot read message", logger.Err(err))
+			}
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch msg.Type {
+		case msgSubscribe:
+			for _, post := range msg.PostIDs {
+				if !storage.ValidID(post) {
+					s.log.Error("incorrect post id", slog.String("post_id", post))
+					s.fail(msg.ID, http.StatusBadRequest, "incorrect post id")
+					continue
+				}
+				if !s.subscribe(b, post) {
+					s.log.Error("too many subscriptions")
+					s.fail(msg.ID, http.StatusBadRequest, "too many subscriptions")
+					break
+				}
+			}
+		case msgUnsubscribe:
+			for _, post := range msg.PostIDs {
+				s.unsubscribe(post)
+			}
+		case msgAdd:
+			s.add(ctx, msg, ln, mod, st)
+		default:
+			s.log.Error("unknown message type", slog.String("type", msg.Type))
+			s.fail(msg.ID, http.StatusBadRequest, "unknown message type")
+		}
+	}
+}
+
+// add записывает комментарий из сообщения клиента в БД и отправляет
+// клиенту ID нового комментария или ошибку.
+func (s *socket) add(ctx context.Context, msg message, ln int, mod *moderation.Pipeline, st storage.DB) {
+	if msg.Comment == nil {
+		s.log.Error("message has no comment")
+		s.fail(msg.ID, http.StatusBadRequest, "empty comment")
+		return
+	}
+
+	if s.auth && middleware.GetClaims(ctx) == nil {
+		s.log.Warn("unauthenticated write message")
+		s.fail(msg.ID, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if s.auth && middleware.GetRole(ctx) < access["POST /comments/new"] {
+		s.log.Warn("access denied",
+			slog.String("user", middleware.GetUserID(ctx)),
+			slog.String("role", middleware.GetRole(ctx).String()),
+		)
+		s.fail(msg.ID, http.StatusForbidden, "forbidden")
+		return
+	}
+	if !s.allow(ctx) {
+		s.fail(msg.ID, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	comm := withAuthor(ctx, *msg.Comment)
+	comm, e := checkComment(comm, s.log, ln, mod, false)
+	if e != nil {
+		s.fail(msg.ID, e.code, e.msg)
+		return
+	}
+
+	id, err := st.AddComment(ctx, comm)
+	if err != nil {
+		s.log.Error("cannot add comment to DB", logger.Err(err))
+		e := addError(err)
+		s.fail(msg.ID, e.code, e.msg)
+		return
+	}
+	s.log.Debug("comment added to DB successfully", slog.String("id", id), slog.String("status", comm.Status))
+
+	s.enqueue(reply{Type: msgAdded, ID: msg.ID, CommentID: id, Status: comm.Status})
+}
+
+// allow забирает токен из корзины изменения данных клиента. При ошибке
+// хранилища корзин сообщение обрабатывается, как и запрос к API.
+func (s *socket) allow(ctx context.Context) bool {
+	if s.limit == nil {
+		return true
+	}
+	wait, err := s.limit.Allow(ctx, s.client, true)
+	if err != nil {
+		s.log.Error("cannot check rate limit", logger.Err(err))
+		return true
+	}
+	if wait > 0 {
+		s.log.Warn("rate limit exceeded", slog.String("client", s.client))
+		return false
+	}
+	return true
+}
+
+// fail отправляет клиенту сообщение об ошибке.
+func (s *socket) fail(id string, code int, msg string) {
+	s.enqueue(reply{Type: msgError, ID: id, Code: code, Error: msg})
+}
+
+// subscribe подписывает соединение на события по комментариям к посту.
+// Если достигнуто максимальное количество подписок, то возвращает false.
+func (s *socket) subscribe(b *events.Broker, post string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+	if _, ok := s.subs[post]; ok {
+		return true
+	}
+	if len(s.subs) >= wsMaxPosts {
+		return false
+	}
+	ch, cancel := b.Subscribe(post)
+	s.subs[post] = subscription{ch: ch, cancel: cancel}
+	go s.forward(post, ch)
+	return true
+}
+
+// unsubscribe отменяет подписку соединения на события по комментариям
+// к посту.
+func (s *socket) unsubscribe(post string) {
+	s.mu.Lock()
+	sub, ok := s.subs[post]
+	delete(s.subs, post)
+	s.mu.Unlock()
+
+	if ok {
+		sub.cancel()
+	}
+}
+
+// forward передает события подписки в очередь сообщений соединения.
+// Если брокер закрыл подписку сам, например при остановке сервера или
+// потому что соединение не успевает читать события, то соединение
+// закрывается: клиент переподключится и перечитает комментарии.
+func (s *socket) forward(post string, ch <-chan storage.Event) {
+	for ev := range ch {
+		if !s.enqueue(ev) {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	sub, ok := s.subs[post]
+	dropped := ok && sub.ch == ch
+	s.mu.Unlock()
+
+	if dropped {
+		s.log.Warn("subscription closed by broker", slog.String("post_id", post))
+		s.close(websocket.CloseTryAgainLater, "subscription closed")
+	}
+}
+
+// enqueue ставит сообщение в очередь на отправку клиенту. Очередь
+// не блокируется: если она заполнена, то соединение закрывается.
+func (s *socket) enqueue(v any) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.send <- v:
+		return true
+	case <-s.done:
+		return false
+	default:
+		s.log.Warn("slow websocket client disconnected")
+		s.close(websocket.CloseTryAgainLater, "too slow")
+		return false
+	}
+}
+
+// write отправляет клиенту сообщения из очереди и пинги до закрытия
+// соединения.
+func (s *socket) write() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case v := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := s.conn.WriteJSON(v)
+			if err != nil {
+				s.log.Info("cannot write message", logger.Err(err))
+				s.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			if err != nil {
+				s.log.Info("cannot write ping", logger.Err(err))
+				s.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// close отменяет подписки, отправляет клиенту сообщение о закрытии
+// с переданным кодом и закрывает соединение. Повторные вызовы ничего
+// не делают.
+func (s *socket) close(code int, reason string) {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		subs := s.subs
+		s.subs = make(map[string]subscription)
+		s.mu.Unlock()
+		for _, sub := range subs {
+			sub.cancel()
+		}
+
+		if code != websocket.CloseAbnormalClosure {
+			msg := websocket.FormatCloseMessage(code, reason)
+			s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+		}
+		s.conn.Close()
+	})
+}
@@ -0,0 +1,312 @@
+// Пакет для работы с сервером и обработчиками API.
+
+package server
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/events"
+	"GoExamComments/internal/logger"
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/memory"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// frame - сообщение сервера: событие об изменении комментария или ответ
+// на сообщение клиента.
+type frame struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	CommentID string          `json:"commentId"`
+	Code      int             `json:"code"`
+	Error     string          `json:"error"`
+	Comment   storage.Comment `json:"comment"`
+}
+
+// dial открывает WebSocket соединение с тестовым сервером.
+func dial(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+	return dialAs(t, ts, "")
+}
+
+// dialAs открывает WebSocket соединение с тестовым сервером с переданным
+// токеном пользователя. Пустой токен не передается.
+func dialAs(t *testing.T, ts *httptest.Server, tok string) *websocket.Conn {
+	t.Helper()
+
+	header := http.Header{}
+	if tok != "" {
+		header.Set("Authorization", "Bearer "+tok)
+	}
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/comments/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// send отправляет сообщение серверу.
+func send(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+
+	err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// readFrame читает следующее сообщение сервера.
+func readFrame(t *testing.T, conn *websocket.Conn) frame {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var f frame
+	err := conn.ReadJSON(&f)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return f
+}
+
+// wsServer запускает тестовый сервер с хранилищем в памяти, брокером
+// событий и проверкой токенов.
+func wsServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, CensorList: []string{"spam"}, Auth: config.Auth{Secret: secret}}
+	b := events.New()
+	srv := New(cfg)
+	srv.API(cfg, events.Wrap(memory.New(), b), b)
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func TestServer_Socket(t *testing.T) {
+	_, ts := wsServer(t)
+
+	posts := []string{storage.NewID(), storage.NewID()}
+	other := storage.NewID()
+	author := token(t, "alice", "")
+	moderator := tokenAs(t, "mod", "", "moderator")
+
+	conn := dialAs(t, ts, author)
+	send(t, conn, `{"type": "subscribe", "postIds": ["`+posts[0]+`", "`+posts[1]+`"]}`)
+	send(t, conn, `{"type": "subscribe", "id": "s1", "postIds": ["post"]}`)
+	if f := readFrame(t, conn); f.Type != msgError || f.ID != "s1" || f.Code != http.StatusBadRequest {
+		t.Fatalf("subscribe reply = %+v, want error for s1", f)
+	}
+
+	// Комментарий, добавленный через соединение: ответ с ID и событие.
+	send(t, conn, `{"type": "add", "id": "a1", "comment": {"postId": "`+posts[0]+`", "content": "first"}}`)
+	added := readFrame(t, conn)
+	if added.Type != msgAdded || added.ID != "a1" || !storage.ValidID(added.CommentID) {
+		t.Fatalf("add reply = %+v, want added for a1", added)
+	}
+	if f := readFrame(t, conn); f.Type != storage.EventNew || f.Comment.ID != added.CommentID {
+		t.Fatalf("event = %+v, want new %s", f, added.CommentID)
+	}
+
+	// Проверки те же, что и при добавлении через HTTP.
+	tests := []struct {
+		name string
+		msg  string
+		code int
+	}{
+		{
+			name: "Empty_Content",
+			msg:  `{"type": "add", "id": "e1", "comment": {"postId": "` + posts[0] + `"}}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "Rejected",
+			msg:  `{"type": "add", "id": "e1", "comment": {"postId": "` + posts[0] + `", "content": "buy spam"}}`,
+			code: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Parent_Not_Found",
+			msg:  `{"type": "add", "id": "e1", "comment": {"postId": "` + posts[0] + `", "parentId": "` + storage.NewID() + `", "content": "reply"}}`,
+			code: http.StatusNotFound,
+		},
+		{
+			name: "Unknown_Type",
+			msg:  `{"type": "like", "id": "e1"}`,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send(t, conn, tt.msg)
+			f := readFrame(t, conn)
+			if f.Type != msgError || f.ID != "e1" || f.Code != tt.code {
+				t.Errorf("reply = %+v, want error %d", f, tt.code)
+			}
+		})
+	}
+
+	// События по другим постам не приходят, по второму посту приходят
+	// новые, измененные и удаленные комментарии.
+	doAs(t, ts, author, http.MethodPost, "/comments/new", `{"postId": "`+other+`", "content": "other"}`, nil)
+	doAs(t, ts, author, http.MethodPost, "/comments/new", `{"postId": "`+posts[1]+`", "content": "second"}`, nil)
+	second := readFrame(t, conn)
+	if second.Type != storage.EventNew || second.Comment.PostID != posts[1] {
+		t.Fatalf("event = %+v, want new on %s", second, posts[1])
+	}
+
+	doAs(t, ts, moderator, http.MethodPut, "/comments/"+second.Comment.ID, `{"content": "edited"}`, nil)
+	if f := readFrame(t, conn); f.Type != storage.EventEdited || f.Comment.Content != "edited" {
+		t.Fatalf("event = %+v, want edited", f)
+	}
+	doAs(t, ts, moderator, http.MethodDelete, "/comments/"+second.Comment.ID, "", nil)
+	if f := readFrame(t, conn); f.Type != storage.EventDeleted || f.Comment.ID != second.Comment.ID {
+		t.Fatalf("event = %+v, want deleted", f)
+	}
+
+	// После отписки события по посту не приходят.
+	send(t, conn, `{"type": "unsubscribe", "postIds": ["`+posts[1]+`"]}`)
+	send(t, conn, `{"type": "add", "id": "a2", "comment": {"postId": "`+posts[1]+`", "content": "third"}}`)
+	if f := readFrame(t, conn); f.Type != msgAdded || f.ID != "a2" {
+		t.Fatalf("add reply = %+v, want added for a2", f)
+	}
+	send(t, conn, `{"type": "add", "id": "a3", "comment": {"postId": "`+posts[0]+`", "content": "fourth"}}`)
+	if f := readFrame(t, conn); f.Type != msgAdded || f.ID != "a3" {
+		t.Fatalf("add reply = %+v, want added for a3", f)
+	}
+	if f := readFrame(t, conn); f.Type != storage.EventNew || f.Comment.PostID != posts[0] {
+		t.Fatalf("event = %+v, want new on %s", f, posts[0])
+	}
+}
+
+func TestServer_Socket_Auth(t *testing.T) {
+	logger.Discard()
+
+	cfg := &config.Config{ContentLength: 1000, Auth: config.Auth{Secret: secret}}
+	b := events.New()
+	srv := New(cfg)
+	srv.API(cfg, events.Wrap(memory.New(), b), b)
+	srv.Middleware()
+	ts := httptest.NewServer(srv.srv.Handler)
+	defer ts.Close()
+
+	post := storage.NewID()
+	msg := `{"type": "add", "id": "a1", "comment": {"postId": "` + post + `", "content": "hello"}}`
+
+	// Без токена соединение открывается только для чтения.
+	reader := dial(t, ts)
+	send(t, reader, `{"type": "subscribe", "postIds": ["`+post+`"]}`)
+	send(t, reader, msg)
+	if f := readFrame(t, reader); f.Type != msgError || f.Code != http.StatusUnauthorized {
+		t.Fatalf("add reply without token = %+v, want error %d", f, http.StatusUnauthorized)
+	}
+
+	// Читателю добавлять комментарии нельзя.
+	ro := dialAs(t, ts, tokenAs(t, "bob", "", "reader"))
+	send(t, ro, msg)
+	if f := readFrame(t, ro); f.Type != msgError || f.Code != http.StatusForbidden {
+		t.Fatalf("add reply by reader = %+v, want error %d", f, http.StatusForbidden)
+	}
+
+	// Автор комментария берется из токена соединения.
+	writer := dialAs(t, ts, token(t, "alice", "Alice"))
+	send(t, writer, msg)
+	if f := readFrame(t, writer); f.Type != msgAdded || f.ID != "a1" {
+		t.Fatalf("add reply = %+v, want added for a1", f)
+	}
+	if f := readFrame(t, reader); f.Type != storage.EventNew || f.Comment.AuthorID != "alice" || f.Comment.AuthorName != "Alice" {
+		t.Fatalf("event = %+v, want new comment by alice", f)
+	}
+}
+
+func TestServer_Socket_Shutdown(t *testing.T) {
+	srv, ts := wsServer(t)
+
+	conn := dial(t, ts)
+	send(t, conn, `{"type": "subscribe", "postIds": ["`+storage.NewID()+`"]}`)
+	idle := dial(t, ts)
+
+	// Подписка оформляется после чтения сообщения, дожидаемся ее через
+	// ответ на следующее сообщение.
+	send(t, conn, `{"type": "ping", "id": "p1"}`)
+	readFrame(t, conn)
+
+	srv.ws.Close()
+
+	for _, c := range []*websocket.Conn{conn, idle} {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := c.ReadMessage()
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+			t.Errorf("ReadMessage() error = %v, want close %d", err, websocket.CloseGoingAway)
+		}
+	}
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/comments/ws"
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = c.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+		t.Errorf("ReadMessage() after shutdown error = %v, want close %d", err, websocket.CloseGoingAway)
+	}
+}
+
+func TestSocket_enqueue(t *testing.T) {
+	logger.Discard()
+
+	// Соединение без горутины записи: очередь не разбирается.
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+		conns <- conn
+	}))
+	defer ts.Close()
+
+	client := dial(t, ts)
+	s := &socket{
+		conn: <-conns,
+		log:  slog.Default(),
+		send: make(chan any, wsQueue),
+		done: make(chan struct{}),
+		subs: make(map[string]subscription),
+	}
+
+	for i := 0; i < wsQueue; i++ {
+		if !s.enqueue(reply{Type: msgAdded}) {
+			t.Fatalf("socket.enqueue() = false on message %d, want true", i)
+		}
+	}
+	if s.enqueue(reply{Type: msgAdded}) {
+		t.Fatal("socket.enqueue() on full queue = true, want false")
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("socket is not closed after queue overflow")
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseTryAgainLater {
+		t.Errorf("ReadMessage() error = %v, want close %d", err, websocket.CloseTryAgainLater)
+	}
+}
@@ -0,0 +1,298 @@
+// Пакет cache содержит кэширующую обертку над storage.DB. Обертка хранит
+// в памяти построенные ответы по популярным постам, чтобы не обращаться
+// к БД и не строить дерево комментариев при каждом просмотре статьи.
+package cache
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/storage"
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Значения по умолчанию для настроек кэша.
+const (
+	defaultTTL  = time.Minute
+	defaultSize = 1000
+)
+
+// Stats - статистика обращений к кэшу.
+type Stats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// entry - закэшированные ответы по одному посту. Ключ values - вид ответа
+// вместе с его параметрами. version увеличивается при каждом сбросе
+// ответов поста: ответ не кэшируется, если за время его построения
+// версия изменилась, чтобы не сохранить данные, прочитанные до изменения.
+type entry struct {
+	post    string
+	expires time.Time
+	values  map[string]any
+	version uint64
+}
+
+// Cache - кэш построенных ответов по постам с ограниченным временем жизни
+// и числом постов. При переполнении вытесняется пост, к которому дольше
+// всего не обращались. Запись поста сбрасывается при любом изменении его
+// комментариев. Методы чтения передаются хранилищу без изменений, ответы
+// кэшируются через Response.
+type Cache struct {
+	storage.DB
+
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex
+	posts map[string]*list.Element
+	lru   *list.List
+
+	group  singleflight.Group
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// New - конструктор кэша с настройками из конфига.
+func New(cfg *config.Config, db storage.DB) *Cache {
+	return new(db, cfg.Cache.TTL, cfg.Cache.Size)
+}
+
+// new - конструктор кэша. Для нулевых настроек используются значения
+// по умолчанию.
+func new(db storage.DB, ttl time.Duration, size int) *Cache {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if size <= 0 {
+		size = defaultSize
+	}
+	return &Cache{
+		DB:    db,
+		ttl:   ttl,
+		size:  size,
+		posts: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Unwrap возвращает хранилище, над которым сделана обертка.
+func (c *Cache) Unwrap() storage.DB {
+	return c.DB
+}
+
+// Stats возвращает статистику обращений к кэшу.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// get возвращает закэшированный ответ по посту.
+func (c *Cache) get(post, key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.posts[post]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.posts, post)
+		return nil, false
+	}
+	v, ok := e.values[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return v, true
+}
+
+// begin возвращает запись поста и ее версию перед построением ответа.
+// Если записи нет или она устарела, то создается пустая запись.
+func (c *Cache) begin(post string) (*entry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.posts[post]; ok {
+		e := el.Value.(*entry)
+		if !time.Now().After(e.expires) {
+			return e, e.version
+		}
+		c.lru.Remove(el)
+		delete(c.posts, post)
+	}
+
+	e := &entry{
+		post:    post,
+		expires: time.Now().Add(c.ttl),
+		values:  make(map[string]any),
+	}
+	c.posts[post] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.posts, last.Value.(*entry).post)
+	}
+	return e, e.version
+}
+
+// set сохраняет ответ в запись поста, если запись не вытеснена и с начала
+// построения ответа ответы поста не сбрасывались.
+func (c *Cache) set(e *entry, key string, version uint64, v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.posts[e.post]
+	if !ok || el.Value != e || e.version != version {
+		return
+	}
+	e.values[key] = v
+	c.lru.MoveToFront(el)
+}
+
+// discard удаляет запись поста без ответов, чтобы запросы к постам
+// без комментариев не вытесняли из кэша популярные посты.
+func (c *Cache) discard(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.posts[e.post]
+	if !ok || el.Value != e || len(e.values) > 0 {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.posts, e.post)
+}
+
+// invalidate сбрасывает ответы поста. Запись остается в кэше, чтобы
+// ответы, которые строятся во время сброса, не были сохранены.
+func (c *Cache) invalidate(post string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.posts[post]; ok {
+		e := el.Value.(*entry)
+		e.version++
+		clear(e.values)
+	}
+}
+
+// load возвращает ответ по посту из кэша или получает его через fetch.
+// Одновременные запросы одного ответа выполняют один общий вызов fetch.
+func (c *Cache) load(post, key string, fetch func() (any, error)) (any, error) {
+	if v, ok := c.get(post, key); ok {
+		c.hits.Add(1)
+		return v, nil
+	}
+	c.misses.Add(1)
+
+	v, err, _ := c.group.Do(post+"/"+key, func() (any, error) {
+		e, version := c.begin(post)
+
+		v, err := fetch()
+		if err != nil {
+			c.discard(e)
+			return nil, err
+		}
+		c.set(e, key, version, v)
+		return v, nil
+	})
+	return v, err
+}
+
+// Response возвращает построенный ответ по посту из кэша или строит его
+// через build. Ключ key задает вид ответа, например параметры запроса.
+// Ответ общий для всех вызывающих, поэтому его нельзя изменять. Ответ
+// не кэшируется, если build вернула ошибку.
+func (c *Cache) Response(ctx context.Context, post, key string, build func(ctx context.Context) (any, error)) (any, error) {
+	return c.load(post, key, func() (any, error) {
+		return build(context.WithoutCancel(ctx))
+	})
+}
+
+// AddComment записывает комментарий в хранилище и сбрасывает запись поста.
+func (c *Cache) AddComment(ctx context.Context, com storage.Comment) (string, error) {
+	id, err := c.DB.AddComment(ctx, com)
+	if err != nil {
+		return id, err
+	}
+	c.invalidate(com.PostID)
+	return id, nil
+}
+
+// SetStatus изменяет статус комментария и сбрасывает запись его поста.
+func (c *Cache) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	com, err := c.DB.SetStatus(ctx, id, status)
+	if err != nil {
+		return com, err
+	}
+	c.invalidate(com.PostID)
+	return com, nil
+}
+
+// UpdateComment изменяет комментарий и сбрасывает запись его поста.
+func (c *Cache) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	upd, err := c.DB.UpdateComment(ctx, com)
+	if err != nil {
+		return upd, err
+	}
+	c.invalidate(upd.PostID)
+	return upd, nil
+}
+
+// DeleteComment удаляет комментарий и сбрасывает запись его поста.
+func (c *Cache) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	com, err := c.DB.DeleteComment(ctx, id)
+	if err != nil {
+		return com, err
+	}
+	c.invalidate(com.PostID)
+	return com, nil
+}
+
+// React записывает реакцию на комментарий и сбрасывает запись его поста.
+func (c *Cache) React(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	com, err := c.DB.React(ctx, id, user, reaction)
+	if err != nil {
+		return com, err
+	}
+	c.invalidate(com.PostID)
+	return com, nil
+}
+
+// Unreact удаляет реакцию на комментарий и сбрасывает запись его поста.
+func (c *Cache) Unreact(ctx context.Context, id, user string) (storage.Comment, error) {
+	com, err := c.DB.Unreact(ctx, id, user)
+	if err != nil {
+		return com, err
+	}
+	c.invalidate(com.PostID)
+	return com, nil
+}
+
+// Report записывает жалобу на комментарий и сбрасывает запись его поста,
+// так как комментарий может быть скрыт.
+func (c *Cache) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	com, err := c.DB.Report(ctx, id, user, reason, threshold)
+	if err != nil {
+		return com, err
+	}
+	c.invalidate(com.PostID)
+	return com, nil
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"GoExamComments/internal/mocks"
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/memory"
+	"GoExamComments/internal/storage/storagetest"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCache_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.DB {
+		return new(memory.New(), time.Minute, 10)
+	})
+}
+
+// comments возвращает функцию построения ответа по посту из хранилища.
+func comments(st storage.DB, post string) func(ctx context.Context) (any, error) {
+	return func(ctx context.Context) (any, error) {
+		return st.Comments(ctx, post)
+	}
+}
+
+func TestCache_Response(t *testing.T) {
+	ctx := context.Background()
+	c := new(memory.New(), time.Minute, 10)
+	post := storage.NewID()
+
+	root, err := c.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = c.Response(ctx, post, "comments", comments(c, post))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if got := c.Stats(); got.Hits != 2 || got.Misses != 1 || got.Entries != 1 {
+		t.Errorf("Cache.Stats() = %+v, want 2 hits, 1 miss, 1 entry", got)
+	}
+
+	// Новый ответ сбрасывает запись поста.
+	_, err = c.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	got, err := c.Response(ctx, post, "comments", comments(c, post))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(got.([]storage.Comment)) != 2 {
+		t.Errorf("Cache.Response() len = %d, want %d", len(got.([]storage.Comment)), 2)
+	}
+	if got := c.Stats(); got.Misses != 2 {
+		t.Errorf("Cache.Stats() misses = %d, want %d", got.Misses, 2)
+	}
+
+	// Ошибка построения ответа не кэшируется.
+	other := storage.NewID()
+	for i := 0; i < 2; i++ {
+		_, err = c.Response(ctx, other, "comments", comments(c, other))
+		if !errors.Is(err, storage.ErrNoComments) {
+			t.Fatalf("Cache.Response() error = %v, wantErr %v", err, storage.ErrNoComments)
+		}
+	}
+	if got := c.Stats(); got.Misses != 4 {
+		t.Errorf("Cache.Stats() misses = %d, want %d", got.Misses, 4)
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	c := new(memory.New(), time.Minute, 10)
+	posts := []string{storage.NewID(), storage.NewID()}
+
+	// Сброс другого поста во время построения ответа не мешает сохранить
+	// ответ, а сброс этого же поста - мешает.
+	tests := []struct {
+		name  string
+		post  string
+		reset string
+		hit   bool
+	}{
+		{name: "Other_Post", post: posts[0], reset: posts[1], hit: true},
+		{name: "Same_Post", post: posts[1], reset: posts[1], hit: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := func(context.Context) (any, error) {
+				c.invalidate(tt.reset)
+				return "response", nil
+			}
+			_, err := c.Response(ctx, tt.post, "key", build)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			before := c.Stats().Hits
+			_, err = c.Response(ctx, tt.post, "key", build)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if hit := c.Stats().Hits > before; hit != tt.hit {
+				t.Errorf("Cache.Response() hit = %t, want %t", hit, tt.hit)
+			}
+		})
+	}
+}
+
+func TestCache_Stampede(t *testing.T) {
+	post := storage.NewID()
+	stMock := mocks.NewDB(t)
+	stMock.
+		On("Comments", mock.Anything, post).
+		Return([]storage.Comment{{ID: storage.NewID(), PostID: post}}, nil).
+		After(50 * time.Millisecond).
+		Once()
+
+	c := new(stMock, time.Minute, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.Response(context.Background(), post, "comments", comments(stMock, post))
+			if err != nil || len(got.([]storage.Comment)) != 1 {
+				t.Errorf("Cache.Response() = %v, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCache_Bounds(t *testing.T) {
+	ctx := context.Background()
+	st := memory.New()
+	c := new(st, 50*time.Millisecond, 2)
+
+	var posts []string
+	for i := 0; i < 3; i++ {
+		post := storage.NewID()
+		_, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "text"})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		posts = append(posts, post)
+	}
+
+	// Третий пост вытесняет первый, к которому дольше всего
+	// не обращались.
+	for _, post := range posts {
+		c.Response(ctx, post, "comments", comments(st, post))
+	}
+	c.Response(ctx, posts[0], "comments", comments(st, posts[0]))
+	if got := c.Stats(); got.Entries != 2 || got.Hits != 0 || got.Misses != 4 {
+		t.Errorf("Cache.Stats() = %+v, want 2 entries, 0 hits, 4 misses", got)
+	}
+
+	// Запись поста устаревает по истечении времени жизни.
+	time.Sleep(60 * time.Millisecond)
+	c.Response(ctx, posts[0], "comments", comments(st, posts[0]))
+	if got := c.Stats(); got.Hits != 0 || got.Misses != 5 {
+		t.Errorf("Cache.Stats() = %+v, want 0 hits, 5 misses", got)
+	}
+}
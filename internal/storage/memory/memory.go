@@ -0,0 +1,678 @@
+// Пакет memory содержит хранилище комментариев в памяти процесса. Хранилище
+// используется в тестах и демонстрациях, данные теряются при остановке
+// сервиса.
+package memory
+
+import (
+	"GoExamComments/internal/storage"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// record - комментарий вместе с историей изменений его текста, реакциями
+// и жалобами читателей. reactions - реакция каждого пользователя,
+// reports - причина жалобы каждого пользователя.
+type record struct {
+	storage.Comment
+	history   []storage.Revision
+	reactions map[string]string
+	reports   map[string]string
+	// reviewed - число жалоб на момент одобрения модератором.
+	reviewed int
+}
+
+// Storage - потокобезопасное хранилище комментариев в памяти.
+type Storage struct {
+	mu       sync.RWMutex
+	comments map[string]*record
+	// children - ID ответов на комментарий, posts - ID комментариев
+	// к посту, authors - ID комментариев автора. Индексы заполняются
+	// при добавлении комментария.
+	children map[string][]string
+	posts    map[string][]string
+	authors  map[string][]string
+}
+
+// New - конструктор пустого хранилища.
+func New() *Storage {
+	return &Storage{
+		comments: make(map[string]*record),
+		children: make(map[string][]string),
+		posts:    make(map[string][]string),
+		authors:  make(map[string][]string),
+	}
+}
+
+// Close ничего не делает, метод нужен для соответствия интерфейсу
+// storage.DB.
+func (s *Storage) Close() error {
+	return nil
+}
+
+// now возвращает текущее время с точностью до миллисекунды, как в MongoDB.
+// Курсоры страниц тоже хранят время с этой точностью.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// visible сообщает, виден ли комментарий читателям.
+func visible(com storage.Comment) bool {
+	return com.Status != storage.StatusPending && com.Status != storage.StatusRejected
+}
+
+// newest сортирует комментарии по убыванию даты создания, при равной дате -
+// по убыванию ID.
+func newest(comments []storage.Comment) {
+	sort.Slice(comments, func(i, j int) bool {
+		if !comments[i].PubTime.Equal(comments[j].PubTime) {
+			return comments[i].PubTime.After(comments[j].PubTime)
+		}
+		return comments[i].ID > comments[j].ID
+	})
+}
+
+// afterCursor возвращает фильтр комментариев, которые идут после курсора
+// при сортировке по убыванию даты создания, а при oldest - по
+// возрастанию. Для пустого курсора фильтр пропускает все комментарии.
+func afterCursor(cursor string, oldest bool) (func(com storage.Comment) bool, error) {
+	if cursor == "" {
+		return func(storage.Comment) bool { return true }, nil
+	}
+	tm, id, err := storage.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if !storage.ValidID(id) {
+		return nil, storage.ErrIncorrectCursor
+	}
+	if oldest {
+		return func(com storage.Comment) bool {
+			return com.PubTime.After(tm) || com.PubTime.Equal(tm) && com.ID > id
+		}, nil
+	}
+	return func(com storage.Comment) bool {
+		return com.PubTime.Before(tm) || com.PubTime.Equal(tm) && com.ID < id
+	}, nil
+}
+
+// descendants возвращает видимые читателям ответы на комментарии
+// с переданными ID не глубже depth уровней. При depth меньше нуля глубина
+// не ограничена. Ответы на скрытые комментарии скрыты вместе с ними.
+// Вызывается под блокировкой.
+func (s *Storage) descendants(ids []string, depth int) []storage.Comment {
+	var replies []storage.Comment
+	for level := 1; len(ids) > 0 && (depth < 0 || level <= depth); level++ {
+		var next []string
+		for _, id := range ids {
+			for _, child := range s.children[id] {
+				rec := s.comments[child]
+				if !visible(rec.Comment) {
+					continue
+				}
+				replies = append(replies, rec.Comment)
+				next = append(next, child)
+			}
+		}
+		ids = next
+	}
+	return replies
+}
+
+// shown сообщает, виден ли комментарий читателям вместе со всеми его
+// предками. Отсутствующие предки не скрывают комментарий. Вызывается
+// под блокировкой.
+func (s *Storage) shown(com storage.Comment) bool {
+	for {
+		if !visible(com) {
+			return false
+		}
+		parent, ok := s.comments[com.ParentID]
+		if !ok {
+			return true
+		}
+		com = parent.Comment
+	}
+}
+
+// AddComment записывает переданный комментарий в хранилище.
+func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string, error) {
+	const operation = "storage.memory.AddComment"
+
+	if !storage.ValidID(com.PostID) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+	if com.ParentID != "" && !storage.ValidID(com.ParentID) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectParentID)
+	}
+	if !storage.ValidAuthor(com.AuthorID, com.AuthorName) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectAuthor)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Проверим, что родительский комментарий существует и виден читателям,
+	// чтобы избежать вставки комментария с некорректной связью.
+	if com.ParentID != "" {
+		parent, ok := s.comments[com.ParentID]
+		if !ok || parent.Deleted || !visible(parent.Comment) {
+			return "", fmt.Errorf("%s: %w", operation, storage.ErrParentNotFound)
+		}
+	}
+
+	if com.Status == "" {
+		com.Status = storage.StatusApproved
+	}
+
+	rec := &record{Comment: storage.Comment{
+		ID:         storage.NewID(),
+		ParentID:   com.ParentID,
+		PostID:     com.PostID,
+		AuthorID:   com.AuthorID,
+		AuthorName: com.AuthorName,
+		PubTime:    now(),
+		Content:    com.Content,
+		Status:     com.Status,
+	}}
+	s.comments[rec.ID] = rec
+	s.posts[rec.PostID] = append(s.posts[rec.PostID], rec.ID)
+	if rec.AuthorID != "" {
+		s.authors[rec.AuthorID] = append(s.authors[rec.AuthorID], rec.ID)
+	}
+	if rec.ParentID != "" {
+		s.children[rec.ParentID] = append(s.children[rec.ParentID], rec.ID)
+	}
+
+	return rec.ID, nil
+}
+
+// Comments возвращает все видимые читателям комментарии по переданному
+// ID поста, отсортированные по дате создания. Удаленные комментарии тоже
+// возвращаются, чтобы сохранить связи в дереве. Ответы на скрытые
+// комментарии скрыты вместе с ними.
+func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment, error) {
+	const operation = "storage.memory.Comments"
+
+	if !storage.ValidID(post) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var comments []storage.Comment
+	for _, id := range s.posts[post] {
+		rec := s.comments[id]
+		if s.shown(rec.Comment) {
+			comments = append(comments, rec.Comment)
+		}
+	}
+
+	if len(comments) == 0 {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+	}
+	newest(comments)
+	return comments, nil
+}
+
+// Threads возвращает страницу корневых комментариев к посту вместе со всеми
+// ответами на них. Корневые комментарии отсортированы по убыванию даты
+// создания, а при oldest - по возрастанию, на странице не более limit
+// корневых комментариев.
+func (s *Storage) Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (storage.Page, error) {
+	const operation = "storage.memory.Threads"
+
+	var page storage.Page
+
+	if !storage.ValidID(post) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	after, err := afterCursor(cursor, oldest)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var roots []storage.Comment
+	for _, id := range s.posts[post] {
+		rec := s.comments[id]
+		if rec.ParentID != "" || !visible(rec.Comment) {
+			continue
+		}
+		if !after(rec.Comment) {
+			continue
+		}
+		roots = append(roots, rec.Comment)
+	}
+
+	if len(roots) == 0 {
+		if cursor == "" {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+		}
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	newest(roots)
+	if oldest {
+		slices.Reverse(roots)
+	}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		last := roots[len(roots)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+
+	ids := make([]string, 0, len(roots))
+	for _, root := range roots {
+		ids = append(ids, root.ID)
+	}
+	replies := s.descendants(ids, -1)
+	newest(replies)
+
+	page.Comments = append(roots, replies...)
+	return page, nil
+}
+
+// AuthorComments возвращает страницу видимых читателям неудаленных
+// комментариев автора к любым постам. Комментарии отсортированы
+// по убыванию даты создания, на странице не более limit комментариев.
+func (s *Storage) AuthorComments(ctx context.Context, author string, limit int, cursor string) (storage.Page, error) {
+	const operation = "storage.memory.AuthorComments"
+
+	var page storage.Page
+
+	if !storage.ValidUserID(author) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	after, err := afterCursor(cursor, false)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := []storage.Comment{}
+	for _, id := range s.authors[author] {
+		rec := s.comments[id]
+		if rec.Deleted || !visible(rec.Comment) || !after(rec.Comment) {
+			continue
+		}
+		comments = append(comments, rec.Comment)
+	}
+
+	newest(comments)
+	if len(comments) > limit {
+		comments = comments[:limit]
+		last := comments[len(comments)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+	page.Comments = comments
+	return page, nil
+}
+
+// Subtree возвращает видимый читателям комментарий с переданным ID и ответы
+// на него не глубже depth уровней. Ответы на скрытые комментарии скрыты
+// вместе с ними. Удаленный комментарий без видимых неудаленных ответов
+// на любой глубине не найден. Комментарий идет первым, ответы
+// отсортированы по дате создания.
+func (s *Storage) Subtree(ctx context.Context, id string, depth int) ([]storage.Comment, error) {
+	const operation = "storage.memory.Subtree"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.comments[id]
+	if !ok || !visible(rec.Comment) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	if rec.Deleted && !slices.ContainsFunc(s.descendants([]string{id}, -1), func(c storage.Comment) bool {
+		return !c.Deleted
+	}) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+
+	comments := []storage.Comment{rec.Comment}
+	if depth <= 0 {
+		return comments, nil
+	}
+	replies := s.descendants([]string{id}, depth)
+	newest(replies)
+
+	return append(comments, replies...), nil
+}
+
+// Comment возвращает комментарий по ID независимо от его статуса
+// модерации.
+func (s *Storage) Comment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.memory.Comment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.comments[id]
+	if !ok {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	return rec.Comment, nil
+}
+
+// Counts возвращает количество видимых читателям неудаленных комментариев
+// к каждому из переданных постов. Посты без комментариев присутствуют
+// в ответе с нулевым значением.
+func (s *Storage) Counts(ctx context.Context, posts []string) (map[string]int, error) {
+	const operation = "storage.memory.Counts"
+
+	for _, post := range posts {
+		if !storage.ValidID(post) {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int, len(posts))
+	for _, post := range posts {
+		counts[post] = 0
+		for _, id := range s.posts[post] {
+			rec := s.comments[id]
+			if !rec.Deleted && visible(rec.Comment) {
+				counts[post]++
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+// Pending возвращает очередь комментариев, ожидающих проверки модератором,
+// начиная с самых старых. Возвращает не более limit комментариев.
+func (s *Storage) Pending(ctx context.Context, limit int) ([]storage.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := []storage.Comment{}
+	for _, rec := range s.comments {
+		if rec.Status == storage.StatusPending {
+			comments = append(comments, rec.Comment)
+		}
+	}
+
+	sort.Slice(comments, func(i, j int) bool {
+		if !comments[i].PubTime.Equal(comments[j].PubTime) {
+			return comments[i].PubTime.Before(comments[j].PubTime)
+		}
+		return comments[i].ID < comments[j].ID
+	})
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+	return comments, nil
+}
+
+// SetStatus устанавливает статус модерации комментария с переданным ID
+// и возвращает обновленный комментарий. Одобрение отмечает жалобы на
+// комментарий проверенными.
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	const operation = "storage.memory.SetStatus"
+
+	if !storage.ValidStatus(status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[id]
+	if !ok {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	rec.Status = status
+	if status == storage.StatusApproved {
+		rec.reviewed = rec.Reports
+	}
+
+	return rec.Comment, nil
+}
+
+// UpdateComment заменяет текст и статус модерации неудаленного комментария
+// с ID из переданной структуры. Пустой статус не изменяет прежний статус.
+// Предыдущий текст вместе со временем его публикации сохраняется в истории
+// изменений. Возвращает обновленный комментарий.
+func (s *Storage) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	const operation = "storage.memory.UpdateComment"
+
+	if com.Content == "" {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrEmptyContent)
+	}
+	if com.Status != "" && !storage.ValidStatus(com.Status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(com.ID) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[com.ID]
+	if !ok || rec.Deleted {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	rec.pushHistory()
+	edited := now()
+	rec.Content = com.Content
+	if com.Status != "" {
+		rec.Status = com.Status
+	}
+	rec.EditedAt = &edited
+
+	return rec.Comment, nil
+}
+
+// History возвращает предыдущие версии текста комментария с переданным ID,
+// начиная с самой старой.
+func (s *Storage) History(ctx context.Context, id string) ([]storage.Revision, error) {
+	const operation = "storage.memory.History"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.comments[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+
+	revs := make([]storage.Revision, len(rec.history))
+	copy(revs, rec.history)
+	return revs, nil
+}
+
+// DeleteComment помечает комментарий с переданным ID как удаленный и убирает
+// его текст. Комментарий остается в хранилище, чтобы ответы на него
+// не потеряли родителя. Текст сохраняется в истории изменений. Возвращает
+// удаленный комментарий.
+func (s *Storage) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.memory.DeleteComment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[id]
+	if !ok || rec.Deleted {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	rec.pushHistory()
+	rec.Content = ""
+	rec.Deleted = true
+
+	return rec.Comment, nil
+}
+
+// React записывает реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Предыдущая реакция пользователя на этот
+// комментарий заменяется. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) React(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	const operation = "storage.memory.React"
+
+	if !storage.ValidReaction(reaction) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReaction)
+	}
+	return s.setReaction(operation, id, user, reaction)
+}
+
+// Unreact удаляет реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) Unreact(ctx context.Context, id, user string) (storage.Comment, error) {
+	const operation = "storage.memory.Unreact"
+
+	return s.setReaction(operation, id, user, "")
+}
+
+// setReaction заменяет реакцию пользователя на комментарий и пересчитывает
+// число реакций. Пустая реакция удаляет предыдущую.
+func (s *Storage) setReaction(operation, id, user, reaction string) (storage.Comment, error) {
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[id]
+	if !ok || rec.Deleted || !visible(rec.Comment) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+
+	likes, dislikes := storage.ReactionDelta(rec.reactions[user], reaction)
+	rec.Likes += likes
+	rec.Dislikes += dislikes
+	if reaction == "" {
+		delete(rec.reactions, user)
+	} else {
+		if rec.reactions == nil {
+			rec.reactions = make(map[string]string)
+		}
+		rec.reactions[user] = reaction
+	}
+
+	return rec.Comment, nil
+}
+
+// Report записывает жалобу пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Повторная жалоба пользователя на тот же
+// комментарий не принимается. Когда число жалоб достигает порога
+// threshold, комментарий отправляется на проверку модератору. Возвращает
+// комментарий с обновленным числом жалоб и статусом.
+func (s *Storage) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	const operation = "storage.memory.Report"
+
+	if !storage.ValidReason(reason) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReason)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.comments[id]
+	if !ok || rec.Deleted || !visible(rec.Comment) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+	if _, ok := rec.reports[user]; ok {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrAlreadyReported)
+	}
+
+	if rec.reports == nil {
+		rec.reports = make(map[string]string)
+	}
+	rec.reports[user] = reason
+	rec.Reports++
+	if storage.Hide(rec.Status, rec.Reports, threshold) {
+		rec.Status = storage.StatusPending
+	}
+
+	return rec.Comment, nil
+}
+
+// Reported возвращает неудаленные и не отклоненные комментарии с жалобами,
+// поступившими после одобрения модератором, начиная с комментариев
+// с наибольшим числом жалоб. При равном числе жалоб сначала идут новые.
+// Возвращает не более limit комментариев.
+func (s *Storage) Reported(ctx context.Context, limit int) ([]storage.Reported, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var comments []storage.Comment
+	for _, rec := range s.comments {
+		if rec.Reports > rec.reviewed && !rec.Deleted && rec.Status != storage.StatusRejected {
+			comments = append(comments, rec.Comment)
+		}
+	}
+
+	newest(comments)
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Reports > comments[j].Reports
+	})
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+
+	list := make([]storage.Reported, 0, len(comments))
+	for _, com := range comments {
+		reasons := make(map[string]int)
+		for _, reason := range s.comments[com.ID].reports {
+			reasons[reason]++
+		}
+		list = append(list, storage.Reported{Comment: com, Reasons: reasons})
+	}
+	return list, nil
+}
+
+// pushHistory добавляет текущий текст комментария вместе со временем его
+// публикации в конец истории изменений.
+func (r *record) pushHistory() {
+	tm := r.PubTime
+	if r.EditedAt != nil {
+		tm = *r.EditedAt
+	}
+	r.history = append(r.history, storage.Revision{Content: r.Content, Time: tm})
+}
@@ -0,0 +1,51 @@
+// Пакет memory содержит хранилище комментариев в памяти процесса. Хранилище
+// используется в тестах и демонстрациях, данные теряются при остановке
+// сервиса.
+
+package memory
+
+import (
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/storagetest"
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.DB {
+		return New()
+	})
+}
+
+func TestStorage_Concurrent(t *testing.T) {
+	st := New()
+	ctx := context.Background()
+	post := storage.NewID()
+	root, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+		}()
+		go func() {
+			defer wg.Done()
+			st.Threads(ctx, post, 10, "", false)
+		}()
+	}
+	wg.Wait()
+
+	counts, err := st.Counts(ctx, []string{post})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if counts[post] != 21 {
+		t.Errorf("Storage.Counts() = %d, want %d", counts[post], 21)
+	}
+}
@@ -3,10 +3,12 @@ package mongodb
 
 import (
 	"GoExamComments/internal/config"
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/storage"
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,12 +17,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Название базы и коллекции в БД. Используются переменные,
+// Название базы и коллекций в БД. Используются переменные,
 // а не константы, так как в тестах им присваиваются другие
-// значения.
+// значения. В коллекциях reactName и reportName хранятся реакции и жалобы
+// читателей, в коллекции limitName - корзины токенов для ограничения
+// частоты запросов, в коллекции keyName - результаты запросов с ключами
+// идемпотентности.
 var (
-	dbName  string = "goExam"
-	colName string = "comments"
+	dbName     string = "goExam"
+	colName    string = "comments"
+	reactName  string = "reactions"
+	reportName string = "reports"
+	limitName  string = "ratelimits"
+	keyName    string = "idempotency"
 )
 
 // tmConn - таймаут на создание пула подключений.
@@ -29,6 +38,9 @@ const tmConn time.Duration = time.Second * 20
 // Storage - пул подключений к БД.
 type Storage struct {
 	db *mongo.Client
+	// resume - токен возобновления потока изменений, чтобы после
+	// переподключения не пропустить новые комментарии.
+	resume bson.Raw
 }
 
 // New - обертка для конструктора пула подключений new.
@@ -77,12 +89,66 @@ func new(opts *options.ClientOptions) (*Storage, error) {
 	}
 
 	// Создаем индекс по полю postId, чтобы ускорить выдачу всех комментариев
-	// по переданному ID поста.
+	// по переданному ID поста, индекс по статусу для очереди модерации,
+	// индексы для постраничной выдачи корневых комментариев и их ответов,
+	// комментариев с жалобами и комментариев автора.
 	collection := db.Database(dbName).Collection(colName)
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{{Key: "postId", Value: -1}},
+	indexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "postId", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "pubTime", Value: 1}}},
+		{Keys: bson.D{
+			{Key: "postId", Value: 1},
+			{Key: "parentId", Value: 1},
+			{Key: "pubTime", Value: -1},
+			{Key: "_id", Value: -1},
+		}},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "reports", Value: -1}, {Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(
+				bson.D{{Key: "reports", Value: bson.D{{Key: "$gt", Value: 0}}}},
+			),
+		},
+		{
+			Keys: bson.D{{Key: "authorId", Value: 1}, {Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(
+				bson.D{{Key: "authorId", Value: bson.D{{Key: "$exists", Value: true}}}},
+			),
+		},
 	}
-	_, err = collection.Indexes().CreateOne(tm, indexModel)
+	_, err = collection.Indexes().CreateMany(tm, indexModels)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	// Уникальные индексы не дают пользователю оставить две реакции
+	// или две жалобы на один комментарий.
+	for _, name := range []string{reactName, reportName} {
+		_, err = db.Database(dbName).Collection(name).Indexes().CreateOne(tm, mongo.IndexModel{
+			Keys:    bson.D{{Key: "commentId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+
+	// Корзины токенов удаляются после того, как успели наполниться:
+	// новая корзина тоже полная. Ключи идемпотентности удаляются после
+	// окончания времени хранения.
+	for _, name := range []string{limitName, keyName} {
+		_, err = db.Database(dbName).Collection(name).Indexes().CreateOne(tm, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+
+	// Комментарии, сохраненные до появления массива предков, не попадают
+	// в выдачу ответов по веткам, поэтому массив заполняется при запуске.
+	err = backfill(context.Background(), collection)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", operation, err)
 	}
@@ -90,6 +156,143 @@ func new(opts *options.ClientOptions) (*Storage, error) {
 	return &Storage{db: db}, nil
 }
 
+// backfill заполняет массив предков ancestors у комментариев, в которых его
+// нет. Предки комментария - предки его родителя и сам родитель. Если
+// родитель не найден, то массив состоит из его ID. После заполнения
+// документов без массива не остается, поэтому повторный запуск ничего
+// не делает.
+func backfill(ctx context.Context, collection *mongo.Collection) error {
+	const operation = "storage.mongodb.backfill"
+
+	filter := bson.D{{Key: "ancestors", Value: bson.D{{Key: "$exists", Value: false}}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "parentId", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	var docs []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		ParentID string             `bson:"parentId"`
+	}
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	parents := make(map[string]string, len(docs))
+	for _, doc := range docs {
+		parents[doc.ID.Hex()] = doc.ParentID
+	}
+	known := make(map[string][]string, len(docs))
+	var ancestors func(id string) ([]string, error)
+	ancestors = func(id string) ([]string, error) {
+		if a, ok := known[id]; ok {
+			return a, nil
+		}
+		a := []string{}
+		if parent, ok := parents[id]; ok {
+			if parent != "" {
+				pa, err := ancestors(parent)
+				if err != nil {
+					return nil, err
+				}
+				a = append(append(a, pa...), parent)
+			}
+			known[id] = a
+			return a, nil
+		}
+
+		// Комментарий уже имеет массив предков или не найден.
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			known[id] = a
+			return a, nil
+		}
+		var doc struct {
+			Ancestors []string `bson:"ancestors"`
+		}
+		opts := options.FindOne().SetProjection(bson.D{{Key: "ancestors", Value: 1}})
+		err = collection.FindOne(ctx, bson.D{{Key: "_id", Value: oid}}, opts).Decode(&doc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		a = append(a, doc.Ancestors...)
+		known[id] = a
+		return a, nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		a, err := ancestors(doc.ID.Hex())
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: doc.ID}, filter[0]}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "ancestors", Value: a}}}}))
+	}
+	_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+// visible - фильтр комментариев, которые видны читателям. Комментарии
+// без статуса тоже попадают в выборку.
+func visible() bson.E {
+	return bson.E{Key: "status", Value: bson.D{{Key: "$nin", Value: bson.A{storage.StatusPending, storage.StatusRejected}}}}
+}
+
+// alive - фильтр неудаленных комментариев.
+func alive() bson.E {
+	return bson.E{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}}
+}
+
+// hidden возвращает ID скрытых от читателей комментариев к посту.
+// Комментарии с такими ID в списке предков скрыты вместе с ними.
+func (s *Storage) hidden(ctx context.Context, post string) (bson.A, error) {
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{
+		{Key: "postId", Value: post},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{storage.StatusPending, storage.StatusRejected}}}},
+	}
+	ids, err := collection.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			hidden = append(hidden, oid.Hex())
+		}
+	}
+	return hidden, nil
+}
+
+// pushHistory возвращает выражение конвейера агрегации, которое добавляет
+// текущий текст комментария вместе со временем его публикации в конец
+// истории изменений.
+func pushHistory() bson.D {
+	revision := bson.D{
+		{Key: "content", Value: "$content"},
+		{Key: "time", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$editedAt", "$pubTime"}}}},
+	}
+	return bson.D{{Key: "$concatArrays", Value: bson.A{
+		bson.D{{Key: "$ifNull", Value: bson.A{"$history", bson.A{}}}},
+		bson.A{revision},
+	}}}
+}
+
+// noHistory - проекция, исключающая историю изменений из выборки.
+func noHistory() bson.D {
+	return bson.D{{Key: "history", Value: 0}}
+}
+
 // Close - обертка для закрытия пула подключений.
 func (s *Storage) Close() error {
 	return s.db.Disconnect(context.Background())
@@ -102,24 +305,41 @@ func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string,
 	if _, err := primitive.ObjectIDFromHex(com.PostID); err != nil {
 		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
 	}
+	if !storage.ValidAuthor(com.AuthorID, com.AuthorName) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectAuthor)
+	}
 
 	collection := s.db.Database(dbName).Collection(colName)
 
-	// Проверим, что родительский комментарий существует, чтобы
-	// избежать вставки комментария с некорректной связью.
+	// Проверим, что родительский комментарий существует и виден читателям,
+	// чтобы избежать вставки комментария с некорректной связью. Список
+	// предков нового комментария - это предки родителя и сам родитель.
+	ancestors := bson.A{}
 	if com.ParentID != "" {
 		id, err := primitive.ObjectIDFromHex(com.ParentID)
 		if err != nil {
 			return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectParentID)
 		}
-		filter := bson.D{{Key: "_id", Value: id}}
-		res := collection.FindOne(ctx, filter)
-		if res.Err() != nil {
-			if res.Err() == mongo.ErrNoDocuments {
+		var parent struct {
+			Ancestors []string `bson:"ancestors"`
+		}
+		filter := bson.D{{Key: "_id", Value: id}, visible(), alive()}
+		opts := options.FindOne().SetProjection(bson.D{{Key: "ancestors", Value: 1}})
+		err = collection.FindOne(ctx, filter, opts).Decode(&parent)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
 				return "", fmt.Errorf("%s: %w", operation, storage.ErrParentNotFound)
 			}
-			return "", fmt.Errorf("%s: %w", operation, res.Err())
+			return "", fmt.Errorf("%s: %w", operation, err)
 		}
+		for _, a := range parent.Ancestors {
+			ancestors = append(ancestors, a)
+		}
+		ancestors = append(ancestors, com.ParentID)
+	}
+
+	if com.Status == "" {
+		com.Status = storage.StatusApproved
 	}
 
 	id := primitive.NewObjectID()
@@ -127,8 +347,18 @@ func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string,
 		{Key: "_id", Value: id},
 		{Key: "parentId", Value: com.ParentID},
 		{Key: "postId", Value: com.PostID},
+		{Key: "ancestors", Value: ancestors},
 		{Key: "pubTime", Value: primitive.NewDateTimeFromTime(time.Now())},
 		{Key: "content", Value: com.Content},
+		{Key: "status", Value: com.Status},
+	}
+	// У анонимных комментариев поля автора нет, чтобы они не попадали
+	// в частичный индекс по автору.
+	if com.AuthorID != "" {
+		bsn = append(bsn,
+			bson.E{Key: "authorId", Value: com.AuthorID},
+			bson.E{Key: "authorName", Value: com.AuthorName},
+		)
 	}
 
 	_, err := collection.InsertOne(ctx, bsn)
@@ -139,10 +369,12 @@ func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string,
 	return id.Hex(), nil
 }
 
-// Comments возвращает все деревья комментариев по переданному ID поста,
-// отсортированные по дате создания.
+// Comments возвращает все видимые читателям комментарии по переданному
+// ID поста, отсортированные по дате создания. Удаленные комментарии тоже
+// возвращаются, чтобы сохранить связи в дереве. Ответы на скрытые
+// комментарии скрыты вместе с ними.
 func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment, error) {
-	const operation = "storage.mongodb.AddComment"
+	const operation = "storage.mongodb.Comments"
 
 	if post == "" {
 		return nil, storage.ErrIncorrectPostID
@@ -151,10 +383,21 @@ func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment,
 		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
 	}
 
+	hidden, err := s.hidden(ctx, post)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
 	var comments []storage.Comment
 	collection := s.db.Database(dbName).Collection(colName)
-	opts := options.Find().SetSort(bson.D{{Key: "pubTime", Value: -1}})
-	filter := bson.D{{Key: "postId", Value: post}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}}).
+		SetProjection(noHistory())
+	filter := bson.D{
+		{Key: "postId", Value: post},
+		{Key: "ancestors", Value: bson.D{{Key: "$nin", Value: hidden}}},
+		visible(),
+	}
 
 	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -171,3 +414,956 @@ func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment,
 	}
 	return comments, nil
 }
+
+// Threads возвращает страницу корневых комментариев к посту вместе со всеми
+// ответами на них. Корневые комментарии отсортированы по убыванию даты
+// создания, а при oldest - по возрастанию, на странице не более limit
+// корневых комментариев. Курсор следующей страницы кодирует дату и ID
+// последнего корневого комментария.
+func (s *Storage) Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (storage.Page, error) {
+	const operation = "storage.mongodb.Threads"
+
+	var page storage.Page
+
+	if _, err := primitive.ObjectIDFromHex(post); err != nil {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	sign, dir := "$lt", -1
+	if oldest {
+		sign, dir = "$gt", 1
+	}
+
+	filter := bson.D{{Key: "postId", Value: post}, {Key: "parentId", Value: ""}, visible()}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		pt := primitive.NewDateTimeFromTime(tm)
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "pubTime", Value: bson.D{{Key: sign, Value: pt}}}},
+			bson.D{{Key: "pubTime", Value: pt}, {Key: "_id", Value: bson.D{{Key: sign, Value: oid}}}},
+		}})
+	}
+
+	// Запрашиваем на один корневой комментарий больше, чтобы узнать,
+	// есть ли следующая страница.
+	collection := s.db.Database(dbName).Collection(colName)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: dir}, {Key: "_id", Value: dir}}).
+		SetLimit(int64(limit + 1)).
+		SetProjection(noHistory())
+
+	cursorDB, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	var roots []storage.Comment
+	err = cursorDB.All(ctx, &roots)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(roots) == 0 {
+		if cursor == "" {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+		}
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		last := roots[len(roots)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+
+	ids := make(bson.A, 0, len(roots))
+	for _, root := range roots {
+		ids = append(ids, root.ID)
+	}
+
+	// Ответы на корневые комментарии страницы выбираются по списку предков.
+	hidden, err := s.hidden(ctx, post)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	var replies []storage.Comment
+	filter = bson.D{
+		{Key: "ancestors", Value: bson.D{{Key: "$in", Value: ids}, {Key: "$nin", Value: hidden}}},
+		visible(),
+	}
+	opts = options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}}).
+		SetProjection(noHistory())
+
+	cursorDB, err = collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	err = cursorDB.All(ctx, &replies)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	page.Comments = append(roots, replies...)
+	return page, nil
+}
+
+// AuthorComments возвращает страницу видимых читателям неудаленных
+// комментариев автора к любым постам. Комментарии отсортированы
+// по убыванию даты создания, на странице не более limit комментариев.
+func (s *Storage) AuthorComments(ctx context.Context, author string, limit int, cursor string) (storage.Page, error) {
+	const operation = "storage.mongodb.AuthorComments"
+
+	var page storage.Page
+
+	if !storage.ValidUserID(author) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	filter := bson.D{{Key: "authorId", Value: author}, visible(), alive()}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		pt := primitive.NewDateTimeFromTime(tm)
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "pubTime", Value: bson.D{{Key: "$lt", Value: pt}}}},
+			bson.D{{Key: "pubTime", Value: pt}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: oid}}}},
+		}})
+	}
+
+	// Запрашиваем на один комментарий больше, чтобы узнать, есть ли
+	// следующая страница.
+	collection := s.db.Database(dbName).Collection(colName)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1)).
+		SetProjection(noHistory())
+
+	cursorDB, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments := []storage.Comment{}
+	err = cursorDB.All(ctx, &comments)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(comments) > limit {
+		comments = comments[:limit]
+		last := comments[len(comments)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+	page.Comments = comments
+	return page, nil
+}
+
+// Subtree возвращает видимый читателям комментарий с переданным ID и ответы
+// на него не глубже depth уровней. Ответы на скрытые комментарии скрыты
+// вместе с ними. Удаленный комментарий без видимых неудаленных ответов
+// на любой глубине не найден. Комментарий идет первым, ответы
+// отсортированы по дате создания.
+func (s *Storage) Subtree(ctx context.Context, id string, depth int) ([]storage.Comment, error) {
+	const operation = "storage.mongodb.Subtree"
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	var doc struct {
+		storage.Comment `bson:",inline"`
+		Ancestors       []string `bson:"ancestors"`
+	}
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}, visible()}
+	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(noHistory())).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	// Ответы на скрытые комментарии скрыты вместе с ними.
+	hidden, err := s.hidden(ctx, doc.PostID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	under := bson.E{Key: "ancestors", Value: bson.D{{Key: "$in", Value: bson.A{id}}, {Key: "$nin", Value: hidden}}}
+	if doc.Deleted {
+		filter := bson.D{under, alive(), visible()}
+		n, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+	}
+
+	comments := []storage.Comment{doc.Comment}
+	if depth <= 0 {
+		return comments, nil
+	}
+
+	// У ответа на глубине d от комментария len(doc.Ancestors)+d предков,
+	// поэтому у ответов не глубже depth нет предка с индексом
+	// len(doc.Ancestors)+depth.
+	maxLen := fmt.Sprintf("ancestors.%d", len(doc.Ancestors)+depth)
+	filter = bson.D{
+		under,
+		{Key: maxLen, Value: bson.D{{Key: "$exists", Value: false}}},
+		visible(),
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}}).
+		SetProjection(noHistory())
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	var replies []storage.Comment
+	err = cursor.All(ctx, &replies)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return append(comments, replies...), nil
+}
+
+// Comment возвращает комментарий по ID независимо от его статуса
+// модерации.
+func (s *Storage) Comment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.mongodb.Comment"
+
+	var com storage.Comment
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	opts := options.FindOne().SetProjection(noHistory())
+	err = collection.FindOne(ctx, bson.D{{Key: "_id", Value: oid}}, opts).Decode(&com)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Counts возвращает количество видимых читателям неудаленных комментариев
+// к каждому из переданных постов. Посты без комментариев присутствуют
+// в ответе с нулевым значением. Подсчет выполняется одной агрегацией
+// по индексу postId.
+func (s *Storage) Counts(ctx context.Context, posts []string) (map[string]int, error) {
+	const operation = "storage.mongodb.Counts"
+
+	counts := make(map[string]int, len(posts))
+	ids := make(bson.A, 0, len(posts))
+	for _, post := range posts {
+		if _, err := primitive.ObjectIDFromHex(post); err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+		}
+		counts[post] = 0
+		ids = append(ids, post)
+	}
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "postId", Value: bson.D{{Key: "$in", Value: ids}}},
+			visible(),
+			alive(),
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$postId"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	var res []struct {
+		PostID string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	err = cursor.All(ctx, &res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	for _, r := range res {
+		counts[r.PostID] = r.Count
+	}
+	return counts, nil
+}
+
+// Pending возвращает очередь комментариев, ожидающих проверки модератором,
+// начиная с самых старых. Возвращает не более limit комментариев.
+func (s *Storage) Pending(ctx context.Context, limit int) ([]storage.Comment, error) {
+	const operation = "storage.mongodb.Pending"
+
+	comments := []storage.Comment{}
+	collection := s.db.Database(dbName).Collection(colName)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pubTime", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(noHistory())
+	filter := bson.D{{Key: "status", Value: storage.StatusPending}}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	err = cursor.All(ctx, &comments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	return comments, nil
+}
+
+// SetStatus устанавливает статус модерации комментария с переданным ID
+// и возвращает обновленный комментарий. Одобрение отмечает жалобы на
+// комментарий проверенными: число жалоб сохраняется в поле reviewed.
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	const operation = "storage.mongodb.SetStatus"
+
+	var com storage.Comment
+
+	if !storage.ValidStatus(status) {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}}
+	set := bson.D{{Key: "status", Value: status}}
+	if status == storage.StatusApproved {
+		set = append(set, bson.E{Key: "reviewed", Value: "$reports"})
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(noHistory())
+
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&com)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// UpdateComment заменяет текст и статус модерации неудаленного комментария
+// с ID из переданной структуры. Пустой статус не изменяет прежний статус.
+// Предыдущий текст вместе со временем его публикации сохраняется в истории
+// изменений. Возвращает обновленный комментарий.
+func (s *Storage) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	const operation = "storage.mongodb.UpdateComment"
+
+	var upd storage.Comment
+
+	if com.Content == "" {
+		return upd, fmt.Errorf("%s: %w", operation, storage.ErrEmptyContent)
+	}
+	if com.Status != "" && !storage.ValidStatus(com.Status) {
+		return upd, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	oid, err := primitive.ObjectIDFromHex(com.ID)
+	if err != nil {
+		return upd, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	// Обновление выполняется одним запросом с конвейером агрегации, чтобы
+	// текущий текст попал в историю атомарно с записью нового. Новый текст
+	// передается через $literal, так как строка, начинающаяся с "$",
+	// иначе будет считаться путем к полю.
+	set := bson.D{
+		{Key: "history", Value: pushHistory()},
+		{Key: "content", Value: bson.D{{Key: "$literal", Value: com.Content}}},
+		{Key: "editedAt", Value: primitive.NewDateTimeFromTime(time.Now())},
+	}
+	if com.Status != "" {
+		set = append(set, bson.E{Key: "status", Value: com.Status})
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}, alive()}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(noHistory())
+
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&upd)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return upd, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return upd, fmt.Errorf("%s: %w", operation, err)
+	}
+	return upd, nil
+}
+
+// History возвращает предыдущие версии текста комментария с переданным ID,
+// начиная с самой старой.
+func (s *Storage) History(ctx context.Context, id string) ([]storage.Revision, error) {
+	const operation = "storage.mongodb.History"
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	var doc struct {
+		History []storage.Revision `bson:"history"`
+	}
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "history", Value: 1}})
+
+	err = collection.FindOne(ctx, filter, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if doc.History == nil {
+		doc.History = []storage.Revision{}
+	}
+	return doc.History, nil
+}
+
+// DeleteComment помечает комментарий с переданным ID как удаленный и убирает
+// его текст. Сам документ остается в БД, чтобы ответы на комментарий
+// не потеряли родителя. Текст сохраняется в истории изменений.
+// Возвращает удаленный комментарий.
+func (s *Storage) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.mongodb.DeleteComment"
+
+	var com storage.Comment
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "history", Value: pushHistory()},
+			{Key: "content", Value: ""},
+			{Key: "deleted", Value: true},
+		}}},
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}, alive()}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(noHistory())
+
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&com)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// React записывает реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Предыдущая реакция пользователя на этот
+// комментарий заменяется. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) React(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	const operation = "storage.mongodb.React"
+
+	if !storage.ValidReaction(reaction) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReaction)
+	}
+
+	com, err := s.setReaction(ctx, id, user, reaction)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Unreact удаляет реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) Unreact(ctx context.Context, id, user string) (storage.Comment, error) {
+	const operation = "storage.mongodb.Unreact"
+
+	com, err := s.setReaction(ctx, id, user, "")
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// setReaction заменяет реакцию пользователя на комментарий и изменяет
+// число реакций в документе комментария. Пустая реакция удаляет
+// предыдущую. Реакция заменяется одним атомарным запросом, который
+// возвращает предыдущую, а счетчики изменяются через $inc на разницу
+// между ними, поэтому одновременные реакции не теряют изменения счетчиков.
+func (s *Storage) setReaction(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	var com storage.Comment
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return com, storage.ErrIncorrectCommentID
+	}
+	if !storage.ValidUserID(user) {
+		return com, storage.ErrIncorrectUserID
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}, alive(), visible()}
+	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(noHistory())).Decode(&com)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return com, storage.ErrCommentNotFound
+		}
+		return com, err
+	}
+
+	prev, err := s.swapReaction(ctx, oid, user, reaction)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+
+	likes, dislikes := storage.ReactionDelta(prev, reaction)
+	if likes == 0 && dislikes == 0 {
+		return com, nil
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{
+		{Key: "likes", Value: likes},
+		{Key: "dislikes", Value: dislikes},
+	}}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(noHistory())
+	err = collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: oid}}, update, opts).Decode(&com)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// swapReaction заменяет реакцию пользователя на комментарий в коллекции
+// реакций и возвращает предыдущую. Пустая реакция удаляет документ.
+func (s *Storage) swapReaction(ctx context.Context, oid primitive.ObjectID, user, reaction string) (string, error) {
+	var doc struct {
+		Reaction string `bson:"reaction"`
+	}
+
+	collection := s.db.Database(dbName).Collection(reactName)
+	filter := bson.D{{Key: "commentId", Value: oid}, {Key: "userId", Value: user}}
+
+	var err error
+	if reaction == "" {
+		err = collection.FindOneAndDelete(ctx, filter).Decode(&doc)
+	} else {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "reaction", Value: reaction}}}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+		err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+		// Два одновременных upsert могут оба не найти документ, тогда
+		// второй получает ошибку уникального индекса. Повторный запрос
+		// найдет документ первого.
+		if mongo.IsDuplicateKeyError(err) {
+			err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+		}
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	return doc.Reaction, nil
+}
+
+// Report записывает жалобу пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Повторная жалоба пользователя на тот же
+// комментарий не принимается. Когда число жалоб достигает порога
+// threshold, комментарий отправляется на проверку модератору. Возвращает
+// комментарий с обновленным числом жалоб и статусом.
+func (s *Storage) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	const operation = "storage.mongodb.Report"
+
+	var com storage.Comment
+
+	if !storage.ValidReason(reason) {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReason)
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+	if !storage.ValidUserID(user) {
+		return com, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{{Key: "_id", Value: oid}, alive(), visible()}
+	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	// Повторную жалобу отклоняет уникальный индекс.
+	_, err = s.db.Database(dbName).Collection(reportName).InsertOne(ctx, bson.D{
+		{Key: "commentId", Value: oid},
+		{Key: "userId", Value: user},
+		{Key: "reason", Value: reason},
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrAlreadyReported)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "reports", Value: 1}}}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(noHistory())
+	err = collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: oid}}, update, opts).Decode(&com)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	// Счетчик увеличивается атомарно, поэтому значение, равное порогу,
+	// получает только один запрос.
+	if storage.Hide(com.Status, com.Reports, threshold) {
+		filter := bson.D{{Key: "_id", Value: oid}, visible()}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: storage.StatusPending}}}}
+		err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&com)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return com, fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+	return com, nil
+}
+
+// Reported возвращает неудаленные и не отклоненные комментарии с жалобами,
+// поступившими после одобрения модератором, начиная с комментариев
+// с наибольшим числом жалоб. При равном числе жалоб сначала идут новые.
+// Возвращает не более limit комментариев.
+func (s *Storage) Reported(ctx context.Context, limit int) ([]storage.Reported, error) {
+	const operation = "storage.mongodb.Reported"
+
+	collection := s.db.Database(dbName).Collection(colName)
+	filter := bson.D{
+		{Key: "reports", Value: bson.D{{Key: "$gt", Value: 0}}},
+		{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{
+			"$reports",
+			bson.D{{Key: "$ifNull", Value: bson.A{"$reviewed", 0}}},
+		}}}},
+		alive(),
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: storage.StatusRejected}}},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "reports", Value: -1}, {Key: "pubTime", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetProjection(noHistory())
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	var comments []storage.Comment
+	err = cur.All(ctx, &comments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	list := make([]storage.Reported, 0, len(comments))
+	if len(comments) == 0 {
+		return list, nil
+	}
+	byID := make(map[string]map[string]int, len(comments))
+	oids := make(bson.A, 0, len(comments))
+	for _, com := range comments {
+		byID[com.ID] = make(map[string]int)
+		oid, _ := primitive.ObjectIDFromHex(com.ID)
+		oids = append(oids, oid)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "commentId", Value: bson.D{{Key: "$in", Value: oids}}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "commentId", Value: "$commentId"}, {Key: "reason", Value: "$reason"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+	cur, err = s.db.Database(dbName).Collection(reportName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	var groups []struct {
+		ID struct {
+			CommentID primitive.ObjectID `bson:"commentId"`
+			Reason    string             `bson:"reason"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	err = cur.All(ctx, &groups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	for _, g := range groups {
+		if reasons, ok := byID[g.ID.CommentID.Hex()]; ok {
+			reasons[g.ID.Reason] = g.Count
+		}
+	}
+
+	for _, com := range comments {
+		list = append(list, storage.Reported{Comment: com, Reasons: byID[com.ID]})
+	}
+	return list, nil
+}
+
+// Watch читает поток изменений коллекции и передает в publish события
+// об изменении видимых читателям комментариев. Блокируется до отмены
+// контекста или ошибки. Поток изменений доступен только в наборе реплик,
+// зато события получают все экземпляры сервиса. Повторный вызов продолжает
+// поток с места остановки.
+func (s *Storage) Watch(ctx context.Context, publish func(storage.Event)) error {
+	const operation = "storage.mongodb.Watch"
+
+	collection := s.db.Database(dbName).Collection(colName)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update"}}}},
+		}}},
+		{{Key: "$project", Value: bson.D{{Key: "fullDocument.history", Value: 0}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if s.resume != nil {
+		opts.SetResumeAfter(s.resume)
+	}
+
+	stream, err := collection.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change struct {
+			OperationType     string          `bson:"operationType"`
+			FullDocument      storage.Comment `bson:"fullDocument"`
+			UpdateDescription struct {
+				UpdatedFields bson.M `bson:"updatedFields"`
+			} `bson:"updateDescription"`
+		}
+		err = stream.Decode(&change)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		ev, ok := toEvent(change.OperationType, change.UpdateDescription.UpdatedFields, change.FullDocument)
+		if ok {
+			publish(ev)
+		}
+		s.resume = stream.ResumeToken()
+	}
+
+	if err = stream.Err(); err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return ctx.Err()
+}
+
+// Take забирает токен из корзины key для ограничения частоты запросов.
+// Корзина наполняется со скоростью rate токенов в секунду и вмещает не
+// более burst токенов. Корзина обновляется одним запросом по часам сервера
+// БД, поэтому ее могут использовать несколько экземпляров сервиса. Если
+// токен получен, то возвращает 0, иначе - время до появления токена.
+func (s *Storage) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	const operation = "storage.mongodb.Take"
+
+	// Прошедшее с прошлого запроса время в секундах.
+	elapsed := bson.D{{Key: "$divide", Value: bson.A{
+		bson.D{{Key: "$max", Value: bson.A{0, bson.D{{Key: "$subtract", Value: bson.A{
+			"$$NOW", bson.D{{Key: "$ifNull", Value: bson.A{"$last", "$$NOW"}}},
+		}}}}}},
+		1000,
+	}}}
+	refill := bson.D{{Key: "$min", Value: bson.A{
+		burst,
+		bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$tokens", burst}}},
+			bson.D{{Key: "$multiply", Value: bson.A{elapsed, rate}}},
+		}}},
+	}}}
+	enough := bson.D{{Key: "$gte", Value: bson.A{"$tokens", 1}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "tokens", Value: refill}, {Key: "last", Value: "$$NOW"}}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "allowed", Value: enough},
+			{Key: "tokens", Value: bson.D{{Key: "$cond", Value: bson.A{
+				enough, bson.D{{Key: "$subtract", Value: bson.A{"$tokens", 1}}}, "$tokens",
+			}}}},
+		}}},
+		{{Key: "$set", Value: bson.D{{Key: "expireAt", Value: bson.D{{Key: "$add", Value: bson.A{
+			"$$NOW",
+			bson.D{{Key: "$multiply", Value: bson.A{
+				bson.D{{Key: "$divide", Value: bson.A{bson.D{{Key: "$subtract", Value: bson.A{burst, "$tokens"}}}, rate}}},
+				1000,
+			}}},
+		}}}}}}},
+	}
+
+	collection := s.db.Database(dbName).Collection(limitName)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var b struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: key}}, pipeline, opts).Decode(&b)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", operation, err)
+	}
+	if b.Allowed {
+		return 0, nil
+	}
+	return time.Duration(math.Ceil((1 - b.Tokens) / rate * float64(time.Second))), nil
+}
+
+// ReserveKey записывает ключ идемпотентности запроса, который начал
+// выполняться, на время ttl. Если ключ уже есть, то возвращает его запись
+// и false. TTL индекс удаляет документы с задержкой, поэтому ключ
+// с истекшим временем хранения удаляется перед записью.
+func (s *Storage) ReserveKey(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) (idempotency.Record, bool, error) {
+	const operation = "storage.mongodb.ReserveKey"
+
+	collection := s.db.Database(dbName).Collection(keyName)
+	now := time.Now()
+	_, err := collection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: key},
+		{Key: "expireAt", Value: bson.D{{Key: "$lte", Value: now}}},
+	})
+	if err != nil {
+		return idempotency.Record{}, false, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	_, err = collection.InsertOne(ctx, keyDoc(key, rec, now.Add(ttl)))
+	if err == nil {
+		return rec, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return idempotency.Record{}, false, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	var got idempotency.Record
+	err = collection.FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&got)
+	if err != nil {
+		return idempotency.Record{}, false, fmt.Errorf("%s: %w", operation, err)
+	}
+	return got, false, nil
+}
+
+// SaveKey записывает результат выполненного запроса с ключом
+// идемпотентности на время ttl.
+func (s *Storage) SaveKey(ctx context.Context, key string, rec idempotency.Record, ttl time.Duration) error {
+	const operation = "storage.mongodb.SaveKey"
+
+	collection := s.db.Database(dbName).Collection(keyName)
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: key}}, keyDoc(key, rec, time.Now().Add(ttl)), opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+// ReleaseKey удаляет ключ идемпотентности запроса, который не удалось
+// выполнить.
+func (s *Storage) ReleaseKey(ctx context.Context, key string) error {
+	const operation = "storage.mongodb.ReleaseKey"
+
+	collection := s.db.Database(dbName).Collection(keyName)
+	_, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+// keyDoc возвращает документ ключа идемпотентности.
+func keyDoc(key string, rec idempotency.Record, expire time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: key},
+		{Key: "hash", Value: rec.Hash},
+		{Key: "status", Value: rec.Status},
+		{Key: "id", Value: rec.ID},
+		{Key: "expireAt", Value: expire},
+	}
+}
+
+// toEvent определяет событие по изменению документа комментария так же,
+// как обертка events.Storage по вызову метода хранилища. Если изменение
+// не видно читателям, то возвращает false. Текст скрытого комментария
+// в событие не попадает.
+func toEvent(op string, fields bson.M, com storage.Comment) (storage.Event, bool) {
+	shown := com.Status != storage.StatusPending && com.Status != storage.StatusRejected
+	deleted := func() (storage.Event, bool) {
+		return storage.Event{Type: storage.EventDeleted, Comment: storage.Strip(com)}, true
+	}
+
+	if op == "insert" {
+		return storage.Event{Type: storage.EventNew, Comment: com}, shown
+	}
+	if _, ok := fields["deleted"]; ok {
+		return deleted()
+	}
+	if _, ok := fields["content"]; ok {
+		if !shown {
+			return deleted()
+		}
+		return storage.Event{Type: storage.EventEdited, Comment: com}, true
+	}
+	if _, ok := fields["status"]; ok {
+		switch com.Status {
+		case storage.StatusApproved:
+			return storage.Event{Type: storage.EventNew, Comment: com}, true
+		case storage.StatusPending, storage.StatusRejected:
+			return deleted()
+		}
+	}
+	return storage.Event{}, false
+}
@@ -3,7 +3,9 @@
 package mongodb
 
 import (
+	"GoExamComments/internal/idempotency"
 	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/storagetest"
 	"context"
 	"fmt"
 	"math/rand"
@@ -14,9 +16,20 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var path string = "mongodb://192.168.0.102:27017/"
+// testOpts возвращает опции подключения к тестовой БД, адрес которой
+// задан в переменной окружения MONGO_TEST_URI, например
+// "mongodb://192.168.0.102:27017/". Если переменная не задана, то тест
+// пропускается.
+func testOpts(t *testing.T) *options.ClientOptions {
+	path := os.Getenv("MONGO_TEST_URI")
+	if path == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	return setOpts(path, "admin", os.Getenv("MONGO_DB_PASSWD"))
+}
 
 // addOne добавляет один комментарий в БД и возвращает его ObjectID
 // в виде строки. Функция для использования в тестах.
@@ -26,9 +39,8 @@ func (s *Storage) addOne(com storage.Comment) (string, error) {
 		{Key: "parentId", Value: com.ParentID},
 		{Key: "postId", Value: com.PostID},
 		{Key: "pubTime", Value: primitive.NewDateTimeFromTime(time.Now())},
-		{Key: "allowed", Value: true},
 		{Key: "content", Value: com.Content},
-		{Key: "childs", Value: bson.A{}},
+		{Key: "status", Value: com.Status},
 	}
 	collection := s.db.Database(dbName).Collection(colName)
 	res, err := collection.InsertOne(context.Background(), bsn)
@@ -42,9 +54,9 @@ func (s *Storage) addOne(com storage.Comment) (string, error) {
 func Test_new(t *testing.T) {
 
 	// Для тестирования авторизации.
-	opts := setOpts(path, "admin", os.Getenv("MONGO_DB_PASSWD"))
+	opts := testOpts(t)
 
-	// opts := setTestOpts(path)
+	// opts := setTestOpts(os.Getenv("MONGO_TEST_URI"))
 	st, err := new(opts)
 	if err != nil {
 		t.Fatal(err.Error())
@@ -56,7 +68,7 @@ func TestStorage_AddComment(t *testing.T) {
 	dbName = "testDB"
 	colName = "testComments"
 
-	opts := setOpts(path, "admin", os.Getenv("MONGO_DB_PASSWD"))
+	opts := testOpts(t)
 	st, err := new(opts)
 	if err != nil {
 		t.Fatal(err.Error())
@@ -129,7 +141,7 @@ func TestStorage_Comments(t *testing.T) {
 	dbName = "testDB"
 	colName = "testComments"
 
-	opts := setOpts(path, "admin", os.Getenv("MONGO_DB_PASSWD"))
+	opts := testOpts(t)
 	st, err := new(opts)
 	if err != nil {
 		t.Fatal(err.Error())
@@ -184,3 +196,643 @@ func TestStorage_Comments(t *testing.T) {
 		})
 	}
 }
+
+func TestStorage_SetStatus(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	post := primitive.NewObjectID().Hex()
+	id, err := st.addOne(storage.Comment{PostID: post, Content: "Pending comment", Status: storage.StatusPending})
+	if err != nil {
+		t.Fatalf("addOne error = %v", err)
+	}
+
+	if _, err := st.Comments(context.Background(), post); err == nil {
+		t.Fatalf("Storage.Comments() returns pending comment")
+	}
+
+	pending, err := st.Pending(context.Background(), 500)
+	if err != nil {
+		t.Fatalf("Storage.Pending() error = %v", err)
+	}
+	if len(pending) == 0 {
+		t.Fatalf("Storage.Pending() error = empty queue")
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		status  string
+		wantErr bool
+	}{
+		{
+			name:    "Approve_OK",
+			id:      id,
+			status:  storage.StatusApproved,
+			wantErr: false,
+		},
+		{
+			name:    "Incorrect_Status",
+			id:      id,
+			status:  "unknown",
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect_ID",
+			id:      "asdf",
+			status:  storage.StatusApproved,
+			wantErr: true,
+		},
+		{
+			name:    "Not_Found",
+			id:      "66e1a6b974aa2008e3b88e53",
+			status:  storage.StatusApproved,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.SetStatus(context.Background(), tt.id, tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.SetStatus() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Status != tt.status {
+				t.Errorf("Storage.SetStatus() status = %s, want %s", got.Status, tt.status)
+			}
+		})
+	}
+
+	comms, err := st.Comments(context.Background(), post)
+	if err != nil {
+		t.Fatalf("Storage.Comments() error = %v", err)
+	}
+	if len(comms) != 1 {
+		t.Errorf("Storage.Comments() len = %d, want %d", len(comms), 1)
+	}
+}
+
+func TestStorage_UpdateComment(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	post := primitive.NewObjectID().Hex()
+	id, err := st.AddComment(context.Background(), storage.Comment{PostID: post, Content: "First version"})
+	if err != nil {
+		t.Fatalf("Storage.AddComment() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		comment storage.Comment
+		wantErr bool
+	}{
+		{
+			name:    "Update_OK",
+			comment: storage.Comment{ID: id, Content: "Second version"},
+			wantErr: false,
+		},
+		{
+			name:    "Update_Dollar_Content",
+			comment: storage.Comment{ID: id, Content: "$content"},
+			wantErr: false,
+		},
+		{
+			name:    "Empty_Content",
+			comment: storage.Comment{ID: id},
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect_ID",
+			comment: storage.Comment{ID: "asdf", Content: "Text"},
+			wantErr: true,
+		},
+		{
+			name:    "Not_Found",
+			comment: storage.Comment{ID: "66e1a6b974aa2008e3b88e53", Content: "Text"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.UpdateComment(context.Background(), tt.comment)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.UpdateComment() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Content != tt.comment.Content || got.EditedAt == nil {
+				t.Errorf("Storage.UpdateComment() = %+v, want content %s and editedAt", got, tt.comment.Content)
+			}
+		})
+	}
+
+	revs, err := st.History(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Storage.History() error = %v", err)
+	}
+	if len(revs) != 2 || revs[0].Content != "First version" {
+		t.Errorf("Storage.History() = %+v, want 2 revisions", revs)
+	}
+}
+
+func TestStorage_DeleteComment(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	post := primitive.NewObjectID().Hex()
+	parent, err := st.AddComment(context.Background(), storage.Comment{PostID: post, Content: "Parent"})
+	if err != nil {
+		t.Fatalf("Storage.AddComment() error = %v", err)
+	}
+	_, err = st.AddComment(context.Background(), storage.Comment{ParentID: parent, PostID: post, Content: "Reply"})
+	if err != nil {
+		t.Fatalf("Storage.AddComment() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{
+			name:    "Delete_OK",
+			id:      parent,
+			wantErr: false,
+		},
+		{
+			name:    "Already_Deleted",
+			id:      parent,
+			wantErr: true,
+		},
+		{
+			name:    "Incorrect_ID",
+			id:      "asdf",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.DeleteComment(context.Background(), tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.DeleteComment() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && (!got.Deleted || got.Content != "") {
+				t.Errorf("Storage.DeleteComment() = %+v, want tombstone", got)
+			}
+		})
+	}
+
+	// Удаленный комментарий остается в выдаче вместе с ответом на него.
+	comms, err := st.Comments(context.Background(), post)
+	if err != nil {
+		t.Fatalf("Storage.Comments() error = %v", err)
+	}
+	if len(comms) != 2 {
+		t.Errorf("Storage.Comments() len = %d, want %d", len(comms), 2)
+	}
+
+	_, err = st.AddComment(context.Background(), storage.Comment{ParentID: parent, PostID: post, Content: "Reply"})
+	if err == nil {
+		t.Errorf("Storage.AddComment() reply to deleted comment error = nil")
+	}
+}
+
+func TestStorage_Threads(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	// Три корневых комментария, на каждый по ответу и ответу на ответ.
+	post := primitive.NewObjectID().Hex()
+	for i := 1; i <= 3; i++ {
+		root, err := st.AddComment(context.Background(), storage.Comment{PostID: post, Content: fmt.Sprintf("Root %d", i)})
+		if err != nil {
+			t.Fatalf("Storage.AddComment() error = %v", err)
+		}
+		reply, err := st.AddComment(context.Background(), storage.Comment{ParentID: root, PostID: post, Content: "Reply"})
+		if err != nil {
+			t.Fatalf("Storage.AddComment() error = %v", err)
+		}
+		_, err = st.AddComment(context.Background(), storage.Comment{ParentID: reply, PostID: post, Content: "Reply to reply"})
+		if err != nil {
+			t.Fatalf("Storage.AddComment() error = %v", err)
+		}
+	}
+
+	var total int
+	var pages int
+	var cursor string
+	for {
+		page, err := st.Threads(context.Background(), post, 2, cursor, false)
+		if err != nil {
+			t.Fatalf("Storage.Threads() error = %v", err)
+		}
+		total += len(page.Comments)
+		pages++
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if total != 9 || pages != 2 {
+		t.Errorf("Storage.Threads() total = %d, pages = %d, want %d, %d", total, pages, 9, 2)
+	}
+
+	if _, err := st.Threads(context.Background(), post, 2, "asdf", false); err == nil {
+		t.Errorf("Storage.Threads() incorrect cursor error = nil")
+	}
+}
+
+func TestStorage_Counts(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	post := primitive.NewObjectID().Hex()
+	empty := primitive.NewObjectID().Hex()
+	for i := 1; i <= 3; i++ {
+		_, err := st.AddComment(context.Background(), storage.Comment{PostID: post, Content: fmt.Sprintf("Comment %d", i)})
+		if err != nil {
+			t.Fatalf("Storage.AddComment() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		posts   []string
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name:    "Counts_OK",
+			posts:   []string{post, empty},
+			want:    map[string]int{post: 3, empty: 0},
+			wantErr: false,
+		},
+		{
+			name:    "Empty_List",
+			posts:   nil,
+			want:    map[string]int{},
+			wantErr: false,
+		},
+		{
+			name:    "Incorrect_Post_ID",
+			posts:   []string{post, "asdf"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.Counts(context.Background(), tt.posts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.Counts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Storage.Counts() = %v, want %v", got, tt.want)
+				return
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("Storage.Counts() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStorage_Subtree(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	// Цепочка из корневого комментария и трех вложенных ответов.
+	post := primitive.NewObjectID().Hex()
+	var ids []string
+	var parent string
+	for i := 0; i < 4; i++ {
+		id, err := st.AddComment(context.Background(), storage.Comment{ParentID: parent, PostID: post, Content: fmt.Sprintf("Level %d", i)})
+		if err != nil {
+			t.Fatalf("Storage.AddComment() error = %v", err)
+		}
+		ids = append(ids, id)
+		parent = id
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		depth   int
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "Comment_Only",
+			id:      ids[1],
+			depth:   0,
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "Depth_1",
+			id:      ids[1],
+			depth:   1,
+			want:    2,
+			wantErr: false,
+		},
+		{
+			name:    "Depth_All",
+			id:      ids[0],
+			depth:   10,
+			want:    4,
+			wantErr: false,
+		},
+		{
+			name:    "Incorrect_ID",
+			id:      "asdf",
+			depth:   0,
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "Not_Found",
+			id:      "66e1a6b974aa2008e3b88e53",
+			depth:   0,
+			want:    0,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.Subtree(context.Background(), tt.id, tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Storage.Subtree() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.want {
+				t.Errorf("Storage.Subtree() len = %d, want %d", len(got), tt.want)
+				return
+			}
+			if !tt.wantErr && got[0].ID != tt.id {
+				t.Errorf("Storage.Subtree() first id = %s, want %s", got[0].ID, tt.id)
+			}
+		})
+	}
+}
+
+func TestStorage_Backfill(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Цепочка комментариев без массива предков, как до его появления.
+	post := primitive.NewObjectID().Hex()
+	var ids []string
+	var parent string
+	for i := 0; i < 3; i++ {
+		id, err := st.addOne(storage.Comment{ParentID: parent, PostID: post, Content: fmt.Sprintf("Level %d", i)})
+		if err != nil {
+			t.Fatalf("addOne error = %v", err)
+		}
+		ids = append(ids, id)
+		parent = id
+	}
+	st.Close()
+
+	// Массив предков заполняется при подключении.
+	st, err = new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	subtree, err := st.Subtree(context.Background(), ids[0], 10)
+	if err != nil {
+		t.Fatalf("Storage.Subtree() error = %v", err)
+	}
+	if len(subtree) != 3 {
+		t.Errorf("Storage.Subtree() len = %d, want %d", len(subtree), 3)
+	}
+	page, err := st.Threads(context.Background(), post, 10, "", false)
+	if err != nil {
+		t.Fatalf("Storage.Threads() error = %v", err)
+	}
+	if len(page.Comments) != 3 {
+		t.Errorf("Storage.Threads() len = %d, want %d", len(page.Comments), 3)
+	}
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	dbName = "testDB"
+	colName = "testConformance"
+	opts := testOpts(t)
+
+	storagetest.Run(t, func(t *testing.T) storage.DB {
+		st, err := new(opts)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		collection := st.db.Database(dbName).Collection(colName)
+		_, err = collection.DeleteMany(context.Background(), bson.D{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	})
+}
+
+func TestStorage_Watch(t *testing.T) {
+	dbName = "testDB"
+	colName = "testComments"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := make(chan storage.Event, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- st.Watch(ctx, func(ev storage.Event) { events <- ev })
+	}()
+
+	// Даем время открыть поток изменений.
+	time.Sleep(500 * time.Millisecond)
+	post := primitive.NewObjectID().Hex()
+	st.AddComment(ctx, storage.Comment{PostID: post, Content: "hidden", Status: storage.StatusPending})
+	id, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "text"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Type != storage.EventNew || ev.Comment.ID != id {
+			t.Errorf("Storage.Watch() event = %+v, want new comment %s", ev, id)
+		}
+	case err := <-errs:
+		// Поток изменений недоступен без набора реплик.
+		t.Skipf("change stream is not available: %v", err)
+	case <-ctx.Done():
+		t.Error("Storage.Watch() no event")
+	}
+}
+
+func Test_toEvent(t *testing.T) {
+	com := storage.Comment{ID: "1", Content: "text", Status: storage.StatusApproved}
+	pending := storage.Comment{ID: "1", Content: "text", Status: storage.StatusPending}
+
+	tests := []struct {
+		name    string
+		op      string
+		fields  bson.M
+		com     storage.Comment
+		want    string
+		content string
+		ok      bool
+	}{
+		{name: "Insert", op: "insert", com: com, want: storage.EventNew, content: "text", ok: true},
+		{name: "Insert_Pending", op: "insert", com: pending, ok: false},
+		{name: "Edit", op: "update", fields: bson.M{"content": "text"}, com: com, want: storage.EventEdited, content: "text", ok: true},
+		{name: "Edit_Flagged", op: "update", fields: bson.M{"content": "text"}, com: pending, want: storage.EventDeleted, ok: true},
+		{name: "Delete", op: "update", fields: bson.M{"deleted": true, "content": ""}, com: com, want: storage.EventDeleted, ok: true},
+		{name: "Approve", op: "update", fields: bson.M{"status": storage.StatusApproved}, com: com, want: storage.EventNew, content: "text", ok: true},
+		{name: "Hide", op: "update", fields: bson.M{"status": storage.StatusPending, "reports": 3}, com: pending, want: storage.EventDeleted, ok: true},
+		{name: "Reaction", op: "update", fields: bson.M{"likes": 1}, com: com, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := toEvent(tt.op, tt.fields, tt.com)
+			if ok != tt.ok {
+				t.Fatalf("toEvent() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (got.Type != tt.want || got.Comment.Content != tt.content) {
+				t.Errorf("toEvent() = %+v, want %s with content %q", got, tt.want, tt.content)
+			}
+		})
+	}
+}
+
+func TestStorage_Take(t *testing.T) {
+	dbName = "testDB"
+	limitName = "testLimits"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	key := "ip:" + strconv.Itoa(rand.Int())
+
+	// Корзина из двух токенов, которая наполняется раз в 100 секунд.
+	for i := 0; i < 2; i++ {
+		wait, err := st.Take(ctx, key, 0.01, 2)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if wait != 0 {
+			t.Fatalf("Storage.Take() request %d wait = %v, want 0", i, wait)
+		}
+	}
+	wait, err := st.Take(ctx, key, 0.01, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if wait <= 0 || wait > 100*time.Second {
+		t.Errorf("Storage.Take() wait = %v, want up to 100s", wait)
+	}
+}
+
+func TestStorage_ReserveKey(t *testing.T) {
+	dbName = "testDB"
+	keyName = "testKeys"
+
+	opts := testOpts(t)
+	st, err := new(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	key := strconv.Itoa(rand.Int())
+
+	_, fresh, err := st.ReserveKey(ctx, key, idempotency.Record{Hash: "h1"}, time.Minute)
+	if err != nil || !fresh {
+		t.Fatalf("Storage.ReserveKey() fresh = %v, error = %v, want new key", fresh, err)
+	}
+	err = st.SaveKey(ctx, key, idempotency.Record{Hash: "h1", Status: 201, ID: "id1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rec, fresh, err := st.ReserveKey(ctx, key, idempotency.Record{Hash: "h2"}, time.Minute)
+	if err != nil || fresh || rec != (idempotency.Record{Hash: "h1", Status: 201, ID: "id1"}) {
+		t.Fatalf("Storage.ReserveKey() = %+v, %v, %v, want saved result", rec, fresh, err)
+	}
+
+	err = st.ReleaseKey(ctx, key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, fresh, _ := st.ReserveKey(ctx, key, idempotency.Record{Hash: "h2"}, time.Minute); !fresh {
+		t.Error("Storage.ReserveKey() released key fresh = false, want true")
+	}
+}
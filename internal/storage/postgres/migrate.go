@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations - SQL файлы миграций схемы БД. Имя файла начинается с номера
+// версии, миграции применяются по возрастанию номера.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock - ключ advisory lock, чтобы несколько экземпляров сервиса
+// не применяли миграции одновременно.
+const migrationLock = 7243001
+
+// migration - одна миграция схемы БД.
+type migration struct {
+	version int
+	name    string
+}
+
+// migrate применяет к БД все еще не примененные миграции в одной
+// транзакции. Номера примененных миграций хранятся в таблице
+// schema_migrations.
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	const operation = "storage.postgres.migrate"
+
+	list, err := listMigrations()
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	_, err = tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+
+	for _, m := range list {
+		var applied bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		if applied {
+			continue
+		}
+
+		sql, err := migrations.ReadFile("migrations/" + m.name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		_, err = tx.Exec(ctx, string(sql))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", operation, m.name, err)
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+// listMigrations возвращает список файлов миграций, отсортированный
+// по номеру версии.
+func listMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect migration name: %s", e.Name())
+		}
+		list = append(list, migration{version: version, name: e.Name()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+
+	return list, nil
+}
@@ -0,0 +1,11 @@
+-- Число реакций читателей на комментарий.
+ALTER TABLE comments ADD COLUMN likes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN dislikes INTEGER NOT NULL DEFAULT 0;
+
+-- Реакции читателей, не более одной от пользователя на комментарий.
+CREATE TABLE comment_reactions (
+	comment_id TEXT NOT NULL REFERENCES comments (id),
+	user_id    TEXT NOT NULL,
+	reaction   TEXT NOT NULL,
+	PRIMARY KEY (comment_id, user_id)
+);
@@ -0,0 +1,13 @@
+-- Число жалоб читателей на комментарий.
+ALTER TABLE comments ADD COLUMN reports INTEGER NOT NULL DEFAULT 0;
+
+-- Список комментариев с жалобами для модераторов.
+CREATE INDEX comments_reports_idx ON comments (reports DESC, pub_time DESC, id DESC) WHERE reports > 0;
+
+-- Жалобы читателей, не более одной от пользователя на комментарий.
+CREATE TABLE comment_reports (
+	comment_id TEXT NOT NULL REFERENCES comments (id),
+	user_id    TEXT NOT NULL,
+	reason     TEXT NOT NULL,
+	PRIMARY KEY (comment_id, user_id)
+);
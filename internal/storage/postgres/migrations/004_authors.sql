@@ -0,0 +1,6 @@
+-- Автор комментария, у анонимных комментариев - пустые строки.
+ALTER TABLE comments ADD COLUMN author_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN author_name TEXT NOT NULL DEFAULT '';
+
+-- Постраничная выдача комментариев автора.
+CREATE INDEX comments_author_idx ON comments (author_id, pub_time DESC, id DESC) WHERE author_id <> '';
@@ -0,0 +1,3 @@
+-- Число жалоб на комментарий на момент его одобрения модератором. Список
+-- комментариев с жалобами показывает только комментарии с новыми жалобами.
+ALTER TABLE comments ADD COLUMN reviewed INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,872 @@
+// Пакет для работы с базой данных PostgreSQL в сервисе комментариев.
+package postgres
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// tmConn - таймаут на создание пула подключений и применение миграций.
+const tmConn time.Duration = time.Second * 20
+
+// columns - столбцы таблицы comments в порядке полей storage.Comment.
+const columns = "id, parent_id, post_id, author_id, author_name, pub_time, edited_at, content, status, deleted, likes, dislikes, reports"
+
+// visible - условие для комментариев, которые видны читателям.
+const visible = "status NOT IN ('" + storage.StatusPending + "', '" + storage.StatusRejected + "')"
+
+// Storage - пул подключений к БД.
+type Storage struct {
+	db *pgxpool.Pool
+}
+
+// New - обертка для конструктора пула подключений new.
+func New(cfg *config.Config) *Storage {
+	poolCfg, err := pgxpool.ParseConfig(cfg.StoragePath)
+	if err != nil {
+		log.Fatalf("failed to init storage: %s", err.Error())
+	}
+	if cfg.StorageUser != "" {
+		poolCfg.ConnConfig.User = cfg.StorageUser
+	}
+	if cfg.StoragePasswd != "" {
+		poolCfg.ConnConfig.Password = cfg.StoragePasswd
+	}
+
+	storage, err := new(poolCfg)
+	if err != nil {
+		log.Fatalf("failed to init storage: %s", err.Error())
+	}
+	return storage
+}
+
+// new - конструктор пула подключений к БД. Применяет к БД миграции схемы.
+func new(poolCfg *pgxpool.Config) (*Storage, error) {
+	const operation = "storage.postgres.new"
+
+	tm, cancel := context.WithTimeout(context.Background(), tmConn)
+	defer cancel()
+
+	db, err := pgxpool.NewWithConfig(tm, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	err = db.Ping(tm)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	err = migrate(tm, db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// Close - обертка для закрытия пула подключений.
+func (s *Storage) Close() error {
+	s.db.Close()
+	return nil
+}
+
+// scanComment читает комментарий из строки результата запроса, столбцы
+// которой перечислены в columns.
+func scanComment(row pgx.Row) (storage.Comment, error) {
+	var com storage.Comment
+	err := row.Scan(
+		&com.ID,
+		&com.ParentID,
+		&com.PostID,
+		&com.AuthorID,
+		&com.AuthorName,
+		&com.PubTime,
+		&com.EditedAt,
+		&com.Content,
+		&com.Status,
+		&com.Deleted,
+		&com.Likes,
+		&com.Dislikes,
+		&com.Reports,
+	)
+	if err != nil {
+		return com, err
+	}
+	com.PubTime = com.PubTime.UTC()
+	if com.EditedAt != nil {
+		t := com.EditedAt.UTC()
+		com.EditedAt = &t
+	}
+	return com, nil
+}
+
+// collect читает все комментарии из результата запроса.
+func collect(rows pgx.Rows) ([]storage.Comment, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (storage.Comment, error) {
+		return scanComment(row)
+	})
+}
+
+// now возвращает текущее время с точностью до миллисекунды, как в MongoDB.
+// Курсоры страниц тоже хранят время с этой точностью.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// AddComment записывает переданный комментарий в БД.
+func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string, error) {
+	const operation = "storage.postgres.AddComment"
+
+	if !storage.ValidID(com.PostID) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+	if !storage.ValidAuthor(com.AuthorID, com.AuthorName) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectAuthor)
+	}
+
+	// Проверим, что родительский комментарий существует и виден читателям,
+	// чтобы избежать вставки комментария с некорректной связью.
+	if com.ParentID != "" {
+		if !storage.ValidID(com.ParentID) {
+			return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectParentID)
+		}
+		var exists bool
+		err := s.db.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND NOT deleted AND "+visible+")",
+			com.ParentID,
+		).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", operation, err)
+		}
+		if !exists {
+			return "", fmt.Errorf("%s: %w", operation, storage.ErrParentNotFound)
+		}
+	}
+
+	if com.Status == "" {
+		com.Status = storage.StatusApproved
+	}
+
+	id := storage.NewID()
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO comments (id, parent_id, post_id, author_id, author_name, pub_time, content, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id, com.ParentID, com.PostID, com.AuthorID, com.AuthorName, now(), com.Content, com.Status,
+	)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return id, nil
+}
+
+// Comments возвращает все видимые читателям комментарии по переданному
+// ID поста, отсортированные по дате создания. Комментарии выбираются
+// рекурсивным запросом от корневых комментариев поста. Удаленные
+// комментарии тоже возвращаются, чтобы сохранить связи в дереве.
+func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment, error) {
+	const operation = "storage.postgres.Comments"
+
+	if !storage.ValidID(post) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	// Обход идет только по видимым комментариям: ответы на скрытый
+	// комментарий скрыты вместе с ним.
+	rows, err := s.db.Query(ctx, `WITH RECURSIVE thread AS (
+			SELECT `+columns+` FROM comments WHERE post_id = $1 AND parent_id = '' AND `+visible+`
+			UNION ALL
+			SELECT c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports
+			FROM comments c JOIN thread t ON c.parent_id = t.id
+			WHERE c.`+visible+`
+		)
+		SELECT `+columns+` FROM thread ORDER BY pub_time DESC, id DESC`,
+		post,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(comments) == 0 {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+	}
+	return comments, nil
+}
+
+// Threads возвращает страницу корневых комментариев к посту вместе со всеми
+// ответами на них. Корневые комментарии отсортированы по убыванию даты
+// создания, а при oldest - по возрастанию, на странице не более limit
+// корневых комментариев. Ответы выбираются рекурсивным запросом от
+// корневых комментариев страницы.
+func (s *Storage) Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (storage.Page, error) {
+	const operation = "storage.postgres.Threads"
+
+	var page storage.Page
+
+	if !storage.ValidID(post) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	sign, dir := "<", "DESC"
+	if oldest {
+		sign, dir = ">", "ASC"
+	}
+
+	query := "SELECT " + columns + " FROM comments WHERE post_id = $1 AND parent_id = '' AND " + visible
+	args := []any{post}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !storage.ValidID(id) {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		query += " AND (pub_time, id) " + sign + " ($2, $3)"
+		args = append(args, tm, id)
+	}
+	// Запрашиваем на один корневой комментарий больше, чтобы узнать,
+	// есть ли следующая страница.
+	query += fmt.Sprintf(" ORDER BY pub_time %s, id %s LIMIT %d", dir, dir, limit+1)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	roots, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(roots) == 0 {
+		if cursor == "" {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+		}
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		last := roots[len(roots)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+
+	ids := make([]string, 0, len(roots))
+	for _, root := range roots {
+		ids = append(ids, root.ID)
+	}
+
+	// Обход идет только по видимым ответам: ответы на скрытый
+	// комментарий скрыты вместе с ним.
+	rows, err = s.db.Query(ctx, `WITH RECURSIVE replies AS (
+			SELECT `+columns+` FROM comments WHERE parent_id = ANY($1) AND `+visible+`
+			UNION ALL
+			SELECT c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports
+			FROM comments c JOIN replies r ON c.parent_id = r.id
+			WHERE c.`+visible+`
+		)
+		SELECT `+columns+` FROM replies ORDER BY pub_time DESC, id DESC`,
+		ids,
+	)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	replies, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	page.Comments = append(roots, replies...)
+	return page, nil
+}
+
+// AuthorComments возвращает страницу видимых читателям неудаленных
+// комментариев автора к любым постам. Комментарии отсортированы
+// по убыванию даты создания, на странице не более limit комментариев.
+func (s *Storage) AuthorComments(ctx context.Context, author string, limit int, cursor string) (storage.Page, error) {
+	const operation = "storage.postgres.AuthorComments"
+
+	var page storage.Page
+
+	if !storage.ValidUserID(author) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	query := "SELECT " + columns + " FROM comments WHERE author_id = $1 AND NOT deleted AND " + visible
+	args := []any{author}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !storage.ValidID(id) {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		query += " AND (pub_time, id) < ($2, $3)"
+		args = append(args, tm, id)
+	}
+	// Запрашиваем на один комментарий больше, чтобы узнать, есть ли
+	// следующая страница.
+	query += fmt.Sprintf(" ORDER BY pub_time DESC, id DESC LIMIT %d", limit+1)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(comments) == 0 {
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+		last := comments[len(comments)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+	page.Comments = comments
+	return page, nil
+}
+
+// Subtree возвращает видимый читателям комментарий с переданным ID и ответы
+// на него не глубже depth уровней. Ответы на скрытые комментарии скрыты
+// вместе с ними. Удаленный комментарий без видимых неудаленных ответов
+// на любой глубине не найден. Комментарий идет первым, ответы
+// отсортированы по дате создания.
+func (s *Storage) Subtree(ctx context.Context, id string, depth int) ([]storage.Comment, error) {
+	const operation = "storage.postgres.Subtree"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRow(ctx,
+		"SELECT "+columns+" FROM comments WHERE id = $1 AND "+visible,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	if com.Deleted {
+		var live bool
+		err = s.db.QueryRow(ctx, `WITH RECURSIVE replies AS (
+				SELECT id, status, deleted FROM comments WHERE parent_id = $1 AND `+visible+`
+				UNION ALL
+				SELECT c.id, c.status, c.deleted FROM comments c JOIN replies r ON c.parent_id = r.id
+				WHERE c.`+visible+`
+			)
+			SELECT EXISTS (SELECT 1 FROM replies WHERE NOT deleted)`,
+			id,
+		).Scan(&live)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !live {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+	}
+
+	comments := []storage.Comment{com}
+	if depth <= 0 {
+		return comments, nil
+	}
+
+	rows, err := s.db.Query(ctx, `WITH RECURSIVE replies AS (
+			SELECT `+columns+`, 1 AS depth FROM comments WHERE parent_id = $1 AND `+visible+`
+			UNION ALL
+			SELECT c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports, r.depth + 1
+			FROM comments c JOIN replies r ON c.parent_id = r.id
+			WHERE r.depth < $2 AND c.`+visible+`
+		)
+		SELECT `+columns+` FROM replies ORDER BY pub_time DESC, id DESC`,
+		id, depth,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	replies, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return append(comments, replies...), nil
+}
+
+// Comment возвращает комментарий по ID независимо от его статуса
+// модерации.
+func (s *Storage) Comment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.postgres.Comment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRow(ctx,
+		"SELECT "+columns+" FROM comments WHERE id = $1",
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Counts возвращает количество видимых читателям неудаленных комментариев
+// к каждому из переданных постов. Посты без комментариев присутствуют
+// в ответе с нулевым значением.
+func (s *Storage) Counts(ctx context.Context, posts []string) (map[string]int, error) {
+	const operation = "storage.postgres.Counts"
+
+	counts := make(map[string]int, len(posts))
+	for _, post := range posts {
+		if !storage.ValidID(post) {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+		}
+		counts[post] = 0
+	}
+	if len(posts) == 0 {
+		return counts, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		"SELECT post_id, count(*) FROM comments WHERE post_id = ANY($1) AND NOT deleted AND "+visible+" GROUP BY post_id",
+		posts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post string
+		var count int
+		err = rows.Scan(&post, &count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		counts[post] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return counts, nil
+}
+
+// Pending возвращает очередь комментариев, ожидающих проверки модератором,
+// начиная с самых старых. Возвращает не более limit комментариев.
+func (s *Storage) Pending(ctx context.Context, limit int) ([]storage.Comment, error) {
+	const operation = "storage.postgres.Pending"
+
+	rows, err := s.db.Query(ctx,
+		"SELECT "+columns+" FROM comments WHERE status = $1 ORDER BY pub_time, id LIMIT $2",
+		storage.StatusPending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if comments == nil {
+		comments = []storage.Comment{}
+	}
+	return comments, nil
+}
+
+// SetStatus устанавливает статус модерации комментария с переданным ID
+// и возвращает обновленный комментарий. Одобрение отмечает жалобы на
+// комментарий проверенными.
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	const operation = "storage.postgres.SetStatus"
+
+	if !storage.ValidStatus(status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRow(ctx,
+		"UPDATE comments SET status = $2, reviewed = CASE WHEN $3 THEN reports ELSE reviewed END WHERE id = $1 RETURNING "+columns,
+		id, status, status == storage.StatusApproved,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// UpdateComment заменяет текст и статус модерации неудаленного комментария
+// с ID из переданной структуры. Пустой статус не изменяет прежний статус.
+// Предыдущий текст вместе со временем его публикации сохраняется в истории
+// изменений. Возвращает обновленный комментарий.
+func (s *Storage) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	const operation = "storage.postgres.UpdateComment"
+
+	if com.Content == "" {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrEmptyContent)
+	}
+	if com.Status != "" && !storage.ValidStatus(com.Status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(com.ID) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	// Текущий текст попадает в историю тем же запросом, что и запись
+	// нового, строка блокируется на время запроса.
+	upd, err := scanComment(s.db.QueryRow(ctx, `WITH old AS (
+			SELECT id, content, COALESCE(edited_at, pub_time) AS time
+			FROM comments WHERE id = $1 AND NOT deleted FOR UPDATE
+		), history AS (
+			INSERT INTO comment_history (comment_id, content, time)
+			SELECT id, content, time FROM old
+		)
+		UPDATE comments c SET content = $2, status = COALESCE(NULLIF($3, ''), c.status), edited_at = $4
+		FROM old WHERE c.id = old.id
+		RETURNING c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports`,
+		com.ID, com.Content, com.Status, now(),
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return upd, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return upd, fmt.Errorf("%s: %w", operation, err)
+	}
+	return upd, nil
+}
+
+// History возвращает предыдущие версии текста комментария с переданным ID,
+// начиная с самой старой.
+func (s *Storage) History(ctx context.Context, id string) ([]storage.Revision, error) {
+	const operation = "storage.postgres.History"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	var exists bool
+	err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+
+	rows, err := s.db.Query(ctx,
+		"SELECT content, time FROM comment_history WHERE comment_id = $1 ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	revs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (storage.Revision, error) {
+		var rev storage.Revision
+		err := row.Scan(&rev.Content, &rev.Time)
+		rev.Time = rev.Time.UTC()
+		return rev, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if revs == nil {
+		revs = []storage.Revision{}
+	}
+	return revs, nil
+}
+
+// DeleteComment помечает комментарий с переданным ID как удаленный и убирает
+// его текст. Строка остается в БД, чтобы ответы на комментарий не потеряли
+// родителя. Текст сохраняется в истории изменений. Возвращает удаленный
+// комментарий.
+func (s *Storage) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.postgres.DeleteComment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRow(ctx, `WITH old AS (
+			SELECT id, content, COALESCE(edited_at, pub_time) AS time
+			FROM comments WHERE id = $1 AND NOT deleted FOR UPDATE
+		), history AS (
+			INSERT INTO comment_history (comment_id, content, time)
+			SELECT id, content, time FROM old
+		)
+		UPDATE comments c SET content = '', deleted = TRUE
+		FROM old WHERE c.id = old.id
+		RETURNING c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// React записывает реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Предыдущая реакция пользователя на этот
+// комментарий заменяется. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) React(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	const operation = "storage.postgres.React"
+
+	if !storage.ValidReaction(reaction) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReaction)
+	}
+
+	com, err := s.setReaction(ctx, id, user, reaction)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Unreact удаляет реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) Unreact(ctx context.Context, id, user string) (storage.Comment, error) {
+	const operation = "storage.postgres.Unreact"
+
+	com, err := s.setReaction(ctx, id, user, "")
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// setReaction в одной транзакции заменяет реакцию пользователя
+// на комментарий и изменяет число реакций в строке комментария. Пустая
+// реакция удаляет предыдущую. Строка комментария блокируется на время
+// транзакции, поэтому одновременные реакции не теряют изменения счетчиков.
+func (s *Storage) setReaction(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	if !storage.ValidID(id) {
+		return storage.Comment{}, storage.ErrIncorrectCommentID
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, storage.ErrIncorrectUserID
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var prev string
+	err = tx.QueryRow(ctx, `SELECT COALESCE(r.reaction, '')
+		FROM comments c LEFT JOIN comment_reactions r ON r.comment_id = c.id AND r.user_id = $2
+		WHERE c.id = $1 AND NOT c.deleted AND c.`+visible+`
+		FOR UPDATE OF c`,
+		id, user,
+	).Scan(&prev)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.Comment{}, storage.ErrCommentNotFound
+		}
+		return storage.Comment{}, err
+	}
+
+	if reaction == "" {
+		_, err = tx.Exec(ctx, "DELETE FROM comment_reactions WHERE comment_id = $1 AND user_id = $2", id, user)
+	} else {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO comment_reactions (comment_id, user_id, reaction) VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id) DO UPDATE SET reaction = excluded.reaction`,
+			id, user, reaction,
+		)
+	}
+	if err != nil {
+		return storage.Comment{}, err
+	}
+
+	likes, dislikes := storage.ReactionDelta(prev, reaction)
+	com, err := scanComment(tx.QueryRow(ctx,
+		"UPDATE comments SET likes = likes + $2, dislikes = dislikes + $3 WHERE id = $1 RETURNING "+columns,
+		id, likes, dislikes,
+	))
+	if err != nil {
+		return com, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// Report записывает жалобу пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Повторная жалоба пользователя на тот же
+// комментарий не принимается. Когда число жалоб достигает порога
+// threshold, комментарий отправляется на проверку модератору. Возвращает
+// комментарий с обновленным числом жалоб и статусом.
+func (s *Storage) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	const operation = "storage.postgres.Report"
+
+	if !storage.ValidReason(reason) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReason)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	com, err := s.report(ctx, id, user, reason, threshold)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// report в одной транзакции записывает жалобу, увеличивает число жалоб
+// в строке комментария и при достижении порога изменяет его статус.
+// Строка комментария блокируется на время транзакции, поэтому порог
+// срабатывает ровно один раз.
+func (s *Storage) report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx,
+		"SELECT TRUE FROM comments WHERE id = $1 AND NOT deleted AND "+visible+" FOR UPDATE",
+		id,
+	).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.Comment{}, storage.ErrCommentNotFound
+		}
+		return storage.Comment{}, err
+	}
+
+	tag, err := tx.Exec(ctx,
+		"INSERT INTO comment_reports (comment_id, user_id, reason) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		id, user, reason,
+	)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.Comment{}, storage.ErrAlreadyReported
+	}
+
+	com, err := scanComment(tx.QueryRow(ctx,
+		"UPDATE comments SET reports = reports + 1 WHERE id = $1 RETURNING "+columns,
+		id,
+	))
+	if err != nil {
+		return com, err
+	}
+	if storage.Hide(com.Status, com.Reports, threshold) {
+		com, err = scanComment(tx.QueryRow(ctx,
+			"UPDATE comments SET status = $2 WHERE id = $1 RETURNING "+columns,
+			id, storage.StatusPending,
+		))
+		if err != nil {
+			return com, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// Reported возвращает неудаленные и не отклоненные комментарии с жалобами,
+// поступившими после одобрения модератором, начиная с комментариев
+// с наибольшим числом жалоб. При равном числе жалоб сначала идут новые.
+// Возвращает не более limit комментариев.
+func (s *Storage) Reported(ctx context.Context, limit int) ([]storage.Reported, error) {
+	const operation = "storage.postgres.Reported"
+
+	rows, err := s.db.Query(ctx,
+		"SELECT "+columns+" FROM comments WHERE reports > 0 AND reports > reviewed AND NOT deleted AND status != $1 ORDER BY reports DESC, pub_time DESC, id DESC LIMIT $2",
+		storage.StatusRejected, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	list := make([]storage.Reported, 0, len(comments))
+	if len(comments) == 0 {
+		return list, nil
+	}
+	byID := make(map[string]map[string]int, len(comments))
+	ids := make([]string, 0, len(comments))
+	for _, com := range comments {
+		byID[com.ID] = make(map[string]int)
+		ids = append(ids, com.ID)
+	}
+
+	rows, err = s.db.Query(ctx,
+		"SELECT comment_id, reason, count(*) FROM comment_reports WHERE comment_id = ANY($1) GROUP BY comment_id, reason",
+		ids,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, reason string
+		var count int
+		err = rows.Scan(&id, &reason, &count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		byID[id][reason] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	for _, com := range comments {
+		list = append(list, storage.Reported{Comment: com, Reasons: byID[com.ID]})
+	}
+	return list, nil
+}
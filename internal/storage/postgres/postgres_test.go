@@ -0,0 +1,259 @@
+// Пакет для работы с базой данных PostgreSQL в сервисе комментариев.
+
+package postgres
+
+import (
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/storagetest"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testStorage подключается к тестовой БД из переменной окружения
+// POSTGRES_TEST_DSN и очищает таблицы. Если переменная не задана,
+// то тест пропускается.
+func testStorage(t *testing.T) *Storage {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	st, err := new(poolCfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Таблицы со ссылками на comments очищаются вместе с ней.
+	_, err = st.db.Exec(context.Background(),
+		"TRUNCATE comments, comment_history, comment_reactions, comment_reports")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.DB {
+		return testStorage(t)
+	})
+}
+
+func TestStorage_AddComment(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	root, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	hidden, err := st.AddComment(ctx, storage.Comment{PostID: post, Content: "hidden", Status: storage.StatusPending})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		com     storage.Comment
+		wantErr error
+	}{
+		{
+			name: "Reply_OK",
+			com:  storage.Comment{PostID: post, ParentID: root, Content: "reply"},
+		},
+		{
+			name:    "Incorrect_PostID",
+			com:     storage.Comment{PostID: "post", Content: "reply"},
+			wantErr: storage.ErrIncorrectPostID,
+		},
+		{
+			name:    "Incorrect_ParentID",
+			com:     storage.Comment{PostID: post, ParentID: "parent", Content: "reply"},
+			wantErr: storage.ErrIncorrectParentID,
+		},
+		{
+			name:    "Parent_Not_Found",
+			com:     storage.Comment{PostID: post, ParentID: storage.NewID(), Content: "reply"},
+			wantErr: storage.ErrParentNotFound,
+		},
+		{
+			name:    "Parent_Pending",
+			com:     storage.Comment{PostID: post, ParentID: hidden, Content: "reply"},
+			wantErr: storage.ErrParentNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AddComment(ctx, tt.com)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Storage.AddComment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStorage_Comments(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	_, err := st.Comments(ctx, post)
+	if !errors.Is(err, storage.ErrNoComments) {
+		t.Fatalf("Storage.Comments() error = %v, wantErr %v", err, storage.ErrNoComments)
+	}
+
+	root, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	reply, _ := st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	st.AddComment(ctx, storage.Comment{PostID: post, ParentID: reply, Content: "hidden", Status: storage.StatusPending})
+	st.AddComment(ctx, storage.Comment{PostID: storage.NewID(), Content: "other"})
+
+	got, err := st.Comments(ctx, post)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(got) != 2 {
+		t.Errorf("Storage.Comments() len = %d, want %d", len(got), 2)
+	}
+}
+
+func TestStorage_Threads(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	for i := 0; i < 3; i++ {
+		root, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+		reply, _ := st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+		st.AddComment(ctx, storage.Comment{PostID: post, ParentID: reply, Content: "reply"})
+	}
+
+	page, err := st.Threads(ctx, post, 2, "", false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(page.Comments) != 6 || page.NextCursor == "" {
+		t.Fatalf("Storage.Threads() len = %d, cursor = %q", len(page.Comments), page.NextCursor)
+	}
+
+	page, err = st.Threads(ctx, post, 2, page.NextCursor, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(page.Comments) != 3 || page.NextCursor != "" {
+		t.Errorf("Storage.Threads() len = %d, cursor = %q", len(page.Comments), page.NextCursor)
+	}
+}
+
+func TestStorage_Subtree(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	root, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	reply, _ := st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	st.AddComment(ctx, storage.Comment{PostID: post, ParentID: reply, Content: "reply"})
+
+	got, err := st.Subtree(ctx, root, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(got) != 2 || got[0].ID != root {
+		t.Errorf("Storage.Subtree() len = %d", len(got))
+	}
+
+	_, err = st.Subtree(ctx, storage.NewID(), 1)
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("Storage.Subtree() error = %v, wantErr %v", err, storage.ErrCommentNotFound)
+	}
+}
+
+func TestStorage_Counts(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post, empty := storage.NewID(), storage.NewID()
+
+	root, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "root"})
+	st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	st.AddComment(ctx, storage.Comment{PostID: post, Content: "hidden", Status: storage.StatusPending})
+
+	got, err := st.Counts(ctx, []string{post, empty})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got[post] != 2 || got[empty] != 0 {
+		t.Errorf("Storage.Counts() = %v", got)
+	}
+}
+
+func TestStorage_Moderation(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	id, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "text", Status: storage.StatusPending})
+
+	pending, err := st.Pending(ctx, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(pending) != 1 || pending[0].ID != id {
+		t.Fatalf("Storage.Pending() len = %d", len(pending))
+	}
+
+	com, err := st.SetStatus(ctx, id, storage.StatusApproved)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if com.Status != storage.StatusApproved {
+		t.Errorf("Storage.SetStatus() status = %s, want %s", com.Status, storage.StatusApproved)
+	}
+
+	_, err = st.SetStatus(ctx, storage.NewID(), storage.StatusApproved)
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("Storage.SetStatus() error = %v, wantErr %v", err, storage.ErrCommentNotFound)
+	}
+}
+
+func TestStorage_UpdateDelete(t *testing.T) {
+	st := testStorage(t)
+	ctx := context.Background()
+	post := storage.NewID()
+
+	id, _ := st.AddComment(ctx, storage.Comment{PostID: post, Content: "first"})
+
+	com, err := st.UpdateComment(ctx, storage.Comment{ID: id, Content: "second"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if com.Content != "second" || com.EditedAt == nil {
+		t.Errorf("Storage.UpdateComment() = %+v", com)
+	}
+
+	com, err = st.DeleteComment(ctx, id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !com.Deleted || com.Content != "" {
+		t.Errorf("Storage.DeleteComment() = %+v", com)
+	}
+
+	_, err = st.UpdateComment(ctx, storage.Comment{ID: id, Content: "third"})
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("Storage.UpdateComment() error = %v, wantErr %v", err, storage.ErrCommentNotFound)
+	}
+
+	revs, err := st.History(ctx, id)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(revs) != 2 || revs[0].Content != "first" || revs[1].Content != "second" {
+		t.Errorf("Storage.History() = %+v", revs)
+	}
+}
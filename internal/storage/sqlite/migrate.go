@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations - SQL файлы миграций схемы БД. Имя файла начинается с номера
+// версии, миграции применяются по возрастанию номера.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migration - одна миграция схемы БД.
+type migration struct {
+	version int
+	name    string
+}
+
+// migrate применяет к БД все еще не примененные миграции в одной
+// транзакции. Номера примененных миграций хранятся в таблице
+// schema_migrations.
+func migrate(ctx context.Context, db *sql.DB) error {
+	const operation = "storage.sqlite.migrate"
+
+	list, err := listMigrations()
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+
+	for _, m := range list {
+		var applied bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)", m.version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		if applied {
+			continue
+		}
+
+		sql, err := migrations.ReadFile("migrations/" + m.name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		_, err = tx.ExecContext(ctx, string(sql))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", operation, m.name, err)
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", m.version)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+// listMigrations возвращает список файлов миграций, отсортированный
+// по номеру версии.
+func listMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect migration name: %s", e.Name())
+		}
+		list = append(list, migration{version: version, name: e.Name()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+
+	return list, nil
+}
@@ -0,0 +1,29 @@
+-- Комментарии к постам. У корневых комментариев parent_id - пустая строка.
+-- Время хранится в миллисекундах Unix.
+CREATE TABLE comments (
+	id        TEXT PRIMARY KEY,
+	parent_id TEXT NOT NULL DEFAULT '',
+	post_id   TEXT NOT NULL,
+	pub_time  INTEGER NOT NULL,
+	edited_at INTEGER,
+	content   TEXT NOT NULL,
+	status    TEXT NOT NULL DEFAULT 'approved',
+	deleted   INTEGER NOT NULL DEFAULT 0
+);
+
+-- Выдача комментариев по посту и постраничная выдача корневых комментариев.
+CREATE INDEX comments_post_idx ON comments (post_id, parent_id, pub_time DESC, id DESC);
+-- Рекурсивный обход ответов.
+CREATE INDEX comments_parent_idx ON comments (parent_id);
+-- Очередь модерации.
+CREATE INDEX comments_status_idx ON comments (status, pub_time);
+
+-- Предыдущие версии текста комментариев.
+CREATE TABLE comment_history (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	comment_id TEXT NOT NULL REFERENCES comments (id),
+	content    TEXT NOT NULL,
+	time       INTEGER NOT NULL
+);
+
+CREATE INDEX comment_history_comment_idx ON comment_history (comment_id, id);
@@ -0,0 +1,923 @@
+// Пакет для работы со встроенной базой данных SQLite в сервисе комментариев.
+// Используется для локальной разработки и развертывания на одном узле без
+// внешней инфраструктуры.
+package sqlite
+
+import (
+	"GoExamComments/internal/config"
+	"GoExamComments/internal/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// tmConn - таймаут на открытие файла БД и применение миграций.
+const tmConn time.Duration = time.Second * 20
+
+// pragmas - настройки, которые применяются к каждому подключению к БД.
+const pragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// columns - столбцы таблицы comments в порядке полей storage.Comment.
+const columns = "id, parent_id, post_id, author_id, author_name, pub_time, edited_at, content, status, deleted, likes, dislikes, reports"
+
+// visible - условие для комментариев, которые видны читателям.
+const visible = "status NOT IN ('" + storage.StatusPending + "', '" + storage.StatusRejected + "')"
+
+// Storage - подключение к файлу БД.
+type Storage struct {
+	db *sql.DB
+}
+
+// New - обертка для конструктора подключения new. Путь к файлу БД берется
+// из StoragePath.
+func New(cfg *config.Config) *Storage {
+	storage, err := new(cfg.StoragePath)
+	if err != nil {
+		log.Fatalf("failed to init storage: %s", err.Error())
+	}
+	return storage
+}
+
+// new - конструктор подключения к БД. Создает файл БД, если его нет,
+// и применяет к БД миграции схемы.
+func new(path string) (*Storage, error) {
+	const operation = "storage.sqlite.new"
+
+	if path == "" {
+		return nil, fmt.Errorf("%s: empty database path", operation)
+	}
+
+	u := url.URL{Scheme: "file", Opaque: path, RawQuery: pragmas}
+	db, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	// SQLite допускает только одну пишущую транзакцию, поэтому все запросы
+	// выполняются через одно подключение. Так же работает и БД в памяти,
+	// которая существует только в рамках подключения.
+	db.SetMaxOpenConns(1)
+
+	tm, cancel := context.WithTimeout(context.Background(), tmConn)
+	defer cancel()
+
+	err = db.PingContext(tm)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	err = migrate(tm, db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// Close - обертка для закрытия подключения.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// scanner - строка или строки результата запроса.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanComment читает комментарий из строки результата запроса, столбцы
+// которой перечислены в columns.
+func scanComment(row scanner) (storage.Comment, error) {
+	var com storage.Comment
+	var pubTime int64
+	var editedAt sql.NullInt64
+	err := row.Scan(
+		&com.ID,
+		&com.ParentID,
+		&com.PostID,
+		&com.AuthorID,
+		&com.AuthorName,
+		&pubTime,
+		&editedAt,
+		&com.Content,
+		&com.Status,
+		&com.Deleted,
+		&com.Likes,
+		&com.Dislikes,
+		&com.Reports,
+	)
+	if err != nil {
+		return com, err
+	}
+	com.PubTime = time.UnixMilli(pubTime).UTC()
+	if editedAt.Valid {
+		t := time.UnixMilli(editedAt.Int64).UTC()
+		com.EditedAt = &t
+	}
+	return com, nil
+}
+
+// collect читает все комментарии из результата запроса и закрывает его.
+func collect(rows *sql.Rows) ([]storage.Comment, error) {
+	defer rows.Close()
+
+	var comments []storage.Comment
+	for rows.Next() {
+		com, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, com)
+	}
+	return comments, rows.Err()
+}
+
+// jsonArray кодирует список строк в JSON массив для передачи в запрос.
+// Массив разворачивается в запросе функцией json_each.
+func jsonArray(list []string) string {
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+// AddComment записывает переданный комментарий в БД.
+func (s *Storage) AddComment(ctx context.Context, com storage.Comment) (string, error) {
+	const operation = "storage.sqlite.AddComment"
+
+	if !storage.ValidID(com.PostID) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+	if !storage.ValidAuthor(com.AuthorID, com.AuthorName) {
+		return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectAuthor)
+	}
+
+	// Проверим, что родительский комментарий существует и виден читателям,
+	// чтобы избежать вставки комментария с некорректной связью.
+	if com.ParentID != "" {
+		if !storage.ValidID(com.ParentID) {
+			return "", fmt.Errorf("%s: %w", operation, storage.ErrIncorrectParentID)
+		}
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND NOT deleted AND "+visible+")",
+			com.ParentID,
+		).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", operation, err)
+		}
+		if !exists {
+			return "", fmt.Errorf("%s: %w", operation, storage.ErrParentNotFound)
+		}
+	}
+
+	if com.Status == "" {
+		com.Status = storage.StatusApproved
+	}
+
+	id := storage.NewID()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO comments (id, parent_id, post_id, author_id, author_name, pub_time, content, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, com.ParentID, com.PostID, com.AuthorID, com.AuthorName, time.Now().UnixMilli(), com.Content, com.Status,
+	)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return id, nil
+}
+
+// Comments возвращает все видимые читателям комментарии по переданному
+// ID поста, отсортированные по дате создания. Удаленные комментарии тоже
+// возвращаются, чтобы сохранить связи в дереве. Ответы на скрытые
+// комментарии скрыты вместе с ними.
+func (s *Storage) Comments(ctx context.Context, post string) ([]storage.Comment, error) {
+	const operation = "storage.sqlite.Comments"
+
+	if !storage.ValidID(post) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	// Скрытые комментарии и все ответы на них собираются рекурсивным
+	// запросом. Ответы на отсутствующие комментарии остаются в выборке.
+	rows, err := s.db.QueryContext(ctx, `WITH RECURSIVE hidden AS (
+			SELECT id FROM comments WHERE post_id = ? AND NOT (`+visible+`)
+			UNION ALL
+			SELECT c.id FROM comments c JOIN hidden h ON c.parent_id = h.id
+		)
+		SELECT `+columns+` FROM comments
+		WHERE post_id = ? AND `+visible+` AND id NOT IN (SELECT id FROM hidden)
+		ORDER BY pub_time DESC, id DESC`,
+		post, post,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(comments) == 0 {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+	}
+	return comments, nil
+}
+
+// Threads возвращает страницу корневых комментариев к посту вместе со всеми
+// ответами на них. Корневые комментарии отсортированы по убыванию даты
+// создания, а при oldest - по возрастанию, на странице не более limit
+// корневых комментариев. Ответы выбираются рекурсивным запросом от
+// корневых комментариев страницы.
+func (s *Storage) Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (storage.Page, error) {
+	const operation = "storage.sqlite.Threads"
+
+	var page storage.Page
+
+	if !storage.ValidID(post) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+	}
+
+	sign, dir := "<", "DESC"
+	if oldest {
+		sign, dir = ">", "ASC"
+	}
+
+	query := "SELECT " + columns + " FROM comments WHERE post_id = ? AND parent_id = '' AND " + visible
+	args := []any{post}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !storage.ValidID(id) {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		query += " AND (pub_time, id) " + sign + " (?, ?)"
+		args = append(args, tm.UnixMilli(), id)
+	}
+	// Запрашиваем на один корневой комментарий больше, чтобы узнать,
+	// есть ли следующая страница.
+	query += " ORDER BY pub_time " + dir + ", id " + dir + " LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	roots, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(roots) == 0 {
+		if cursor == "" {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrNoComments)
+		}
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		last := roots[len(roots)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+
+	ids := make([]string, 0, len(roots))
+	for _, root := range roots {
+		ids = append(ids, root.ID)
+	}
+
+	// Обход идет только по видимым ответам: ответы на скрытый
+	// комментарий скрыты вместе с ним.
+	rows, err = s.db.QueryContext(ctx, `WITH RECURSIVE replies AS (
+			SELECT `+columns+` FROM comments WHERE parent_id IN (SELECT value FROM json_each(?)) AND `+visible+`
+			UNION ALL
+			SELECT c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports
+			FROM comments c JOIN replies r ON c.parent_id = r.id
+			WHERE c.`+visible+`
+		)
+		SELECT `+columns+` FROM replies ORDER BY pub_time DESC, id DESC`,
+		jsonArray(ids),
+	)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	replies, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	page.Comments = append(roots, replies...)
+	return page, nil
+}
+
+// AuthorComments возвращает страницу видимых читателям неудаленных
+// комментариев автора к любым постам. Комментарии отсортированы
+// по убыванию даты создания, на странице не более limit комментариев.
+func (s *Storage) AuthorComments(ctx context.Context, author string, limit int, cursor string) (storage.Page, error) {
+	const operation = "storage.sqlite.AuthorComments"
+
+	var page storage.Page
+
+	if !storage.ValidUserID(author) {
+		return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	query := "SELECT " + columns + " FROM comments WHERE author_id = ? AND NOT deleted AND " + visible
+	args := []any{author}
+	if cursor != "" {
+		tm, id, err := storage.DecodeCursor(cursor)
+		if err != nil {
+			return page, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !storage.ValidID(id) {
+			return page, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCursor)
+		}
+		query += " AND (pub_time, id) < (?, ?)"
+		args = append(args, tm.UnixMilli(), id)
+	}
+	// Запрашиваем на один комментарий больше, чтобы узнать, есть ли
+	// следующая страница.
+	query += " ORDER BY pub_time DESC, id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return page, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if len(comments) == 0 {
+		page.Comments = []storage.Comment{}
+		return page, nil
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+		last := comments[len(comments)-1]
+		page.NextCursor = storage.EncodeCursor(last.PubTime, last.ID)
+	}
+	page.Comments = comments
+	return page, nil
+}
+
+// Subtree возвращает видимый читателям комментарий с переданным ID и ответы
+// на него не глубже depth уровней. Ответы на скрытые комментарии скрыты
+// вместе с ними. Удаленный комментарий без видимых неудаленных ответов
+// на любой глубине не найден. Комментарий идет первым, ответы
+// отсортированы по дате создания.
+func (s *Storage) Subtree(ctx context.Context, id string, depth int) ([]storage.Comment, error) {
+	const operation = "storage.sqlite.Subtree"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRowContext(ctx,
+		"SELECT "+columns+" FROM comments WHERE id = ? AND "+visible,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	if com.Deleted {
+		var live bool
+		err = s.db.QueryRowContext(ctx, `WITH RECURSIVE replies AS (
+				SELECT id, status, deleted FROM comments WHERE parent_id = ? AND `+visible+`
+				UNION ALL
+				SELECT c.id, c.status, c.deleted FROM comments c JOIN replies r ON c.parent_id = r.id
+				WHERE c.`+visible+`
+			)
+			SELECT EXISTS (SELECT 1 FROM replies WHERE NOT deleted)`,
+			id,
+		).Scan(&live)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		if !live {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+	}
+
+	comments := []storage.Comment{com}
+	if depth <= 0 {
+		return comments, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `WITH RECURSIVE replies AS (
+			SELECT `+columns+`, 1 AS depth FROM comments WHERE parent_id = ? AND `+visible+`
+			UNION ALL
+			SELECT c.id, c.parent_id, c.post_id, c.author_id, c.author_name, c.pub_time, c.edited_at, c.content, c.status, c.deleted, c.likes, c.dislikes, c.reports, r.depth + 1
+			FROM comments c JOIN replies r ON c.parent_id = r.id
+			WHERE r.depth < ? AND c.`+visible+`
+		)
+		SELECT `+columns+` FROM replies ORDER BY pub_time DESC, id DESC`,
+		id, depth,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	replies, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return append(comments, replies...), nil
+}
+
+// Comment возвращает комментарий по ID независимо от его статуса
+// модерации.
+func (s *Storage) Comment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.sqlite.Comment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRowContext(ctx,
+		"SELECT "+columns+" FROM comments WHERE id = ?",
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Counts возвращает количество видимых читателям неудаленных комментариев
+// к каждому из переданных постов. Посты без комментариев присутствуют
+// в ответе с нулевым значением.
+func (s *Storage) Counts(ctx context.Context, posts []string) (map[string]int, error) {
+	const operation = "storage.sqlite.Counts"
+
+	counts := make(map[string]int, len(posts))
+	for _, post := range posts {
+		if !storage.ValidID(post) {
+			return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectPostID)
+		}
+		counts[post] = 0
+	}
+	if len(posts) == 0 {
+		return counts, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT post_id, count(*) FROM comments WHERE post_id IN (SELECT value FROM json_each(?)) AND NOT deleted AND "+visible+" GROUP BY post_id",
+		jsonArray(posts),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post string
+		var count int
+		err = rows.Scan(&post, &count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		counts[post] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return counts, nil
+}
+
+// Pending возвращает очередь комментариев, ожидающих проверки модератором,
+// начиная с самых старых. Возвращает не более limit комментариев.
+func (s *Storage) Pending(ctx context.Context, limit int) ([]storage.Comment, error) {
+	const operation = "storage.sqlite.Pending"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+columns+" FROM comments WHERE status = ? ORDER BY pub_time, id LIMIT ?",
+		storage.StatusPending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	if comments == nil {
+		comments = []storage.Comment{}
+	}
+	return comments, nil
+}
+
+// SetStatus устанавливает статус модерации комментария с переданным ID
+// и возвращает обновленный комментарий. Одобрение отмечает жалобы на
+// комментарий проверенными.
+func (s *Storage) SetStatus(ctx context.Context, id string, status string) (storage.Comment, error) {
+	const operation = "storage.sqlite.SetStatus"
+
+	if !storage.ValidStatus(status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := scanComment(s.db.QueryRowContext(ctx,
+		"UPDATE comments SET status = ?, reviewed = CASE WHEN ? THEN reports ELSE reviewed END WHERE id = ? RETURNING "+columns,
+		status, status == storage.StatusApproved, id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return com, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+		}
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// UpdateComment заменяет текст и статус модерации неудаленного комментария
+// с ID из переданной структуры. Пустой статус не изменяет прежний статус.
+// Предыдущий текст вместе со временем его публикации сохраняется в истории
+// изменений. Возвращает обновленный комментарий.
+func (s *Storage) UpdateComment(ctx context.Context, com storage.Comment) (storage.Comment, error) {
+	const operation = "storage.sqlite.UpdateComment"
+
+	if com.Content == "" {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrEmptyContent)
+	}
+	if com.Status != "" && !storage.ValidStatus(com.Status) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectStatus)
+	}
+	if !storage.ValidID(com.ID) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	upd, err := s.rewrite(ctx, com.ID,
+		"UPDATE comments SET content = ?, status = COALESCE(NULLIF(?, ''), status), edited_at = ? WHERE id = ? RETURNING "+columns,
+		com.Content, com.Status, time.Now().UnixMilli(), com.ID,
+	)
+	if err != nil {
+		return upd, fmt.Errorf("%s: %w", operation, err)
+	}
+	return upd, nil
+}
+
+// History возвращает предыдущие версии текста комментария с переданным ID,
+// начиная с самой старой.
+func (s *Storage) History(ctx context.Context, id string) ([]storage.Revision, error) {
+	const operation = "storage.sqlite.History"
+
+	if !storage.ValidID(id) {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE id = ?)", id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", operation, storage.ErrCommentNotFound)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT content, time FROM comment_history WHERE comment_id = ? ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	defer rows.Close()
+
+	revs := []storage.Revision{}
+	for rows.Next() {
+		var rev storage.Revision
+		var tm int64
+		err = rows.Scan(&rev.Content, &tm)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		rev.Time = time.UnixMilli(tm).UTC()
+		revs = append(revs, rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	return revs, nil
+}
+
+// DeleteComment помечает комментарий с переданным ID как удаленный и убирает
+// его текст. Строка остается в БД, чтобы ответы на комментарий не потеряли
+// родителя. Текст сохраняется в истории изменений. Возвращает удаленный
+// комментарий.
+func (s *Storage) DeleteComment(ctx context.Context, id string) (storage.Comment, error) {
+	const operation = "storage.sqlite.DeleteComment"
+
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+
+	com, err := s.rewrite(ctx, id,
+		"UPDATE comments SET content = '', deleted = 1 WHERE id = ? RETURNING "+columns,
+		id,
+	)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// rewrite в одной транзакции сохраняет текущий текст неудаленного
+// комментария с переданным ID в истории изменений и выполняет запрос
+// на его изменение. Запрос должен возвращать столбцы columns.
+func (s *Storage) rewrite(ctx context.Context, id string, query string, args ...any) (storage.Comment, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO comment_history (comment_id, content, time)
+		SELECT id, content, COALESCE(edited_at, pub_time) FROM comments WHERE id = ? AND NOT deleted`,
+		id,
+	)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	if n == 0 {
+		return storage.Comment{}, storage.ErrCommentNotFound
+	}
+
+	com, err := scanComment(tx.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return com, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// React записывает реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Предыдущая реакция пользователя на этот
+// комментарий заменяется. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) React(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	const operation = "storage.sqlite.React"
+
+	if !storage.ValidReaction(reaction) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReaction)
+	}
+
+	com, err := s.setReaction(ctx, id, user, reaction)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// Unreact удаляет реакцию пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Возвращает комментарий с обновленным числом
+// реакций.
+func (s *Storage) Unreact(ctx context.Context, id, user string) (storage.Comment, error) {
+	const operation = "storage.sqlite.Unreact"
+
+	com, err := s.setReaction(ctx, id, user, "")
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// setReaction в одной транзакции заменяет реакцию пользователя
+// на комментарий и изменяет число реакций в строке комментария. Пустая
+// реакция удаляет предыдущую.
+func (s *Storage) setReaction(ctx context.Context, id, user, reaction string) (storage.Comment, error) {
+	if !storage.ValidID(id) {
+		return storage.Comment{}, storage.ErrIncorrectCommentID
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, storage.ErrIncorrectUserID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND NOT deleted AND "+visible+")",
+		id,
+	).Scan(&exists)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	if !exists {
+		return storage.Comment{}, storage.ErrCommentNotFound
+	}
+
+	var prev string
+	err = tx.QueryRowContext(ctx,
+		"SELECT reaction FROM comment_reactions WHERE comment_id = ? AND user_id = ?",
+		id, user,
+	).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return storage.Comment{}, err
+	}
+
+	if reaction == "" {
+		_, err = tx.ExecContext(ctx, "DELETE FROM comment_reactions WHERE comment_id = ? AND user_id = ?", id, user)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO comment_reactions (comment_id, user_id, reaction) VALUES (?, ?, ?)
+			ON CONFLICT (comment_id, user_id) DO UPDATE SET reaction = excluded.reaction`,
+			id, user, reaction,
+		)
+	}
+	if err != nil {
+		return storage.Comment{}, err
+	}
+
+	likes, dislikes := storage.ReactionDelta(prev, reaction)
+	com, err := scanComment(tx.QueryRowContext(ctx,
+		"UPDATE comments SET likes = likes + ?, dislikes = dislikes + ? WHERE id = ? RETURNING "+columns,
+		likes, dislikes, id,
+	))
+	if err != nil {
+		return com, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// Report записывает жалобу пользователя на видимый читателям неудаленный
+// комментарий с переданным ID. Повторная жалоба пользователя на тот же
+// комментарий не принимается. Когда число жалоб достигает порога
+// threshold, комментарий отправляется на проверку модератору. Возвращает
+// комментарий с обновленным числом жалоб и статусом.
+func (s *Storage) Report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	const operation = "storage.sqlite.Report"
+
+	if !storage.ValidReason(reason) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectReason)
+	}
+	if !storage.ValidID(id) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectCommentID)
+	}
+	if !storage.ValidUserID(user) {
+		return storage.Comment{}, fmt.Errorf("%s: %w", operation, storage.ErrIncorrectUserID)
+	}
+
+	com, err := s.report(ctx, id, user, reason, threshold)
+	if err != nil {
+		return com, fmt.Errorf("%s: %w", operation, err)
+	}
+	return com, nil
+}
+
+// report в одной транзакции записывает жалобу, увеличивает число жалоб
+// в строке комментария и при достижении порога изменяет его статус.
+func (s *Storage) report(ctx context.Context, id, user, reason string, threshold int) (storage.Comment, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND NOT deleted AND "+visible+")",
+		id,
+	).Scan(&exists)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	if !exists {
+		return storage.Comment{}, storage.ErrCommentNotFound
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO comment_reports (comment_id, user_id, reason) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		id, user, reason,
+	)
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	if n == 0 {
+		return storage.Comment{}, storage.ErrAlreadyReported
+	}
+
+	com, err := scanComment(tx.QueryRowContext(ctx,
+		"UPDATE comments SET reports = reports + 1 WHERE id = ? RETURNING "+columns,
+		id,
+	))
+	if err != nil {
+		return com, err
+	}
+	if storage.Hide(com.Status, com.Reports, threshold) {
+		com, err = scanComment(tx.QueryRowContext(ctx,
+			"UPDATE comments SET status = ? WHERE id = ? RETURNING "+columns,
+			storage.StatusPending, id,
+		))
+		if err != nil {
+			return com, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return storage.Comment{}, err
+	}
+	return com, nil
+}
+
+// Reported возвращает неудаленные и не отклоненные комментарии с жалобами,
+// поступившими после одобрения модератором, начиная с комментариев
+// с наибольшим числом жалоб. При равном числе жалоб сначала идут новые.
+// Возвращает не более limit комментариев.
+func (s *Storage) Reported(ctx context.Context, limit int) ([]storage.Reported, error) {
+	const operation = "storage.sqlite.Reported"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+columns+" FROM comments WHERE reports > 0 AND reports > reviewed AND NOT deleted AND status != ? ORDER BY reports DESC, pub_time DESC, id DESC LIMIT ?",
+		storage.StatusRejected, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	comments, err := collect(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	list := make([]storage.Reported, 0, len(comments))
+	if len(comments) == 0 {
+		return list, nil
+	}
+	byID := make(map[string]map[string]int, len(comments))
+	ids := make([]string, 0, len(comments))
+	for _, com := range comments {
+		byID[com.ID] = make(map[string]int)
+		ids = append(ids, com.ID)
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		"SELECT comment_id, reason, count(*) FROM comment_reports WHERE comment_id IN (SELECT value FROM json_each(?)) GROUP BY comment_id, reason",
+		jsonArray(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, reason string
+		var count int
+		err = rows.Scan(&id, &reason, &count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", operation, err)
+		}
+		byID[id][reason] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", operation, err)
+	}
+
+	for _, com := range comments {
+		list = append(list, storage.Reported{Comment: com, Reasons: byID[com.ID]})
+	}
+	return list, nil
+}
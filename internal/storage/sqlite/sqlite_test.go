@@ -0,0 +1,39 @@
+// Пакет для работы со встроенной базой данных SQLite в сервисе комментариев.
+
+package sqlite
+
+import (
+	"GoExamComments/internal/storage"
+	"GoExamComments/internal/storage/storagetest"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.DB {
+		st, err := new(filepath.Join(t.TempDir(), "comments.db"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	})
+}
+
+func Test_new(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comments.db")
+
+	// Повторное открытие файла не должно применять миграции еще раз.
+	for i := 0; i < 2; i++ {
+		st, err := new(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		st.Close()
+	}
+
+	_, err := new("")
+	if err == nil {
+		t.Error("new() error = nil, want error for empty path")
+	}
+}
@@ -3,8 +3,14 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Ошибки при работе с БД.
@@ -15,15 +21,179 @@ var (
 	ErrIncorrectPostID    = errors.New("incorrect post id")
 	ErrIncorrectCommentID = errors.New("incorrect comment id")
 	ErrEmptyContent       = errors.New("empty comment content field")
+	ErrCommentNotFound    = errors.New("comment not found")
+	ErrIncorrectStatus    = errors.New("incorrect comment status")
+	ErrIncorrectCursor    = errors.New("incorrect page cursor")
+	ErrIncorrectUserID    = errors.New("incorrect user id")
+	ErrIncorrectReaction  = errors.New("incorrect reaction")
+	ErrIncorrectReason    = errors.New("incorrect report reason")
+	ErrAlreadyReported    = errors.New("comment already reported by user")
+	ErrIncorrectAuthor    = errors.New("incorrect comment author")
+)
+
+// Статусы модерации комментария. Читателям видны только одобренные
+// комментарии. Комментарии без статуса, записанные до его появления,
+// считаются одобренными.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
 )
 
-// Comment - структура комментария к посту.
+// Реакции читателей на комментарий. Каждый читатель может оставить
+// не более одной реакции на комментарий.
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// Причины жалоб читателей на комментарий.
+const (
+	ReasonSpam     = "spam"
+	ReasonAbuse    = "abuse"
+	ReasonOffTopic = "offtopic"
+	ReasonOther    = "other"
+)
+
+// maxUserID - максимальная длина ID пользователя в байтах.
+const maxUserID = 128
+
+// maxAuthorName - максимальная длина имени автора комментария в символах.
+const maxAuthorName = 64
+
+// Comment - структура комментария к посту. EditedAt заполнено только
+// у отредактированных комментариев. Удаленный комментарий остается в БД
+// без текста с флагом Deleted, чтобы не терять ответы на него. Likes
+// и Dislikes - число реакций читателей каждого вида, Reports - число
+// жалоб читателей. AuthorID и AuthorName пусты у анонимных комментариев.
 type Comment struct {
-	ID       string    `json:"id" bson:"_id"`
-	ParentID string    `json:"parentId" bson:"parentId"`
-	PostID   string    `json:"postId" bson:"postId"`
-	PubTime  time.Time `json:"pubTime" bson:"pubTime"`
-	Content  string    `json:"content" bson:"content"`
+	ID         string     `json:"id" bson:"_id"`
+	ParentID   string     `json:"parentId" bson:"parentId"`
+	PostID     string     `json:"postId" bson:"postId"`
+	AuthorID   string     `json:"authorId" bson:"authorId,omitempty"`
+	AuthorName string     `json:"authorName" bson:"authorName,omitempty"`
+	PubTime    time.Time  `json:"pubTime" bson:"pubTime"`
+	EditedAt   *time.Time `json:"editedAt,omitempty" bson:"editedAt,omitempty"`
+	Content    string     `json:"content" bson:"content"`
+	Status     string     `json:"status" bson:"status"`
+	Deleted    bool       `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	Likes      int        `json:"likes" bson:"likes"`
+	Dislikes   int        `json:"dislikes" bson:"dislikes"`
+	Reports    int        `json:"reports,omitempty" bson:"reports,omitempty"`
+}
+
+// Reported - комментарий с жалобами читателей. Reasons - число жалоб
+// по каждой причине.
+type Reported struct {
+	Comment Comment        `json:"comment"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// Page - страница комментариев: корневые комментарии и все ответы на них
+// или комментарии автора. NextCursor пуст на последней странице.
+type Page struct {
+	Comments   []Comment
+	NextCursor string
+}
+
+// Revision - предыдущая версия текста комментария. Time - время, когда
+// эта версия была опубликована.
+type Revision struct {
+	Content string    `json:"content" bson:"content"`
+	Time    time.Time `json:"time" bson:"time"`
+}
+
+// Типы событий об изменении комментариев.
+const (
+	EventNew     = "new"
+	EventEdited  = "edited"
+	EventDeleted = "deleted"
+)
+
+// Event - событие об изменении комментария, которое видно читателям:
+// новый или одобренный комментарий, изменение текста, удаление или
+// отклонение модератором.
+type Event struct {
+	Type    string  `json:"type"`
+	Comment Comment `json:"comment"`
+}
+
+// ValidStatus проверяет, что переданная строка является статусом модерации.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusApproved, StatusRejected:
+		return true
+	}
+	return false
+}
+
+// ValidReaction проверяет, что переданная строка является реакцией.
+func ValidReaction(reaction string) bool {
+	return reaction == ReactionLike || reaction == ReactionDislike
+}
+
+// ValidReason проверяет, что переданная строка является причиной жалобы.
+func ValidReason(reason string) bool {
+	switch reason {
+	case ReasonSpam, ReasonAbuse, ReasonOffTopic, ReasonOther:
+		return true
+	}
+	return false
+}
+
+// Strip возвращает комментарий без текста, автора, реакций и жалоб. Так
+// удаленные и скрытые комментарии показываются читателям, чтобы заглушка
+// не раскрывала, кто и что написал.
+func Strip(com Comment) Comment {
+	com.Content = ""
+	com.AuthorID = ""
+	com.AuthorName = ""
+	com.Likes = 0
+	com.Dislikes = 0
+	com.Reports = 0
+	return com
+}
+
+// Hide сообщает, нужно ли скрыть одобренный комментарий до проверки
+// модератором после жалобы, с которой число жалоб стало равным reports.
+// Комментарий скрывается один раз, когда число жалоб достигает порога,
+// поэтому одобренный модератором комментарий новые жалобы не скрывают.
+// Порог 0 отключает скрытие.
+func Hide(status string, reports, threshold int) bool {
+	return threshold > 0 && reports == threshold && status != StatusPending && status != StatusRejected
+}
+
+// ReactionDelta возвращает изменение числа лайков и дизлайков комментария
+// при замене реакции пользователя prev на next. Пустая строка означает
+// отсутствие реакции.
+func ReactionDelta(prev, next string) (likes, dislikes int) {
+	count := func(reaction string) (int, int) {
+		switch reaction {
+		case ReactionLike:
+			return 1, 0
+		case ReactionDislike:
+			return 0, 1
+		}
+		return 0, 0
+	}
+	pl, pd := count(prev)
+	nl, nd := count(next)
+	return nl - pl, nd - pd
+}
+
+// ValidUserID проверяет ID пользователя: непустая строка не длиннее
+// 128 байт. Формат ID задает сервис авторизации, поэтому он не проверяется.
+func ValidUserID(user string) bool {
+	return user != "" && len(user) <= maxUserID
+}
+
+// ValidAuthor проверяет автора комментария: у анонимного комментария
+// нет ни ID, ни имени автора, имя автора не длиннее 64 символов.
+func ValidAuthor(id, name string) bool {
+	if id == "" {
+		return name == ""
+	}
+	return ValidUserID(id) && utf8.RuneCountInString(name) <= maxAuthorName
 }
 
 // Interface - интерфейс хранилища комментариев к постам.
@@ -32,5 +202,57 @@ type Comment struct {
 type DB interface {
 	AddComment(ctx context.Context, com Comment) (string, error)
 	Comments(ctx context.Context, post string) ([]Comment, error)
+	Threads(ctx context.Context, post string, limit int, cursor string, oldest bool) (Page, error)
+	AuthorComments(ctx context.Context, author string, limit int, cursor string) (Page, error)
+	Counts(ctx context.Context, posts []string) (map[string]int, error)
+	Subtree(ctx context.Context, id string, depth int) ([]Comment, error)
+	Comment(ctx context.Context, id string) (Comment, error)
+	Pending(ctx context.Context, limit int) ([]Comment, error)
+	SetStatus(ctx context.Context, id string, status string) (Comment, error)
+	UpdateComment(ctx context.Context, com Comment) (Comment, error)
+	History(ctx context.Context, id string) ([]Revision, error)
+	DeleteComment(ctx context.Context, id string) (Comment, error)
+	React(ctx context.Context, id, user, reaction string) (Comment, error)
+	Unreact(ctx context.Context, id, user string) (Comment, error)
+	Report(ctx context.Context, id, user, reason string, threshold int) (Comment, error)
+	Reported(ctx context.Context, limit int) ([]Reported, error)
 	Close() error
 }
+
+// NewID возвращает новый уникальный ID комментария. Все хранилища используют
+// формат ObjectID из MongoDB, чтобы ID не зависели от выбранного хранилища.
+func NewID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// ValidID проверяет, что переданная строка является ID в формате ObjectID.
+// ID постов и комментариев проверяются этой функцией во всех хранилищах.
+func ValidID(id string) bool {
+	_, err := primitive.ObjectIDFromHex(id)
+	return err == nil
+}
+
+// EncodeCursor возвращает курсор страницы, указывающий на комментарий
+// с переданными датой создания и ID. Дата сохраняется с точностью
+// до миллисекунды.
+func EncodeCursor(tm time.Time, id string) string {
+	s := strconv.FormatInt(tm.UnixMilli(), 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// DecodeCursor возвращает дату создания и ID комментария из курсора.
+func DecodeCursor(cursor string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrIncorrectCursor
+	}
+	ms, id, ok := strings.Cut(string(b), ":")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrIncorrectCursor
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrIncorrectCursor
+	}
+	return time.UnixMilli(n), id, nil
+}
@@ -0,0 +1,75 @@
+// Пакет содержит основную структуру Comment для работы с комментариями.
+
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	tm := time.UnixMilli(1726000000123)
+	id := "66e1a6b974aa2008e3b88e53"
+
+	gotTm, gotID, err := DecodeCursor(EncodeCursor(tm, id))
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	if !gotTm.Equal(tm) || gotID != id {
+		t.Errorf("DecodeCursor() = %v, %s, want %v, %s", gotTm, gotID, tm, id)
+	}
+
+	for _, cursor := range []string{"???", "MTIz", "YWJjOmRlZg"} {
+		if _, _, err := DecodeCursor(cursor); err == nil {
+			t.Errorf("DecodeCursor(%q) error = nil, want %v", cursor, ErrIncorrectCursor)
+		}
+	}
+}
+
+func TestReactionDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		prev     string
+		next     string
+		likes    int
+		dislikes int
+	}{
+		{name: "New_Like", next: ReactionLike, likes: 1},
+		{name: "Same", prev: ReactionLike, next: ReactionLike},
+		{name: "Like_To_Dislike", prev: ReactionLike, next: ReactionDislike, likes: -1, dislikes: 1},
+		{name: "Remove_Dislike", prev: ReactionDislike, dislikes: -1},
+		{name: "Nothing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			likes, dislikes := ReactionDelta(tt.prev, tt.next)
+			if likes != tt.likes || dislikes != tt.dislikes {
+				t.Errorf("ReactionDelta() = %d, %d, want %d, %d", likes, dislikes, tt.likes, tt.dislikes)
+			}
+		})
+	}
+}
+
+func TestValidAuthor(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		author string
+		want   bool
+	}{
+		{name: "Anonymous", want: true},
+		{name: "Author", id: "alice", author: "Алиса", want: true},
+		{name: "Without_Name", id: "alice", want: true},
+		{name: "Name_Without_ID", author: "Alice"},
+		{name: "Long_Name", id: "alice", author: strings.Repeat("я", maxAuthorName+1)},
+		{name: "Long_ID", id: strings.Repeat("a", maxUserID+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidAuthor(tt.id, tt.author); got != tt.want {
+				t.Errorf("ValidAuthor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,1020 @@
+// Пакет storagetest содержит общий набор тестов, который проверяет, что
+// реализация storage.DB ведет себя так же, как остальные хранилища:
+// возвращает те же ошибки из пакета storage, скрывает от читателей те же
+// комментарии и сортирует их в том же порядке.
+package storagetest
+
+import (
+	"GoExamComments/internal/storage"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory создает пустое хранилище для одного теста. Закрытие хранилища
+// и очистку данных фабрика регистрирует через t.Cleanup.
+type Factory func(t *testing.T) storage.DB
+
+// Run запускает общий набор тестов для хранилища, которое создает фабрика.
+// Каждый тест получает новое пустое хранилище.
+func Run(t *testing.T, newDB Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st storage.DB)
+	}{
+		{name: "AddComment", test: testAddComment},
+		{name: "Comments", test: testComments},
+		{name: "Comments_Hidden_Parent", test: testCommentsHiddenParent},
+		{name: "Threads", test: testThreads},
+		{name: "Threads_Errors", test: testThreadsErrors},
+		{name: "AuthorComments", test: testAuthorComments},
+		{name: "Subtree", test: testSubtree},
+		{name: "Comment", test: testComment},
+		{name: "Counts", test: testCounts},
+		{name: "Pending", test: testPending},
+		{name: "SetStatus", test: testSetStatus},
+		{name: "UpdateComment", test: testUpdateComment},
+		{name: "UpdateComment_Keeps_Status", test: testUpdateKeepsStatus},
+		{name: "UpdateDelete", test: testUpdateDelete},
+		{name: "DeleteComment", test: testDeleteComment},
+		{name: "History", test: testHistory},
+		{name: "React", test: testReact},
+		{name: "React_Concurrent", test: testReactConcurrent},
+		{name: "Report", test: testReport},
+		{name: "Reported", test: testReported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newDB(t))
+		})
+	}
+}
+
+// add записывает комментарий в хранилище и возвращает его ID. Перед
+// записью ждет одну миллисекунду, чтобы у комментариев различалось время
+// создания.
+func add(t *testing.T, st storage.DB, com storage.Comment) string {
+	t.Helper()
+	time.Sleep(time.Millisecond)
+	id, err := st.AddComment(context.Background(), com)
+	if err != nil {
+		t.Fatalf("AddComment() error = %v", err)
+	}
+	return id
+}
+
+// ids возвращает ID комментариев в порядке следования.
+func ids(comments []storage.Comment) []string {
+	list := make([]string, 0, len(comments))
+	for _, com := range comments {
+		list = append(list, com.ID)
+	}
+	return list
+}
+
+// wantErr проверяет, что ошибка соответствует ожидаемой.
+func wantErr(t *testing.T, method string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s() error = %v, wantErr %v", method, err, want)
+	}
+}
+
+// equal сравнивает два списка ID с учетом порядка.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testAddComment(t *testing.T, st storage.DB) {
+	post := storage.NewID()
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	hidden := add(t, st, storage.Comment{PostID: post, Content: "hidden", Status: storage.StatusPending})
+
+	tests := []struct {
+		name    string
+		com     storage.Comment
+		wantErr error
+	}{
+		{
+			name: "Root_OK",
+			com:  storage.Comment{PostID: post, Content: "root"},
+		},
+		{
+			name: "Reply_OK",
+			com:  storage.Comment{PostID: post, ParentID: root, Content: "reply"},
+		},
+		{
+			name: "Author_OK",
+			com:  storage.Comment{PostID: post, AuthorID: "alice", AuthorName: "Alice", Content: "root"},
+		},
+		{
+			name:    "Name_Without_Author",
+			com:     storage.Comment{PostID: post, AuthorName: "Alice", Content: "root"},
+			wantErr: storage.ErrIncorrectAuthor,
+		},
+		{
+			name:    "Incorrect_PostID",
+			com:     storage.Comment{PostID: "post", Content: "reply"},
+			wantErr: storage.ErrIncorrectPostID,
+		},
+		{
+			name:    "Incorrect_ParentID",
+			com:     storage.Comment{PostID: post, ParentID: "parent", Content: "reply"},
+			wantErr: storage.ErrIncorrectParentID,
+		},
+		{
+			name:    "Parent_Not_Found",
+			com:     storage.Comment{PostID: post, ParentID: storage.NewID(), Content: "reply"},
+			wantErr: storage.ErrParentNotFound,
+		},
+		{
+			name:    "Parent_Pending",
+			com:     storage.Comment{PostID: post, ParentID: hidden, Content: "reply"},
+			wantErr: storage.ErrParentNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := st.AddComment(context.Background(), tt.com)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddComment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !storage.ValidID(id) {
+				t.Errorf("AddComment() id = %q, want ObjectID", id)
+			}
+		})
+	}
+}
+
+func testComments(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	_, err := st.Comments(ctx, post)
+	if !errors.Is(err, storage.ErrNoComments) {
+		t.Fatalf("Comments() error = %v, wantErr %v", err, storage.ErrNoComments)
+	}
+	_, err = st.Comments(ctx, "post")
+	if !errors.Is(err, storage.ErrIncorrectPostID) {
+		t.Fatalf("Comments() error = %v, wantErr %v", err, storage.ErrIncorrectPostID)
+	}
+
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	reply := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	add(t, st, storage.Comment{PostID: post, ParentID: reply, Content: "hidden", Status: storage.StatusPending})
+	add(t, st, storage.Comment{PostID: storage.NewID(), Content: "other"})
+
+	got, err := st.Comments(ctx, post)
+	if err != nil {
+		t.Fatalf("Comments() error = %v", err)
+	}
+	// Видны только одобренные комментарии, сначала новые.
+	if want := []string{reply, root}; !equal(ids(got), want) {
+		t.Errorf("Comments() = %v, want %v", ids(got), want)
+	}
+}
+
+func testThreads(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	var roots []string
+	for i := 0; i < 3; i++ {
+		root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+		reply := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+		add(t, st, storage.Comment{PostID: post, ParentID: reply, Content: "reply"})
+		roots = append(roots, root)
+	}
+
+	page, err := st.Threads(ctx, post, 2, "", false)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if len(page.Comments) != 6 || page.NextCursor == "" {
+		t.Fatalf("Threads() len = %d, cursor = %q, want 6 comments and cursor", len(page.Comments), page.NextCursor)
+	}
+	if got := ids(page.Comments[:2]); !equal(got, []string{roots[2], roots[1]}) {
+		t.Errorf("Threads() roots = %v, want %v", got, []string{roots[2], roots[1]})
+	}
+
+	page, err = st.Threads(ctx, post, 2, page.NextCursor, false)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if len(page.Comments) != 3 || page.NextCursor != "" {
+		t.Errorf("Threads() len = %d, cursor = %q, want 3 comments and no cursor", len(page.Comments), page.NextCursor)
+	}
+	if got := ids(page.Comments[:1]); !equal(got, []string{roots[0]}) {
+		t.Errorf("Threads() roots = %v, want %v", got, []string{roots[0]})
+	}
+
+	page, err = st.Threads(ctx, post, 2, "", true)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if len(page.Comments) != 6 || page.NextCursor == "" {
+		t.Fatalf("Threads() len = %d, cursor = %q, want 6 comments and cursor", len(page.Comments), page.NextCursor)
+	}
+	if got := ids(page.Comments[:2]); !equal(got, []string{roots[0], roots[1]}) {
+		t.Errorf("Threads() roots = %v, want %v", got, []string{roots[0], roots[1]})
+	}
+
+	page, err = st.Threads(ctx, post, 2, page.NextCursor, true)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if len(page.Comments) != 3 || page.NextCursor != "" {
+		t.Fatalf("Threads() len = %d, cursor = %q, want 3 comments and no cursor", len(page.Comments), page.NextCursor)
+	}
+	if got := ids(page.Comments[:1]); !equal(got, []string{roots[2]}) {
+		t.Errorf("Threads() roots = %v, want %v", got, []string{roots[2]})
+	}
+
+	_, err = st.Threads(ctx, post, 2, "cursor", false)
+	if !errors.Is(err, storage.ErrIncorrectCursor) {
+		t.Errorf("Threads() error = %v, wantErr %v", err, storage.ErrIncorrectCursor)
+	}
+}
+
+func testAuthorComments(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	posts := []string{storage.NewID(), storage.NewID()}
+
+	root := add(t, st, storage.Comment{PostID: posts[0], AuthorID: "alice", AuthorName: "Alice", Content: "root"})
+	reply := add(t, st, storage.Comment{PostID: posts[0], ParentID: root, AuthorID: "alice", AuthorName: "Alice", Content: "reply"})
+	other := add(t, st, storage.Comment{PostID: posts[1], AuthorID: "alice", AuthorName: "Alice", Content: "other post"})
+	add(t, st, storage.Comment{PostID: posts[1], AuthorID: "alice", Content: "hidden", Status: storage.StatusPending})
+	deleted := add(t, st, storage.Comment{PostID: posts[1], AuthorID: "alice", Content: "deleted"})
+	add(t, st, storage.Comment{PostID: posts[1], AuthorID: "bob", Content: "bob"})
+	add(t, st, storage.Comment{PostID: posts[1], Content: "anonymous"})
+
+	_, err := st.DeleteComment(ctx, deleted)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+
+	// Видны только одобренные неудаленные комментарии автора ко всем
+	// постам, сначала новые.
+	page, err := st.AuthorComments(ctx, "alice", 2, "")
+	if err != nil {
+		t.Fatalf("AuthorComments() error = %v", err)
+	}
+	if want := []string{other, reply}; !equal(ids(page.Comments), want) || page.NextCursor == "" {
+		t.Fatalf("AuthorComments() = %v, cursor = %q, want %v and cursor", ids(page.Comments), page.NextCursor, want)
+	}
+	if com := page.Comments[0]; com.AuthorID != "alice" || com.AuthorName != "Alice" {
+		t.Errorf("AuthorComments() author = %q %q, want alice Alice", com.AuthorID, com.AuthorName)
+	}
+
+	page, err = st.AuthorComments(ctx, "alice", 2, page.NextCursor)
+	if err != nil {
+		t.Fatalf("AuthorComments() error = %v", err)
+	}
+	if want := []string{root}; !equal(ids(page.Comments), want) || page.NextCursor != "" {
+		t.Errorf("AuthorComments() = %v, cursor = %q, want %v and no cursor", ids(page.Comments), page.NextCursor, want)
+	}
+
+	page, err = st.AuthorComments(ctx, "carol", 2, "")
+	if err != nil || page.Comments == nil || len(page.Comments) != 0 {
+		t.Errorf("AuthorComments() = %v, error = %v, want empty page", page.Comments, err)
+	}
+
+	_, err = st.AuthorComments(ctx, "", 2, "")
+	wantErr(t, "AuthorComments", err, storage.ErrIncorrectUserID)
+	_, err = st.AuthorComments(ctx, "alice", 2, "cursor")
+	wantErr(t, "AuthorComments", err, storage.ErrIncorrectCursor)
+
+	// Правка текста не меняет автора.
+	upd, err := st.UpdateComment(ctx, storage.Comment{ID: root, Content: "edited"})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+	if upd.AuthorID != "alice" || upd.AuthorName != "Alice" {
+		t.Errorf("UpdateComment() author = %q %q, want alice Alice", upd.AuthorID, upd.AuthorName)
+	}
+}
+
+func testUpdateDelete(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "first"})
+
+	com, err := st.UpdateComment(ctx, storage.Comment{ID: id, Content: "second"})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+	if com.Content != "second" || com.EditedAt == nil {
+		t.Errorf("UpdateComment() = %+v, want new content and editedAt", com)
+	}
+
+	com, err = st.DeleteComment(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+	if !com.Deleted || com.Content != "" {
+		t.Errorf("DeleteComment() = %+v, want deleted comment without content", com)
+	}
+
+	_, err = st.UpdateComment(ctx, storage.Comment{ID: id, Content: "third"})
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("UpdateComment() error = %v, wantErr %v", err, storage.ErrCommentNotFound)
+	}
+	_, err = st.DeleteComment(ctx, id)
+	if !errors.Is(err, storage.ErrCommentNotFound) {
+		t.Errorf("DeleteComment() error = %v, wantErr %v", err, storage.ErrCommentNotFound)
+	}
+
+	revs, err := st.History(ctx, id)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(revs) != 2 || revs[0].Content != "first" || revs[1].Content != "second" {
+		t.Errorf("History() = %+v, want first and second revisions", revs)
+	}
+}
+
+func testCommentsHiddenParent(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	reply := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	leaf := add(t, st, storage.Comment{PostID: post, ParentID: reply, Content: "leaf"})
+
+	// Ответы на скрытый комментарий скрыты вместе с ним.
+	_, err := st.SetStatus(ctx, reply, storage.StatusPending)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	got, err := st.Comments(ctx, post)
+	if err != nil {
+		t.Fatalf("Comments() error = %v", err)
+	}
+	if want := []string{root}; !equal(ids(got), want) {
+		t.Errorf("Comments() = %v, want %v", ids(got), want)
+	}
+
+	page, err := st.Threads(ctx, post, 10, "", false)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if want := []string{root}; !equal(ids(page.Comments), want) {
+		t.Errorf("Threads() = %v, want %v", ids(page.Comments), want)
+	}
+
+	got, err = st.Subtree(ctx, root, 5)
+	if err != nil {
+		t.Fatalf("Subtree() error = %v", err)
+	}
+	if want := []string{root}; !equal(ids(got), want) {
+		t.Errorf("Subtree() = %v, want %v", ids(got), want)
+	}
+
+	// Удаленный комментарий, все живые ответы на который скрыты,
+	// не найден.
+	_, err = st.DeleteComment(ctx, root)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+	_, err = st.Subtree(ctx, root, 5)
+	wantErr(t, "Subtree", err, storage.ErrCommentNotFound)
+
+	// После одобрения ответы снова видны.
+	_, err = st.SetStatus(ctx, reply, storage.StatusApproved)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	got, err = st.Comments(ctx, post)
+	if err != nil {
+		t.Fatalf("Comments() error = %v", err)
+	}
+	if want := []string{leaf, reply, root}; !equal(ids(got), want) {
+		t.Errorf("Comments() = %v, want %v", ids(got), want)
+	}
+}
+
+func testThreadsErrors(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	_, err := st.Threads(ctx, post, 10, "", false)
+	wantErr(t, "Threads", err, storage.ErrNoComments)
+	_, err = st.Threads(ctx, "post", 10, "", false)
+	wantErr(t, "Threads", err, storage.ErrIncorrectPostID)
+
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	com, err := st.Subtree(ctx, root, 0)
+	if err != nil {
+		t.Fatalf("Subtree() error = %v", err)
+	}
+
+	// Страница после последней пуста, но не является ошибкой.
+	page, err := st.Threads(ctx, post, 10, storage.EncodeCursor(com[0].PubTime, root), false)
+	if err != nil {
+		t.Fatalf("Threads() error = %v", err)
+	}
+	if page.Comments == nil || len(page.Comments) != 0 || page.NextCursor != "" {
+		t.Errorf("Threads() = %+v, want empty page", page)
+	}
+
+	_, err = st.Threads(ctx, post, 10, storage.EncodeCursor(com[0].PubTime, "id"), false)
+	wantErr(t, "Threads", err, storage.ErrIncorrectCursor)
+}
+
+func testSubtree(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	first := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "first"})
+	second := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "second"})
+	leaf := add(t, st, storage.Comment{PostID: post, ParentID: first, Content: "leaf"})
+	add(t, st, storage.Comment{PostID: post, ParentID: leaf, Content: "deep"})
+	hidden := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "hidden", Status: storage.StatusPending})
+
+	tests := []struct {
+		name  string
+		id    string
+		depth int
+		want  []string
+	}{
+		{name: "Depth_0", id: root, depth: 0, want: []string{root}},
+		{name: "Depth_1", id: root, depth: 1, want: []string{root, second, first}},
+		{name: "Depth_2", id: root, depth: 2, want: []string{root, leaf, second, first}},
+		{name: "Reply", id: first, depth: 1, want: []string{first, leaf}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := st.Subtree(ctx, tt.id, tt.depth)
+			if err != nil {
+				t.Fatalf("Subtree() error = %v", err)
+			}
+			if !equal(ids(got), tt.want) {
+				t.Errorf("Subtree() = %v, want %v", ids(got), tt.want)
+			}
+		})
+	}
+
+	_, err := st.Subtree(ctx, hidden, 1)
+	wantErr(t, "Subtree", err, storage.ErrCommentNotFound)
+
+	// Удаленный комментарий возвращается, пока у него есть неудаленные
+	// ответы на любой глубине, даже если они глубже depth.
+	for _, id := range []string{first, leaf} {
+		_, err = st.DeleteComment(ctx, id)
+		if err != nil {
+			t.Fatalf("DeleteComment() error = %v", err)
+		}
+	}
+	got, err := st.Subtree(ctx, first, 0)
+	if err != nil || len(got) != 1 || !got[0].Deleted {
+		t.Errorf("Subtree() deleted with live replies = %v, %v, want deleted comment", got, err)
+	}
+	lone, err := st.DeleteComment(ctx, second)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+	_, err = st.Subtree(ctx, lone.ID, 1)
+	wantErr(t, "Subtree", err, storage.ErrCommentNotFound)
+
+	_, err = st.Subtree(ctx, storage.NewID(), 1)
+	wantErr(t, "Subtree", err, storage.ErrCommentNotFound)
+	_, err = st.Subtree(ctx, "id", 1)
+	wantErr(t, "Subtree", err, storage.ErrIncorrectCommentID)
+}
+
+func testCounts(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post, other, empty := storage.NewID(), storage.NewID(), storage.NewID()
+
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	add(t, st, storage.Comment{PostID: post, Content: "hidden", Status: storage.StatusPending})
+	deleted := add(t, st, storage.Comment{PostID: post, Content: "deleted"})
+	add(t, st, storage.Comment{PostID: other, Content: "other"})
+
+	_, err := st.DeleteComment(ctx, deleted)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+
+	got, err := st.Counts(ctx, []string{post, other, empty})
+	if err != nil {
+		t.Fatalf("Counts() error = %v", err)
+	}
+	want := map[string]int{post: 2, other: 1, empty: 0}
+	if len(got) != len(want) {
+		t.Errorf("Counts() = %v, want %v", got, want)
+	}
+	for post, count := range want {
+		if got[post] != count {
+			t.Errorf("Counts() = %v, want %v", got, want)
+			break
+		}
+	}
+
+	got, err = st.Counts(ctx, nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("Counts() = %v, %v, want empty map", got, err)
+	}
+
+	_, err = st.Counts(ctx, []string{post, "post"})
+	wantErr(t, "Counts", err, storage.ErrIncorrectPostID)
+}
+
+func testPending(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	got, err := st.Pending(ctx, 10)
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("Pending() = %v, want empty slice", got)
+	}
+
+	first := add(t, st, storage.Comment{PostID: post, Content: "first", Status: storage.StatusPending})
+	add(t, st, storage.Comment{PostID: post, Content: "approved"})
+	second := add(t, st, storage.Comment{PostID: post, Content: "second", Status: storage.StatusPending})
+	third := add(t, st, storage.Comment{PostID: post, Content: "third", Status: storage.StatusPending})
+
+	// Очередь модерации начинается с самых старых комментариев.
+	got, err = st.Pending(ctx, 10)
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if want := []string{first, second, third}; !equal(ids(got), want) {
+		t.Errorf("Pending() = %v, want %v", ids(got), want)
+	}
+
+	got, err = st.Pending(ctx, 2)
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if want := []string{first, second}; !equal(ids(got), want) {
+		t.Errorf("Pending() = %v, want %v", ids(got), want)
+	}
+}
+
+func testComment(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	before := time.Now().Add(-time.Second)
+	id := add(t, st, storage.Comment{PostID: post, AuthorID: "alice", AuthorName: "Alice", Content: "text", Status: storage.StatusPending})
+
+	// Комментарий возвращается и до проверки модератором.
+	com, err := st.Comment(ctx, id)
+	if err != nil {
+		t.Fatalf("Comment() error = %v", err)
+	}
+	if com.ID != id || com.PostID != post || com.AuthorID != "alice" || com.AuthorName != "Alice" ||
+		com.Content != "text" || com.Status != storage.StatusPending || com.PubTime.Before(before) {
+		t.Errorf("Comment() = %+v, want stored comment", com)
+	}
+
+	_, err = st.Comment(ctx, "id")
+	wantErr(t, "Comment", err, storage.ErrIncorrectCommentID)
+	_, err = st.Comment(ctx, storage.NewID())
+	wantErr(t, "Comment", err, storage.ErrCommentNotFound)
+}
+
+func testSetStatus(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text", Status: storage.StatusPending})
+
+	_, err := st.Comments(ctx, post)
+	wantErr(t, "Comments", err, storage.ErrNoComments)
+
+	com, err := st.SetStatus(ctx, id, storage.StatusApproved)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	if com.ID != id || com.Status != storage.StatusApproved || com.Content != "text" {
+		t.Errorf("SetStatus() = %+v, want approved comment", com)
+	}
+
+	got, err := st.Comments(ctx, post)
+	if err != nil || len(got) != 1 {
+		t.Errorf("Comments() = %v, %v, want approved comment", ids(got), err)
+	}
+
+	_, err = st.SetStatus(ctx, id, "status")
+	wantErr(t, "SetStatus", err, storage.ErrIncorrectStatus)
+	_, err = st.SetStatus(ctx, "id", storage.StatusApproved)
+	wantErr(t, "SetStatus", err, storage.ErrIncorrectCommentID)
+	_, err = st.SetStatus(ctx, storage.NewID(), storage.StatusApproved)
+	wantErr(t, "SetStatus", err, storage.ErrCommentNotFound)
+}
+
+func testUpdateComment(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text"})
+
+	com, err := st.UpdateComment(ctx, storage.Comment{ID: id, Content: "flagged", Status: storage.StatusPending})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+	if com.Status != storage.StatusPending || com.PostID != post {
+		t.Errorf("UpdateComment() = %+v, want pending comment", com)
+	}
+
+	tests := []struct {
+		name string
+		com  storage.Comment
+		want error
+	}{
+		{
+			name: "Empty_Content",
+			com:  storage.Comment{ID: id},
+			want: storage.ErrEmptyContent,
+		},
+		{
+			name: "Incorrect_Status",
+			com:  storage.Comment{ID: id, Content: "text", Status: "status"},
+			want: storage.ErrIncorrectStatus,
+		},
+		{
+			name: "Incorrect_ID",
+			com:  storage.Comment{ID: "id", Content: "text"},
+			want: storage.ErrIncorrectCommentID,
+		},
+		{
+			name: "Not_Found",
+			com:  storage.Comment{ID: storage.NewID(), Content: "text"},
+			want: storage.ErrCommentNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.UpdateComment(ctx, tt.com)
+			wantErr(t, "UpdateComment", err, tt.want)
+		})
+	}
+}
+
+func testUpdateKeepsStatus(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text"})
+
+	_, err := st.SetStatus(ctx, id, storage.StatusRejected)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	// Изменение текста без статуса не делает отклоненный комментарий
+	// видимым.
+	com, err := st.UpdateComment(ctx, storage.Comment{ID: id, Content: "edited"})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+	if com.Status != storage.StatusRejected || com.Content != "edited" {
+		t.Errorf("UpdateComment() = %+v, want rejected comment", com)
+	}
+	_, err = st.Comments(ctx, post)
+	wantErr(t, "Comments", err, storage.ErrNoComments)
+
+	// Одобренный комментарий остается одобренным, отмеченный текст
+	// отправляет его на проверку.
+	_, err = st.SetStatus(ctx, id, storage.StatusApproved)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	com, err = st.UpdateComment(ctx, storage.Comment{ID: id, Content: "fixed"})
+	if err != nil || com.Status != storage.StatusApproved {
+		t.Fatalf("UpdateComment() = %+v, %v, want approved comment", com, err)
+	}
+	com, err = st.UpdateComment(ctx, storage.Comment{ID: id, Content: "flagged", Status: storage.StatusPending})
+	if err != nil || com.Status != storage.StatusPending {
+		t.Errorf("UpdateComment() = %+v, %v, want pending comment", com, err)
+	}
+}
+
+func testDeleteComment(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	root := add(t, st, storage.Comment{PostID: post, Content: "root"})
+	reply := add(t, st, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+
+	_, err := st.DeleteComment(ctx, root)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+
+	// Удаленный комментарий остается в выборке, чтобы не потерять ответы,
+	// но отвечать на него нельзя.
+	got, err := st.Comments(ctx, post)
+	if err != nil {
+		t.Fatalf("Comments() error = %v", err)
+	}
+	if want := []string{reply, root}; !equal(ids(got), want) || !got[1].Deleted {
+		t.Errorf("Comments() = %+v, want reply and deleted root", got)
+	}
+	_, err = st.AddComment(ctx, storage.Comment{PostID: post, ParentID: root, Content: "reply"})
+	wantErr(t, "AddComment", err, storage.ErrParentNotFound)
+
+	_, err = st.DeleteComment(ctx, "id")
+	wantErr(t, "DeleteComment", err, storage.ErrIncorrectCommentID)
+	_, err = st.DeleteComment(ctx, storage.NewID())
+	wantErr(t, "DeleteComment", err, storage.ErrCommentNotFound)
+}
+
+func testHistory(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text"})
+
+	revs, err := st.History(ctx, id)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if revs == nil || len(revs) != 0 {
+		t.Errorf("History() = %v, want empty slice", revs)
+	}
+
+	com, err := st.Subtree(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("Subtree() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	upd, err := st.UpdateComment(ctx, storage.Comment{ID: id, Content: "first"})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	_, err = st.UpdateComment(ctx, storage.Comment{ID: id, Content: "second"})
+	if err != nil {
+		t.Fatalf("UpdateComment() error = %v", err)
+	}
+
+	// Время версии - время ее публикации: создания или предыдущего
+	// изменения.
+	revs, err = st.History(ctx, id)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(revs) != 2 ||
+		revs[0].Content != "text" || !revs[0].Time.Equal(com[0].PubTime) ||
+		revs[1].Content != "first" || !revs[1].Time.Equal(*upd.EditedAt) {
+		t.Errorf("History() = %+v, want text and first revisions", revs)
+	}
+
+	_, err = st.History(ctx, "id")
+	wantErr(t, "History", err, storage.ErrIncorrectCommentID)
+	_, err = st.History(ctx, storage.NewID())
+	wantErr(t, "History", err, storage.ErrCommentNotFound)
+}
+
+func testReact(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text"})
+
+	// Каждый шаг - реакция пользователя, пустая реакция удаляет ее.
+	steps := []struct {
+		user     string
+		reaction string
+		likes    int
+		dislikes int
+	}{
+		{user: "alice", reaction: storage.ReactionLike, likes: 1},
+		{user: "alice", reaction: storage.ReactionLike, likes: 1},
+		{user: "bob", reaction: storage.ReactionLike, likes: 2},
+		{user: "alice", reaction: storage.ReactionDislike, likes: 1, dislikes: 1},
+		{user: "bob", reaction: "", dislikes: 1},
+		{user: "bob", reaction: "", dislikes: 1},
+	}
+	for i, step := range steps {
+		var com storage.Comment
+		var err error
+		if step.reaction == "" {
+			com, err = st.Unreact(ctx, id, step.user)
+		} else {
+			com, err = st.React(ctx, id, step.user, step.reaction)
+		}
+		if err != nil {
+			t.Fatalf("step %d: error = %v", i, err)
+		}
+		if com.ID != id || com.Likes != step.likes || com.Dislikes != step.dislikes {
+			t.Errorf("step %d: comment = %+v, want likes %d, dislikes %d", i, com, step.likes, step.dislikes)
+		}
+	}
+
+	got, err := st.Comments(ctx, post)
+	if err != nil || len(got) != 1 || got[0].Likes != 0 || got[0].Dislikes != 1 {
+		t.Errorf("Comments() = %+v, %v, want counts in comment", got, err)
+	}
+
+	pending := add(t, st, storage.Comment{PostID: post, Content: "text", Status: storage.StatusPending})
+	_, err = st.React(ctx, pending, "alice", storage.ReactionLike)
+	wantErr(t, "React", err, storage.ErrCommentNotFound)
+	_, err = st.DeleteComment(ctx, id)
+	if err != nil {
+		t.Fatalf("DeleteComment() error = %v", err)
+	}
+	_, err = st.React(ctx, id, "alice", storage.ReactionLike)
+	wantErr(t, "React", err, storage.ErrCommentNotFound)
+	_, err = st.Unreact(ctx, id, "alice")
+	wantErr(t, "Unreact", err, storage.ErrCommentNotFound)
+
+	_, err = st.React(ctx, id, "alice", "love")
+	wantErr(t, "React", err, storage.ErrIncorrectReaction)
+	_, err = st.React(ctx, id, "", storage.ReactionLike)
+	wantErr(t, "React", err, storage.ErrIncorrectUserID)
+	_, err = st.React(ctx, "id", "alice", storage.ReactionLike)
+	wantErr(t, "React", err, storage.ErrIncorrectCommentID)
+	_, err = st.Unreact(ctx, storage.NewID(), "alice")
+	wantErr(t, "Unreact", err, storage.ErrCommentNotFound)
+}
+
+// testReactConcurrent проверяет, что одновременные реакции не теряют
+// изменения счетчиков.
+func testReactConcurrent(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	id := add(t, st, storage.Comment{PostID: storage.NewID(), Content: "text"})
+
+	const users = 20
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			// Каждый пользователь несколько раз меняет реакцию и в итоге
+			// ставит лайк.
+			for _, reaction := range []string{storage.ReactionDislike, storage.ReactionLike, storage.ReactionDislike, storage.ReactionLike} {
+				_, err := st.React(ctx, id, user, reaction)
+				if err != nil {
+					t.Errorf("React() error = %v", err)
+					return
+				}
+			}
+		}("user-" + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	got, err := st.Subtree(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("Subtree() error = %v", err)
+	}
+	if got[0].Likes != users || got[0].Dislikes != 0 {
+		t.Errorf("Subtree() = %+v, want %d likes and no dislikes", got[0], users)
+	}
+}
+
+func testReport(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+	id := add(t, st, storage.Comment{PostID: post, Content: "text"})
+
+	com, err := st.Report(ctx, id, "alice", storage.ReasonSpam, 2)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if com.ID != id || com.Reports != 1 || com.Status != storage.StatusApproved {
+		t.Errorf("Report() = %+v, want one report", com)
+	}
+	_, err = st.Report(ctx, id, "alice", storage.ReasonAbuse, 2)
+	wantErr(t, "Report", err, storage.ErrAlreadyReported)
+
+	// Комментарий скрывается, когда число жалоб достигает порога.
+	com, err = st.Report(ctx, id, "bob", storage.ReasonAbuse, 2)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if com.Reports != 2 || com.Status != storage.StatusPending {
+		t.Errorf("Report() = %+v, want pending comment with two reports", com)
+	}
+	_, err = st.Comments(ctx, post)
+	wantErr(t, "Comments", err, storage.ErrNoComments)
+	_, err = st.Report(ctx, id, "carol", storage.ReasonSpam, 2)
+	wantErr(t, "Report", err, storage.ErrCommentNotFound)
+
+	// Одобренный модератором комментарий новые жалобы не скрывают.
+	_, err = st.SetStatus(ctx, id, storage.StatusApproved)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	com, err = st.Report(ctx, id, "carol", storage.ReasonSpam, 2)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if com.Reports != 3 || com.Status != storage.StatusApproved {
+		t.Errorf("Report() = %+v, want approved comment with three reports", com)
+	}
+
+	// Без порога комментарий не скрывается.
+	other := add(t, st, storage.Comment{PostID: post, Content: "text"})
+	com, err = st.Report(ctx, other, "alice", storage.ReasonOther, 0)
+	if err != nil || com.Status != storage.StatusApproved {
+		t.Errorf("Report() = %+v, %v, want approved comment", com, err)
+	}
+
+	_, err = st.Report(ctx, id, "dave", "boring", 2)
+	wantErr(t, "Report", err, storage.ErrIncorrectReason)
+	_, err = st.Report(ctx, id, "", storage.ReasonSpam, 2)
+	wantErr(t, "Report", err, storage.ErrIncorrectUserID)
+	_, err = st.Report(ctx, "id", "dave", storage.ReasonSpam, 2)
+	wantErr(t, "Report", err, storage.ErrIncorrectCommentID)
+	_, err = st.Report(ctx, storage.NewID(), "dave", storage.ReasonSpam, 2)
+	wantErr(t, "Report", err, storage.ErrCommentNotFound)
+}
+
+func testReported(t *testing.T, st storage.DB) {
+	ctx := context.Background()
+	post := storage.NewID()
+
+	list, err := st.Reported(ctx, 10)
+	if err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("Reported() = %v, %v, want empty slice", list, err)
+	}
+
+	first := add(t, st, storage.Comment{PostID: post, Content: "first"})
+	second := add(t, st, storage.Comment{PostID: post, Content: "second"})
+	third := add(t, st, storage.Comment{PostID: post, Content: "third"})
+	rejected := add(t, st, storage.Comment{PostID: post, Content: "rejected"})
+	add(t, st, storage.Comment{PostID: post, Content: "clean"})
+
+	reports := []struct {
+		id     string
+		user   string
+		reason string
+	}{
+		{first, "alice", storage.ReasonSpam},
+		{second, "alice", storage.ReasonSpam},
+		{second, "bob", storage.ReasonSpam},
+		{second, "carol", storage.ReasonAbuse},
+		{third, "alice", storage.ReasonOffTopic},
+		{rejected, "alice", storage.ReasonAbuse},
+	}
+	for _, r := range reports {
+		_, err = st.Report(ctx, r.id, r.user, r.reason, 0)
+		if err != nil {
+			t.Fatalf("Report() error = %v", err)
+		}
+	}
+	_, err = st.SetStatus(ctx, rejected, storage.StatusRejected)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	// Сначала больше жалоб, при равенстве сначала новые.
+	list, err = st.Reported(ctx, 10)
+	if err != nil {
+		t.Fatalf("Reported() error = %v", err)
+	}
+	got := make([]string, 0, len(list))
+	for _, r := range list {
+		got = append(got, r.Comment.ID)
+	}
+	if want := []string{second, third, first}; !equal(got, want) {
+		t.Fatalf("Reported() = %v, want %v", got, want)
+	}
+	if r := list[0].Reasons; len(r) != 2 || r[storage.ReasonSpam] != 2 || r[storage.ReasonAbuse] != 1 {
+		t.Errorf("Reported() reasons = %v, want 2 spam and 1 abuse", r)
+	}
+
+	list, err = st.Reported(ctx, 1)
+	if err != nil || len(list) != 1 || list[0].Comment.ID != second {
+		t.Errorf("Reported() with limit = %v, %v, want %s", list, err, second)
+	}
+
+	// Одобренный комментарий возвращается в список только с новыми жалобами.
+	_, err = st.SetStatus(ctx, second, storage.StatusApproved)
+	if err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	list, err = st.Reported(ctx, 10)
+	if err != nil {
+		t.Fatalf("Reported() error = %v", err)
+	}
+	got = got[:0]
+	for _, r := range list {
+		got = append(got, r.Comment.ID)
+	}
+	if want := []string{third, first}; !equal(got, want) {
+		t.Fatalf("Reported() after approve = %v, want %v", got, want)
+	}
+	_, err = st.Report(ctx, second, "dave", storage.ReasonOffTopic, 0)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	list, err = st.Reported(ctx, 10)
+	if err != nil || len(list) != 3 || list[0].Comment.ID != second {
+		t.Errorf("Reported() after new report = %v, %v, want %s first", list, err, second)
+	}
+}
@@ -0,0 +1,110 @@
+package tree
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+)
+
+// Order - порядок сортировки комментариев в дереве.
+type Order int
+
+// Возможные порядки сортировки.
+const (
+	// Newest - сначала новые комментарии.
+	Newest Order = iota
+	// Oldest - сначала старые комментарии.
+	Oldest
+	// Replies - сначала комментарии с наибольшим числом ответов на всех
+	// уровнях вложенности. При равенстве сначала новые.
+	Replies
+	// Top - сначала комментарии с наибольшей оценкой по реакциям
+	// читателей. При равенстве сначала новые.
+	Top
+)
+
+// z - квантиль нормального распределения для доверительного интервала
+// 95% в оценке Уилсона.
+const z = 1.96
+
+// ErrUnknownOrder - неизвестный порядок сортировки.
+var ErrUnknownOrder = errors.New("unknown sort order")
+
+// ParseOrder возвращает порядок сортировки по его названию: "new", "old",
+// "replies" или "top". Пустая строка соответствует порядку Newest.
+func ParseOrder(s string) (Order, error) {
+	switch s {
+	case "", "new":
+		return Newest, nil
+	case "old":
+		return Oldest, nil
+	case "replies":
+		return Replies, nil
+	case "top":
+		return Top, nil
+	default:
+		return Newest, ErrUnknownOrder
+	}
+}
+
+// Sort сортирует переданный слайс комментариев и ответы на них на всех
+// уровнях вложенности в переданном порядке.
+func Sort(arr []*Node, order Order) {
+	var counts map[*Node]int
+	if order == Replies {
+		counts = make(map[*Node]int)
+		for _, node := range arr {
+			countReplies(node, counts)
+		}
+	}
+	sortNodes(arr, order, counts)
+}
+
+// sortNodes сортирует переданный слайс и рекурсивно вызывает саму себя
+// на вложенных слайсах. counts содержит число ответов для порядка Replies.
+func sortNodes(arr []*Node, order Order, counts map[*Node]int) {
+	slices.SortStableFunc(arr, func(a, b *Node) int {
+		switch order {
+		case Oldest:
+			return cmp.Or(a.PubTime.Compare(b.PubTime), cmp.Compare(a.ID, b.ID))
+		case Replies:
+			if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+				return c
+			}
+		case Top:
+			if c := cmp.Compare(Score(b.Likes, b.Dislikes), Score(a.Likes, a.Dislikes)); c != 0 {
+				return c
+			}
+		}
+		return cmp.Or(b.PubTime.Compare(a.PubTime), cmp.Compare(b.ID, a.ID))
+	})
+	for _, node := range arr {
+		sortNodes(node.Childs, order, counts)
+	}
+}
+
+// countReplies записывает в карту число ответов на комментарий на всех
+// уровнях вложенности для него и всех его потомков. Возвращает число
+// ответов на переданный комментарий.
+func countReplies(node *Node, counts map[*Node]int) int {
+	var count int
+	for _, child := range node.Childs {
+		count += countReplies(child, counts) + 1
+	}
+	counts[node] = count
+	return count
+}
+
+// Score возвращает нижнюю границу доверительного интервала Уилсона для доли
+// лайков среди реакций. Комментарий с одним лайком получает меньшую оценку,
+// чем комментарий с сотней лайков и парой дизлайков. Без реакций оценка
+// равна нулю.
+func Score(likes, dislikes int) float64 {
+	n := float64(likes + dislikes)
+	if n <= 0 {
+		return 0
+	}
+	p := float64(likes) / n
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
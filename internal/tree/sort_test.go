@@ -0,0 +1,116 @@
+// Пакет tree содержит инструменты для создания и сортировки дерева комментариев.
+
+package tree
+
+import (
+	"GoExamComments/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestSort(t *testing.T) {
+	tm := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	comms := []storage.Comment{
+		{ID: "com-1", PubTime: tm, Likes: 1},
+		{ID: "com-2", PubTime: tm.Add(time.Minute), Likes: 100, Dislikes: 2},
+		{ID: "com-3", PubTime: tm.Add(2 * time.Minute)},
+		{ID: "com-4", ParentID: "com-1", PubTime: tm.Add(3 * time.Minute), Likes: 5},
+		{ID: "com-5", ParentID: "com-1", PubTime: tm.Add(4 * time.Minute)},
+		{ID: "com-6", ParentID: "com-2", PubTime: tm.Add(5 * time.Minute)},
+		{ID: "com-7", ParentID: "com-4", PubTime: tm.Add(6 * time.Minute)},
+		{ID: "com-8", ParentID: "com-5", PubTime: tm.Add(7 * time.Minute)},
+		{ID: "com-9", ParentID: "com-5", PubTime: tm.Add(8 * time.Minute)},
+	}
+
+	tests := []struct {
+		name   string
+		order  string
+		roots  []string
+		childs []string
+	}{
+		{
+			name:   "Newest",
+			order:  "new",
+			roots:  []string{"com-3", "com-2", "com-1"},
+			childs: []string{"com-5", "com-4"},
+		},
+		{
+			name:   "Oldest",
+			order:  "old",
+			roots:  []string{"com-1", "com-2", "com-3"},
+			childs: []string{"com-4", "com-5"},
+		},
+		{
+			name:   "Replies",
+			order:  "replies",
+			roots:  []string{"com-1", "com-2", "com-3"},
+			childs: []string{"com-5", "com-4"},
+		},
+		{
+			name:   "Top",
+			order:  "top",
+			roots:  []string{"com-2", "com-1", "com-3"},
+			childs: []string{"com-4", "com-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := ParseOrder(tt.order)
+			if err != nil {
+				t.Fatalf("ParseOrder() error = %v", err)
+			}
+			root, err := Build(comms, Drop)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+
+			Sort(root.Comments, order)
+
+			for i, id := range tt.roots {
+				if root.Comments[i].ID != id {
+					t.Errorf("Sort() roots[%d] = %s, want %s", i, root.Comments[i].ID, id)
+				}
+			}
+			com1 := root.Comments[0]
+			for _, node := range root.Comments {
+				if node.ID == "com-1" {
+					com1 = node
+				}
+			}
+			for i, id := range tt.childs {
+				if com1.Childs[i].ID != id {
+					t.Errorf("Sort() childs[%d] = %s, want %s", i, com1.Childs[i].ID, id)
+				}
+			}
+		})
+	}
+
+	if _, err := ParseOrder("best"); err == nil {
+		t.Errorf("ParseOrder() error = nil, want %v", ErrUnknownOrder)
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		better [2]int
+		worse  [2]int
+	}{
+		{name: "More_Votes", better: [2]int{100, 2}, worse: [2]int{1, 0}},
+		{name: "Higher_Share", better: [2]int{10, 1}, worse: [2]int{10, 5}},
+		{name: "Any_Like", better: [2]int{1, 0}, worse: [2]int{0, 0}},
+		{name: "No_Votes", better: [2]int{0, 0}, worse: [2]int{0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Score(tt.better[0], tt.better[1])
+			w := Score(tt.worse[0], tt.worse[1])
+			if b < w {
+				t.Errorf("Score(%v) = %f < Score(%v) = %f", tt.better, b, tt.worse, w)
+			}
+		})
+	}
+	if got := Score(0, 0); got != 0 {
+		t.Errorf("Score(0, 0) = %f, want 0", got)
+	}
+}
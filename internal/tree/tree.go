@@ -3,37 +3,213 @@ package tree
 
 import (
 	"GoExamComments/internal/storage"
+	"cmp"
 	"errors"
 	"fmt"
+	"slices"
 )
 
-// Root - структура дерева комментариев к посту.
+// Root - структура дерева комментариев к посту. Orphans содержит отчет
+// о поддеревьях, родителя которых не оказалось среди комментариев.
 type Root struct {
 	Comments []*Node
+	Orphans  []Orphan
 }
 
-// Node - структура узла дерева комментариев.
+// Orphan - поддеревья комментариев с отсутствующим родителем. IDs - ID
+// ответов на отсутствующий комментарий, Count - общее число комментариев
+// в этих поддеревьях.
+type Orphan struct {
+	ParentID string
+	IDs      []string
+	Count    int
+}
+
+// Policy - способ обработки поддеревьев с отсутствующим родителем.
+type Policy int
+
+// Возможные способы обработки поддеревьев с отсутствующим родителем.
+const (
+	// Drop - поддеревья не попадают в дерево.
+	Drop Policy = iota
+	// Promote - ответы на отсутствующий комментарий становятся корневыми.
+	Promote
+	// Tombstone - ответы прикрепляются к заглушке удаленного комментария
+	// на месте отсутствующего родителя.
+	Tombstone
+)
+
+// Node - структура узла дерева комментариев. More заполняется вместо
+// Childs, если ответы скрыты функцией Truncate.
 type Node struct {
 	storage.Comment
 	Childs []*Node `json:"childs"`
+	More   *More   `json:"more,omitempty"`
+}
+
+// More - заглушка на месте скрытых ответов. ParentID - ID комментария,
+// с которого можно продолжить загрузку ответов, Count - число скрытых
+// ответов на всех уровнях вложенности.
+type More struct {
+	ParentID string `json:"parentId"`
+	Count    int    `json:"count"`
 }
 
 var (
 	// ErrEmptySlice - пустой слайс комментариев.
 	ErrEmptySlice = errors.New("empty comment array")
+	// ErrNodeNotFound - комментария с переданным ID нет в слайсе.
+	ErrNodeNotFound = errors.New("comment not found in array")
+	// ErrUnknownPolicy - неизвестный способ обработки поддеревьев
+	// с отсутствующим родителем.
+	ErrUnknownPolicy = errors.New("unknown orphan policy")
 )
 
-// Build строит полное дерево комментариев из переданного слайса.
-func Build(comments []storage.Comment) (Root, error) {
+// ParsePolicy возвращает способ обработки поддеревьев с отсутствующим
+// родителем по его названию: "drop", "promote" или "tombstone". Пустая
+// строка соответствует способу Drop.
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "drop":
+		return Drop, nil
+	case "promote":
+		return Promote, nil
+	case "tombstone":
+		return Tombstone, nil
+	default:
+		return Drop, ErrUnknownPolicy
+	}
+}
+
+// DeletedContent - текст, который показывается вместо удаленного комментария.
+const DeletedContent = "comment deleted"
+
+// Build строит полное дерево комментариев из переданного слайса. Удаленные
+// комментарии остаются в дереве, только если у них есть неудаленные ответы.
+// Поддеревья, родителя которых нет в слайсе, попадают в отчет Root.Orphans
+// и обрабатываются переданным способом policy.
+func Build(comments []storage.Comment, policy Policy) (Root, error) {
 	const operation = "tree.Build"
 
-	m := make(map[string]*Node)
 	root := Root{}
 
 	if len(comments) == 0 {
 		return root, fmt.Errorf("%s: %w", operation, ErrEmptySlice)
 	}
 
+	var m map[string]*Node
+	root.Comments, m = link(comments)
+
+	// Узлы без ID - заглушки для родителей, которых нет в слайсе.
+	for id, node := range m {
+		if node.ID != "" {
+			continue
+		}
+
+		orphan := Orphan{ParentID: id, Count: countHidden(node.Childs)}
+		for _, child := range node.Childs {
+			orphan.IDs = append(orphan.IDs, child.ID)
+		}
+		root.Orphans = append(root.Orphans, orphan)
+
+		switch policy {
+		case Promote:
+			root.Comments = append(root.Comments, node.Childs...)
+		case Tombstone:
+			// Дата заглушки - дата самого раннего ответа, чтобы при
+			// сортировке она оказалась рядом с ответами.
+			first := slices.MinFunc(node.Childs, func(a, b *Node) int {
+				return a.PubTime.Compare(b.PubTime)
+			})
+			node.Comment = storage.Comment{
+				ID:      id,
+				PostID:  first.PostID,
+				PubTime: first.PubTime,
+				Deleted: true,
+			}
+			root.Comments = append(root.Comments, node)
+		}
+	}
+	slices.SortFunc(root.Orphans, func(a, b Orphan) int {
+		return cmp.Compare(a.ParentID, b.ParentID)
+	})
+
+	root.Comments = prune(root.Comments)
+	if root.Comments == nil {
+		root.Comments = []*Node{}
+	}
+
+	return root, nil
+}
+
+// Subtree строит из переданного слайса дерево ответов на комментарий
+// с переданным ID и возвращает узел этого комментария. Родитель комментария
+// в слайсе не нужен.
+func Subtree(comments []storage.Comment, id string) (*Node, error) {
+	const operation = "tree.Subtree"
+
+	if len(comments) == 0 {
+		return nil, fmt.Errorf("%s: %w", operation, ErrEmptySlice)
+	}
+
+	_, m := link(comments)
+
+	// Узел без ID - заглушка для родителя, которого нет в слайсе.
+	node, ok := m[id]
+	if !ok || node.ID == "" {
+		return nil, fmt.Errorf("%s: %w", operation, ErrNodeNotFound)
+	}
+
+	node.Childs = prune(node.Childs)
+	if node.Deleted {
+		node.Comment = storage.Strip(node.Comment)
+		node.Content = DeletedContent
+	}
+
+	return node, nil
+}
+
+// Truncate ограничивает глубину дерева maxDepth уровнями, корневые
+// комментарии находятся на первом уровне. Ответы на комментарии последнего
+// уровня убираются из дерева, вместо них в узел записывается заглушка
+// More с числом скрытых ответов. При maxDepth меньше 1 дерево не меняется.
+func Truncate(arr []*Node, maxDepth int) {
+	if maxDepth < 1 {
+		return
+	}
+	for _, node := range arr {
+		if maxDepth > 1 {
+			Truncate(node.Childs, maxDepth-1)
+			continue
+		}
+		if len(node.Childs) == 0 {
+			continue
+		}
+		node.More = &More{ParentID: node.ID, Count: countHidden(node.Childs)}
+		node.Childs = nil
+	}
+}
+
+// countHidden возвращает число узлов в переданном слайсе на всех уровнях
+// вложенности, включая уже скрытые заглушками.
+func countHidden(arr []*Node) int {
+	var count int
+	for _, node := range arr {
+		count++
+		count += countHidden(node.Childs)
+		if node.More != nil {
+			count += node.More.Count
+		}
+	}
+	return count
+}
+
+// link связывает комментарии из переданного слайса в деревья. Возвращает
+// слайс корневых комментариев и карту всех узлов по ID комментария.
+func link(comments []storage.Comment) ([]*Node, map[string]*Node) {
+	var roots []*Node
+	m := make(map[string]*Node)
+
 	for _, comment := range comments {
 		node := &Node{Comment: comment}
 
@@ -44,7 +220,7 @@ func Build(comments []storage.Comment) (Root, error) {
 		m[comment.ID] = node
 
 		if comment.ParentID == "" {
-			root.Comments = append(root.Comments, node)
+			roots = append(roots, node)
 			continue
 		}
 
@@ -58,7 +234,29 @@ func Build(comments []storage.Comment) (Root, error) {
 		m[comment.ParentID].Childs = append(m[comment.ParentID].Childs, node)
 	}
 
-	return root, nil
+	return roots, m
+}
+
+// prune убирает из переданного слайса удаленные комментарии, у которых
+// не осталось неудаленных потомков. Оставшиеся удаленные комментарии
+// заменяет заглушками без автора и реакций с текстом DeletedContent.
+// Рекурсивно вызывает саму себя на вложенных слайсах.
+func prune(arr []*Node) []*Node {
+	var kept []*Node
+	for _, node := range arr {
+		node.Childs = prune(node.Childs)
+		if !node.Deleted {
+			kept = append(kept, node)
+			continue
+		}
+		if len(node.Childs) == 0 {
+			continue
+		}
+		node.Comment = storage.Strip(node.Comment)
+		node.Content = DeletedContent
+		kept = append(kept, node)
+	}
+	return kept
 }
 
 // traverseRoot обходит все дерево комментариев в глубину. Выводит содержимое
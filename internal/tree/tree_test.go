@@ -22,9 +22,220 @@ var comments = []storage.Comment{
 
 func TestBuild(t *testing.T) {
 	want := len(comments)
-	root, _ := Build(comments)
+	root, _ := Build(comments, Drop)
 	count := traverseRoot(root)
 	if count != want {
 		t.Errorf("Build() error, len = %d, want %d", count, want)
 	}
 }
+
+func TestBuild_Deleted(t *testing.T) {
+	comms := []storage.Comment{
+		{ID: "com-6", ParentID: "com-5", Deleted: true},
+		{ID: "com-5", ParentID: "com-4", Deleted: true},
+		{ID: "com-4", ParentID: "com-1"},
+		{ID: "com-3", ParentID: "com-2"},
+		{ID: "com-2", ParentID: "", Deleted: true},
+		{ID: "com-1", ParentID: "", Deleted: true, AuthorID: "user-1", AuthorName: "Alice", Likes: 2, Dislikes: 1},
+		{ID: "com-0", ParentID: "", Deleted: true},
+	}
+
+	root, err := Build(comms, Drop)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	// Удаленные com-0, com-5 и com-6 не имеют неудаленных потомков.
+	want := 4
+	count := traverseRoot(root)
+	if count != want {
+		t.Errorf("Build() error, len = %d, want %d", count, want)
+	}
+	for _, node := range root.Comments {
+		if node.Content != DeletedContent {
+			t.Errorf("Build() content = %q, want %q", node.Content, DeletedContent)
+		}
+		if node.AuthorID != "" || node.AuthorName != "" || node.Likes != 0 || node.Dislikes != 0 {
+			t.Errorf("Build() tombstone = %+v, want no author and reactions", node.Comment)
+		}
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "Subtree_OK",
+			id:      "com-3",
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name:    "Leaf_OK",
+			id:      "com-10",
+			want:    0,
+			wantErr: false,
+		},
+		{
+			name:    "Not_Found",
+			id:      "com-11",
+			want:    0,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := Subtree(comments, tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Subtree() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if node.ID != tt.id {
+				t.Errorf("Subtree() id = %s, want %s", node.ID, tt.id)
+			}
+			count := traverse(node.Childs)
+			if count != tt.want {
+				t.Errorf("Subtree() error, len = %d, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtree_Deleted(t *testing.T) {
+	comms := []storage.Comment{
+		{ID: "com-2", ParentID: "com-1"},
+		{ID: "com-1", Deleted: true, AuthorID: "user-1", AuthorName: "Alice", Likes: 2, Dislikes: 1},
+	}
+
+	node, err := Subtree(comms, "com-1")
+	if err != nil {
+		t.Fatalf("Subtree() error = %v", err)
+	}
+	if node.Content != DeletedContent || node.AuthorID != "" || node.AuthorName != "" || node.Likes != 0 || node.Dislikes != 0 {
+		t.Errorf("Subtree() = %+v, want tombstone without author and reactions", node.Comment)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		want     int
+		hidden   int
+	}{
+		{
+			name:     "Roots_Only",
+			maxDepth: 1,
+			want:     3,
+			hidden:   7,
+		},
+		{
+			name:     "Two_Levels",
+			maxDepth: 2,
+			want:     6,
+			hidden:   4,
+		},
+		{
+			name:     "No_Limit",
+			maxDepth: 0,
+			want:     10,
+			hidden:   0,
+		},
+		{
+			name:     "Deeper_Than_Tree",
+			maxDepth: 10,
+			want:     10,
+			hidden:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _ := Build(comments, Drop)
+			Truncate(root.Comments, tt.maxDepth)
+
+			count := traverseRoot(root)
+			if count != tt.want {
+				t.Errorf("Truncate() error, len = %d, want %d", count, tt.want)
+			}
+			hidden := countHidden(root.Comments) - count
+			if hidden != tt.hidden {
+				t.Errorf("Truncate() error, hidden = %d, want %d", hidden, tt.hidden)
+			}
+		})
+	}
+}
+
+func TestBuild_Orphans(t *testing.T) {
+	comms := []storage.Comment{
+		{ID: "com-5", ParentID: "com-4"},
+		{ID: "com-4", ParentID: "com-missing"},
+		{ID: "com-3", ParentID: "com-missing"},
+		{ID: "com-2", ParentID: "com-1"},
+		{ID: "com-1", ParentID: ""},
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		roots  int
+		want   int
+	}{
+		{
+			name:   "Drop",
+			policy: "drop",
+			roots:  1,
+			want:   2,
+		},
+		{
+			name:   "Promote",
+			policy: "promote",
+			roots:  3,
+			want:   5,
+		},
+		{
+			name:   "Tombstone",
+			policy: "tombstone",
+			roots:  2,
+			want:   6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParsePolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("ParsePolicy() error = %v", err)
+			}
+
+			root, err := Build(comms, policy)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+
+			if len(root.Orphans) != 1 {
+				t.Fatalf("Build() orphans = %d, want %d", len(root.Orphans), 1)
+			}
+			orphan := root.Orphans[0]
+			if orphan.ParentID != "com-missing" || orphan.Count != 3 || len(orphan.IDs) != 2 {
+				t.Errorf("Build() orphan = %+v, want parent %s with %d comments", orphan, "com-missing", 3)
+			}
+
+			if len(root.Comments) != tt.roots {
+				t.Errorf("Build() roots = %d, want %d", len(root.Comments), tt.roots)
+			}
+			count := traverseRoot(root)
+			if count != tt.want {
+				t.Errorf("Build() error, len = %d, want %d", count, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParsePolicy("keep"); err == nil {
+		t.Errorf("ParsePolicy() error = nil, want %v", ErrUnknownPolicy)
+	}
+}